@@ -51,7 +51,10 @@ require (
 	google.golang.org/protobuf v1.36.4
 )
 
-require cosmossdk.io/schema v1.0.0
+require (
+	cosmossdk.io/core/testing v0.0.1
+	cosmossdk.io/schema v1.0.0
+)
 
 require (
 	buf.build/gen/go/cometbft/cometbft/protocolbuffers/go v1.36.4-20241120201313-68e42a58b301.1 // indirect
@@ -63,7 +66,6 @@ require (
 	cloud.google.com/go/iam v1.3.1 // indirect
 	cloud.google.com/go/storage v1.43.0 // indirect
 	cosmossdk.io/collections v1.1.0 // indirect
-	cosmossdk.io/core/testing v0.0.1 // indirect
 	cosmossdk.io/errors v1.0.1 // indirect
 	cosmossdk.io/errors/v2 v2.0.0 // indirect
 	cosmossdk.io/server/v2/stf v1.0.0-beta.2 // indirect
@@ -0,0 +1,90 @@
+package simapp
+
+import (
+	"maps"
+	"runtime/metrics"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	// blocksAccount is the account of the block execution outside of the transactions, begin and end blockers
+	// included, and of the commit.
+	blocksAccount = "blocks"
+	// simsAccount is the account of the message factories and the transaction building of the simulation.
+	simsAccount = "sims"
+)
+
+// cost is the time and the heap allocations spent in an account.
+type cost struct {
+	time   time.Duration
+	bytes  uint64
+	allocs uint64
+}
+
+func (c cost) add(o cost) cost {
+	return cost{time: c.time + o.time, bytes: c.bytes + o.bytes, allocs: c.allocs + o.allocs}
+}
+
+func (c cost) sub(o cost) cost {
+	return cost{time: c.time - o.time, bytes: c.bytes - o.bytes, allocs: c.allocs - o.allocs}
+}
+
+// ModuleAccounting accumulates the time and the heap allocations spent delivering the messages of each module, the
+// blocks and the simulation itself.
+//
+// The heap allocations are read from the runtime metrics, so they include the allocations of any other goroutine
+// running meanwhile.
+type ModuleAccounting struct {
+	costs   map[string]cost
+	nested  cost
+	samples []metrics.Sample
+}
+
+// NewModuleAccounting constructor
+func NewModuleAccounting() *ModuleAccounting {
+	return &ModuleAccounting{
+		costs: make(map[string]cost),
+		samples: []metrics.Sample{
+			{Name: "/gc/heap/allocs:bytes"},
+			{Name: "/gc/heap/allocs:objects"},
+		},
+	}
+}
+
+// begin starts to measure the cost of the given account, until the returned function is called. The cost of
+// the measures nested in between is not added to the account. It is a no-op on a nil ModuleAccounting.
+func (a *ModuleAccounting) begin(account string) (end func()) {
+	if a == nil {
+		return func() {}
+	}
+	outer := a.nested
+	a.nested = cost{}
+	start := a.read()
+	return func() {
+		total := a.read().sub(start)
+		a.costs[account] = a.costs[account].add(total.sub(a.nested))
+		a.nested = outer.add(total)
+	}
+}
+
+func (a *ModuleAccounting) read() cost {
+	metrics.Read(a.samples)
+	return cost{
+		time:   time.Duration(time.Now().UnixNano()),
+		bytes:  a.samples[0].Value.Uint64(),
+		allocs: a.samples[1].Value.Uint64(),
+	}
+}
+
+// ReportMetrics reports the time and the heap allocations of each account per benchmark iteration.
+func (a *ModuleAccounting) ReportMetrics(b *testing.B) {
+	b.Helper()
+	for _, account := range slices.Sorted(maps.Keys(a.costs)) {
+		c := a.costs[account]
+		b.ReportMetric(float64(c.time.Nanoseconds())/float64(b.N), account+"-ns/op")
+		b.ReportMetric(float64(c.bytes)/float64(b.N), account+"-B/op")
+		b.ReportMetric(float64(c.allocs)/float64(b.N), account+"-allocs/op")
+	}
+}
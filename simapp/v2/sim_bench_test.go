@@ -3,10 +3,19 @@
 package simapp
 
 import (
-	"github.com/cosmos/cosmos-sdk/x/simulation/client/cli"
+	"bytes"
 	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	banktypes "cosmossdk.io/x/bank/types"
+
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+	"github.com/cosmos/cosmos-sdk/x/simulation/client/cli"
 )
 
+// BenchmarkFullAppSimulation runs a full app simulation, the operations can be restricted to some modules
+// with the -Modules flag, e.g. -Modules=bank,staking.
 func BenchmarkFullAppSimulation(b *testing.B) {
 	b.ReportAllocs()
 	cfg := cli.NewConfigFromFlags()
@@ -15,3 +24,64 @@ func BenchmarkFullAppSimulation(b *testing.B) {
 		RunWithSeed[Tx](b, NewSimApp[Tx], AppConfig, cfg, 1)
 	}
 }
+
+// accountingB is a testing.B accounting the cost of the simulation per module.
+type accountingB struct {
+	*testing.B
+	accounting *ModuleAccounting
+}
+
+func (b accountingB) ModuleAccounting() *ModuleAccounting {
+	return b.accounting
+}
+
+// BenchmarkFullAppSimulationPerModule runs a full app simulation and reports the time and the heap allocations
+// spent delivering the messages of each module, the blocks and the simulation itself.
+func BenchmarkFullAppSimulationPerModule(b *testing.B) {
+	b.ReportAllocs()
+	cfg := cli.NewConfigFromFlags()
+	cfg.ChainID = SimAppChainID
+	accounting := NewModuleAccounting()
+	for i := 0; i < b.N; i++ {
+		RunWithSeed[Tx](accountingB{B: b, accounting: accounting}, NewSimApp[Tx], AppConfig, cfg, 1)
+	}
+	accounting.ReportMetrics(b)
+}
+
+// BenchmarkHistoricalGet builds the state of a simulation on IAVL v2 trees pruned to the recent versions, and
+// reports the time and the allocations of reading a bank key at the earliest version retained by the pruning,
+// which is served by a readonly clone of the tree. The pruning interval defaults to 10 blocks unless set with
+// the -PruneInterval flag.
+func BenchmarkHistoricalGet(b *testing.B) {
+	cfg := cli.NewConfigFromFlags()
+	cfg.ChainID = SimAppChainID
+	if cfg.PruneInterval == 0 {
+		cfg.PruneInterval = 10
+	}
+	RunWithSeed[Tx](b, NewSimApp[Tx], AppConfig, cfg, 1, func(tb testing.TB, _ ChainState[Tx], app TestInstance[Tx], _ []simtypes.Account) {
+		rs := app.App.Store()
+		latest, state, err := rs.StateLatest()
+		require.NoError(tb, err)
+		require.Greater(tb, latest, uint64(pruneKeepRecent), "not enough blocks")
+		version := latest - pruneKeepRecent
+
+		storeKey := []byte(banktypes.StoreKey)
+		reader, err := state.GetReader(storeKey)
+		require.NoError(tb, err)
+		it, err := reader.Iterator(nil, nil)
+		require.NoError(tb, err)
+		require.True(tb, it.Valid(), "no state to read in store %s", storeKey)
+		key := bytes.Clone(it.Key())
+		require.NoError(tb, it.Close())
+
+		sc := rs.GetStateCommitment()
+		b.Run("get", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := sc.Get(storeKey, version, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
@@ -3,11 +3,20 @@
 package simapp
 
 import (
-	simsxv2 "github.com/cosmos/cosmos-sdk/simsx/v2"
+	"bytes"
+	"flag"
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
 	simcli "github.com/cosmos/cosmos-sdk/x/simulation/client/cli"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
+var flagFuzzNumBlocksValue uint64
+
+func init() {
+	flag.Uint64Var(&flagFuzzNumBlocksValue, "FuzzNumBlocks", 10, "number of blocks simulated per seed by FuzzAppSimulation")
+}
+
 func FuzzFullAppSimulation(f *testing.F) {
 	cfg := simcli.NewConfigFromFlags()
 	cfg.ChainID = SimAppChainID
@@ -17,7 +26,27 @@ func FuzzFullAppSimulation(f *testing.F) {
 			t.Skip()
 			return
 		}
-		randSource := simsxv2.NewByteSource(cfg.FuzzSeed, cfg.Seed)
-		RunWithRandSource[Tx](t, NewSimApp[Tx], AppConfig, cfg, randSource)
+		RunWithReader[Tx](t, NewSimApp[Tx], AppConfig, cfg, bytes.NewReader(rawSeed))
+	})
+}
+
+// FuzzAppSimulation runs a short simulation for each fuzzed seed, twice to detect non-determinism.
+// The number of blocks simulated per seed is set with the -FuzzNumBlocks flag.
+func FuzzAppSimulation(f *testing.F) {
+	cfg := simcli.NewConfigFromFlags()
+	cfg.ChainID = SimAppChainID
+	cfg.NumBlocks = flagFuzzNumBlocksValue
+	for _, seed := range DefaultSeeds[:4] {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, seed int64) {
+		var appHashes [2][]byte
+		for i := range appHashes {
+			RunWithSeed[Tx](t, NewSimApp[Tx], AppConfig, cfg, seed, func(_ testing.TB, cs ChainState[Tx], _ TestInstance[Tx], _ []simtypes.Account) {
+				appHashes[i] = cs.AppHash
+			})
+		}
+		require.Equal(t, appHashes[0], appHashes[1], "non-determinism in seed %d", seed)
 	})
 }
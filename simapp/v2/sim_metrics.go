@@ -0,0 +1,100 @@
+package simapp
+
+import (
+	"encoding/csv"
+	"os"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metricsFileMtx serializes the writes to the metrics files of parallel runs.
+var metricsFileMtx sync.Mutex
+
+// metricsHeader is the header of the CSV metrics file.
+var metricsHeader = []string{"chain_id", "seed", "blocks", "txs", "blocks_per_sec", "avg_commit_ms", "peak_memory_bytes"}
+
+// runMetrics collects the performance metrics of a simulation run.
+//
+// The memory is read from the runtime metrics after each block, so the peak memory is the peak of the samples
+// and includes the memory of any other goroutine running meanwhile.
+type runMetrics struct {
+	start      time.Time
+	elapsed    time.Duration
+	blocks     int
+	txs        int
+	commitTime time.Duration
+	peakMemory uint64
+	samples    []metrics.Sample
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{
+		start: time.Now(),
+		samples: []metrics.Sample{
+			{Name: "/memory/classes/total:bytes"},
+			{Name: "/memory/classes/heap/released:bytes"},
+		},
+	}
+}
+
+// addBlock records a block with the given number of delivered transactions and commit duration.
+func (m *runMetrics) addBlock(txs int, commitTime time.Duration) {
+	m.blocks++
+	m.txs += txs
+	m.commitTime += commitTime
+	metrics.Read(m.samples)
+	m.peakMemory = max(m.peakMemory, m.samples[0].Value.Uint64()-m.samples[1].Value.Uint64())
+	m.elapsed = time.Since(m.start)
+}
+
+// record returns the CSV record of the metrics.
+func (m *runMetrics) record(chainID string, seed int64) []string {
+	var blocksPerSec, avgCommitMs float64
+	if m.elapsed > 0 {
+		blocksPerSec = float64(m.blocks) / m.elapsed.Seconds()
+	}
+	if m.blocks > 0 {
+		avgCommitMs = float64(m.commitTime.Microseconds()) / 1000 / float64(m.blocks)
+	}
+	return []string{
+		chainID,
+		strconv.FormatInt(seed, 10),
+		strconv.Itoa(m.blocks),
+		strconv.Itoa(m.txs),
+		strconv.FormatFloat(blocksPerSec, 'f', 3, 64),
+		strconv.FormatFloat(avgCommitMs, 'f', 3, 64),
+		strconv.FormatUint(m.peakMemory, 10),
+	}
+}
+
+// writeCSV appends the CSV record of the metrics to the file at path, the header is written first when the file
+// is new or empty.
+func (m *runMetrics) writeCSV(path, chainID string, seed int64) error {
+	metricsFileMtx.Lock()
+	defer metricsFileMtx.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		if err := w.Write(metricsHeader); err != nil {
+			return err
+		}
+	}
+	if err := w.Write(m.record(chainID, seed)); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,33 @@
+package simapp
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMetricsWriteCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.csv")
+	m := newRunMetrics()
+	m.addBlock(3, 2*time.Millisecond)
+	m.addBlock(1, 4*time.Millisecond)
+	require.NoError(t, m.writeCSV(path, "sims-chain", 1))
+	require.NoError(t, m.writeCSV(path, "sims-chain", 2))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, metricsHeader, records[0])
+	assert.Equal(t, []string{"sims-chain", "1", "2", "4"}, records[1][:4])
+	assert.Equal(t, "3.000", records[1][5])
+	assert.NotEqual(t, "0", records[1][6])
+	assert.Equal(t, "2", records[2][1])
+}
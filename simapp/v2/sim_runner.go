@@ -1,9 +1,12 @@
 package simapp
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"iter"
 	"maps"
 	"math/rand"
@@ -30,8 +33,12 @@ import (
 	"cosmossdk.io/schema/appdata"
 	"cosmossdk.io/server/v2/appmanager"
 	"cosmossdk.io/server/v2/cometbft"
+	serverstore "cosmossdk.io/server/v2/store"
 	"cosmossdk.io/server/v2/streaming"
 	storev2 "cosmossdk.io/store/v2"
+	"cosmossdk.io/store/v2/commitment/iavlv2"
+	"cosmossdk.io/store/v2/root"
+	banktypes "cosmossdk.io/x/bank/types"
 	consensustypes "cosmossdk.io/x/consensus/types"
 
 	"github.com/cosmos/cosmos-sdk/client"
@@ -39,6 +46,7 @@ import (
 	"github.com/cosmos/cosmos-sdk/simsx"
 	simsxv2 "github.com/cosmos/cosmos-sdk/simsx/v2"
 	simtestutil "github.com/cosmos/cosmos-sdk/testutil/sims"
+	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/cosmos/cosmos-sdk/types/module"
 	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
 	"github.com/cosmos/cosmos-sdk/x/simulation"
@@ -49,12 +57,16 @@ type Tx = transaction.Tx
 type (
 	HasWeightedOperationsX              = simsx.HasWeightedOperationsX
 	HasWeightedOperationsXWithProposals = simsx.HasWeightedOperationsXWithProposals
+	HasAdversarialOperationsX           = simsx.HasAdversarialOperationsX
 	HasProposalMsgsX                    = simsx.HasProposalMsgsX
 	HasLegacyProposalMsgs               = simsx.HasLegacyProposalMsgs
 )
 
 const SimAppChainID = "simulation-app"
 
+// pruneKeepRecent is the number of recent versions kept when the state commitment is pruned by the simulation.
+const pruneKeepRecent = 2
+
 // DefaultSeeds list of seeds was imported from the original simulation runner: https://github.com/cosmos/tools/blob/v1.0.0/cmd/runsim/main.go#L32
 var DefaultSeeds = []int64{
 	1, 2, 4, 7,
@@ -136,10 +148,56 @@ func SetupTestInstance[T Tx, V SimulationApp[T]](
 	appConfigFactory AppConfigFactory,
 	randSource simsxv2.RandSource,
 	dbBackend string,
+) TestInstance[T] {
+	tb.Helper()
+	return setupTestInstance[T, V](tb, appFactory, appConfigFactory, randSource, map[string]any{"store.app-db-backend": dbBackend})
+}
+
+// checkpointIntervalSetting is the store setting of the checkpoint interval of the IAVL v2 trees.
+const checkpointIntervalSetting = "store.options.iavl-v2-config.checkpoint-interval"
+
+// storeSettings returns the store settings of the app for the given simulation config.
+// When pruning is enabled, the state commitment uses IAVL v2 trees, keeping the last pruneKeepRecent versions.
+// The trees are checkpointed at every version, as IAVL v2 only deletes the versions up to a checkpoint, so that
+// exactly the versions up to the pruned height are deleted.
+func storeSettings(tCfg simtypes.Config) map[string]any {
+	settings := map[string]any{"store.app-db-backend": tCfg.DBBackend}
+	if tCfg.PruneInterval != 0 {
+		settings["store.options.sc-type"] = string(root.SCTypeIavlV2)
+		settings["store.options.sc-pruning-option.keep-recent"] = pruneKeepRecent
+		settings["store.options.sc-pruning-option.interval"] = tCfg.PruneInterval
+		settings[checkpointIntervalSetting] = 1
+	}
+	return settings
+}
+
+// OverrideCheckpointInterval sets the checkpoint interval of the IAVL v2 trees of the given global config, e.g. set
+// by storeSettings, in the root store config, as ProvideRootStoreConfig resets the IAVL v2 config to its defaults.
+// It is invoked by the simulations before the store is built.
+func OverrideCheckpointInterval(cfg *root.Config, config runtime.GlobalConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	settings, err := serverstore.UnmarshalConfig(config)
+	if err != nil {
+		return err
+	}
+	cfg.Options.IavlV2Config.CheckpointInterval = settings.Options.IavlV2Config.CheckpointInterval
+	return nil
+}
+
+func setupTestInstance[T Tx, V SimulationApp[T]](
+	tb testing.TB,
+	appFactory AppFactory[T, V],
+	appConfigFactory AppConfigFactory,
+	randSource simsxv2.RandSource,
+	settings map[string]any,
 ) TestInstance[T] {
 	tb.Helper()
 	vp := viper.New()
-	vp.Set("store.app-db-backend", dbBackend)
+	for key, value := range settings {
+		vp.Set(key, value)
+	}
 	vp.Set("home", tb.TempDir())
 
 	depInjCfg := depinject.Configs(
@@ -159,7 +217,11 @@ func SetupTestInstance[T Tx, V SimulationApp[T]](
 	)
 	require.NoError(tb, err)
 
-	xapp, err := appFactory(depinject.Configs(depinject.Supply(log.NewNopLogger(), runtime.GlobalConfig(vp.AllSettings()))))
+	appConfigs := []depinject.Config{depinject.Supply(log.NewNopLogger(), runtime.GlobalConfig(vp.AllSettings()))}
+	if _, ok := settings[checkpointIntervalSetting]; ok {
+		appConfigs = append(appConfigs, depinject.Invoke(OverrideCheckpointInterval))
+	}
+	xapp, err := appFactory(depinject.Configs(appConfigs...))
 	require.NoError(tb, err)
 	return TestInstance[T]{
 		RandSource:    randSource,
@@ -217,9 +279,12 @@ func RunWithSeeds[T Tx, V SimulationApp[T]](
 	t.Helper()
 	cfg := cli.NewConfigFromFlags()
 	cfg.ChainID = SimAppChainID
-	for _, seed := range seeds {
+	corpus, err := simsx.OpenSeedCorpus(cfg.SeedCorpusPath)
+	require.NoError(t, err)
+	for _, seed := range corpus.Seeds(seeds) {
 		t.Run(fmt.Sprintf("seed: %d", seed), func(t *testing.T) {
 			t.Parallel()
+			corpus.AddOnFailure(t, seed)
 			RunWithSeed(t, appFactory, appConfigFactory, cfg, seed, postRunActions...)
 		})
 	}
@@ -238,6 +303,21 @@ func RunWithSeed[T Tx, V SimulationApp[T]](
 	RunWithRandSource(tb, appFactory, appConfigFactory, tCfg, simsxv2.NewSeededRandSource(seed), postRunActions...)
 }
 
+// RunWithReader initializes and executes a simulation run taking all its random choices from the data read from r, so
+// that an external driver such as a fuzzer controls the exact stream of decisions. Once r is exhausted, the random
+// choices are taken from the seed of the config.
+func RunWithReader[T Tx, V SimulationApp[T]](
+	tb testing.TB,
+	appFactory AppFactory[T, V],
+	appConfigFactory AppConfigFactory,
+	tCfg simtypes.Config,
+	r io.Reader,
+	postRunActions ...func(t testing.TB, cs ChainState[T], app TestInstance[T], accs []simtypes.Account),
+) {
+	tb.Helper()
+	RunWithRandSource(tb, appFactory, appConfigFactory, tCfg, simsxv2.NewReaderSource(r, tCfg.Seed), postRunActions...)
+}
+
 // RunWithRandSource initializes and executes a simulation run with the given rand source, generating blocks and transactions.
 func RunWithRandSource[T Tx, V SimulationApp[T]](
 	tb testing.TB,
@@ -252,7 +332,7 @@ func RunWithRandSource[T Tx, V SimulationApp[T]](
 	require.NotEmpty(tb, initialBlockHeight, "initial block height must not be 0")
 
 	setupFn := func(ctx context.Context, r *rand.Rand) (TestInstance[T], ChainState[T], []simtypes.Account) {
-		testInstance := SetupTestInstance[T, V](tb, appFactory, appConfigFactory, randSource, tCfg.DBBackend)
+		testInstance := setupTestInstance[T, V](tb, appFactory, appConfigFactory, randSource, storeSettings(tCfg))
 		accounts, genesisAppState, chainID, genesisTimestamp := prepareInitialGenesisState(
 			testInstance.App,
 			r,
@@ -298,7 +378,10 @@ func RunWithRandSourceX[T Tx](
 	}
 
 	modules := testInstance.ModuleManager.Modules()
-	msgFactoriesFn := prepareSimsMsgFactories(tb, r, modules, simsx.ParamWeightSource(customFactoryParams))
+	for _, name := range tCfg.Modules {
+		require.Contains(tb, modules, name, "unknown simulated module")
+	}
+	msgFactoriesFn := prepareSimsMsgFactories(tb, r, modules, simsx.ParamWeightSource(customFactoryParams), tCfg.SimulatesModule, tCfg.AdversarialRate)
 
 	if b, ok := tb.(interface{ ResetTimer() }); ok {
 		b.ResetTimer()
@@ -436,8 +519,31 @@ func doMainLoop[T Tx](
 	)
 	rootReporter := simsx.NewBasicSimulationReporter()
 	futureOpsReg := simsxv2.NewFutureOpsRegistry()
+	// benchmarks can account the cost of the simulation per module
+	var accounting *ModuleAccounting
+	if x, ok := tb.(interface{ ModuleAccounting() *ModuleAccounting }); ok {
+		accounting = x.ModuleAccounting()
+	}
+	perf := newRunMetrics()
+	seed := tCfg.Seed
+	if src, ok := testInstance.RandSource.(*simsxv2.SeededRandomSource); ok {
+		seed = src.GetSeed()
+	}
+	var diff *stateDiff
+	if tCfg.StateDiffPath != "" {
+		var err error
+		diff, err = newStateDiff(seedPath(tCfg.StateDiffPath, seed))
+		require.NoError(tb, err, "state diff")
+		defer func() {
+			require.NoError(tb, diff.Close(), "state diff")
+		}()
+	}
 
 	for end := cs.BlockHeight + numBlocks; cs.BlockHeight < end; cs.BlockHeight++ {
+		if tCfg.MaxDuration > 0 && time.Since(perf.start) >= tCfg.MaxDuration {
+			fmt.Printf("Time limit of %s reached after %d blocks at height %d\n", tCfg.MaxDuration, perf.blocks, cs.BlockHeight)
+			break
+		}
 		if len(cs.ActiveValidatorSet) == 0 {
 			tb.Skipf("run out of validators in block: %d\n", cs.BlockHeight)
 			return
@@ -465,6 +571,7 @@ func doMainLoop[T Tx](
 		simsCtx := context.WithValue(rootCtx, corecontext.CometInfoKey, cometInfo) // required for ContextAwareCometInfoService
 		resultHandlers := make([]simsx.SimDeliveryResultHandler, 0, maxTXPerBlock)
 		var txPerBlockCounter int
+		endBlock := accounting.begin(blocksAccount)
 		blockRsp, updates, err := testInstance.App.DeliverSims(simsCtx, blockReqN, func(ctx context.Context) iter.Seq[T] {
 			return func(yield func(T) bool) {
 				unbondingTime, err := testInstance.StakingKeeper.UnbondingTime(ctx)
@@ -488,7 +595,9 @@ func doMainLoop[T Tx](
 					}
 
 					// the stf context is required to access state via keepers
+					endSims := accounting.begin(simsAccount)
 					signers, msg := mergedMsgFactory.Create()(ctx, testData, reporter)
+					endSims()
 					if reporter.IsSkipped() {
 						txSkippedCounter++
 						require.NoError(tb, reporter.Close())
@@ -498,10 +607,16 @@ func doMainLoop[T Tx](
 					reporter.Success(msg)
 					require.NoError(tb, reporter.Close())
 
+					endSims = accounting.begin(simsAccount)
 					tx, err := testInstance.TXBuilder.Build(ctx, testInstance.AuthKeeper, signers, msg, r, cs.ChainID)
+					endSims()
 					require.NoError(tb, err)
 					blockReqN.Txs = append(blockReqN.Txs, tx)
-					if !yield(tx) {
+					// the transaction is delivered by yield
+					endTx := accounting.begin(sdk.GetModuleNameFromTypeURL(sdk.MsgTypeURL(msg)))
+					more := yield(tx)
+					endTx()
+					if !more {
 						return
 					}
 				}
@@ -510,12 +625,27 @@ func doMainLoop[T Tx](
 		require.NoError(tb, err, "%d, %s", blockReqN.Height, blockReqN.Time)
 		changeSet, err := updates.GetStateChanges()
 		require.NoError(tb, err)
+		if diff != nil {
+			_, prev, err := testInstance.App.Store().StateLatest()
+			require.NoError(tb, err)
+			require.NoError(tb, diff.write(blockReqN.Height, prev, changeSet), "state diff")
+		}
+		commitStart := time.Now()
 		cs.AppHash, err = testInstance.App.Store().Commit(&store.Changeset{
 			Version: blockReqN.Height,
 			Changes: changeSet,
 		})
+		commitTime := time.Since(commitStart)
+		endBlock()
 
 		require.NoError(tb, err)
+		if exp, ok := tCfg.AppHashCheckpoints[blockReqN.Height]; ok {
+			require.Equal(tb, hex.EncodeToString(exp), hex.EncodeToString(cs.AppHash), "app hash at height %d", blockReqN.Height)
+		}
+		perf.addBlock(len(blockRsp.TxResults), commitTime)
+		if tCfg.PruneInterval != 0 {
+			requirePruned(tb, testInstance.App.Store(), blockReqN.Height, tCfg.PruneInterval)
+		}
 		require.Equal(tb, len(resultHandlers), len(blockRsp.TxResults), "txPerBlockCounter: %d, totalSkipped: %d", txPerBlockCounter, txSkippedCounter)
 		for i, v := range blockRsp.TxResults {
 			require.NoError(tb, resultHandlers[i](v.Error))
@@ -545,11 +675,55 @@ func doMainLoop[T Tx](
 	}
 	fmt.Println("+++ reporter:\n" + rootReporter.Summary().String())
 	fmt.Printf("Tx total: %d skipped: %d\n", txTotalCounter, txSkippedCounter)
+	if tCfg.ExportMetricsPath != "" {
+		require.NoError(tb, perf.writeCSV(tCfg.ExportMetricsPath, cs.ChainID, seed), "export metrics")
+	}
 }
 
-// prepareSimsMsgFactories constructs and returns a function to retrieve simulation message factories for all modules.
+// requirePruned checks, at the heights where the state commitment has been pruned, that the state of the pruned
+// version is gone, its reads failing with ErrVersionPruned, and that the reads of the retained versions succeed.
+func requirePruned(tb testing.TB, rs storev2.RootStore, height, interval uint64) {
+	tb.Helper()
+	pruned, pruneTo := (&storev2.PruningOption{KeepRecent: pruneKeepRecent, Interval: interval}).ShouldPrune(height)
+	// version 0 is read as the latest version, there is nothing pruned to check
+	if !pruned || pruneTo == 0 {
+		return
+	}
+	storeKey := []byte(banktypes.StoreKey)
+	_, state, err := rs.StateLatest()
+	require.NoError(tb, err)
+	reader, err := state.GetReader(storeKey)
+	require.NoError(tb, err)
+	it, err := reader.Iterator(nil, nil)
+	require.NoError(tb, err)
+	require.True(tb, it.Valid(), "no state to read in store %s", storeKey)
+	key := bytes.Clone(it.Key())
+	require.NoError(tb, it.Close())
+
+	sc := rs.GetStateCommitment()
+	_, err = sc.Get(storeKey, pruneTo, key)
+	require.ErrorIs(tb, err, iavlv2.ErrVersionPruned, "read of pruned version %d at height %d", pruneTo, height)
+	_, err = rs.StateAt(pruneTo)
+	require.Error(tb, err, "state of pruned version %d at height %d", pruneTo, height)
+	for v := pruneTo + 1; v <= height; v++ {
+		value, err := sc.Get(storeKey, v, key)
+		require.NoError(tb, err, "read of retained version %d at height %d", v, height)
+		require.NotNil(tb, value, "read of retained version %d at height %d", v, height)
+	}
+}
+
+// prepareSimsMsgFactories constructs and returns a function to retrieve simulation message factories for the simulated modules.
 // It initializes proposal and factory registries, registers proposals and weighted operations, and sorts deterministically.
-func prepareSimsMsgFactories(tb testing.TB, r *rand.Rand, modules map[string]appmodulev2.AppModule, weights simsx.WeightSource) func() simsx.SimMsgFactoryX {
+// The proposal messages of all modules are registered, so that they can still be submitted by the simulated modules.
+// The adversarial factories of the simulated modules are mixed in with the adversarial rate, if any.
+func prepareSimsMsgFactories(
+	tb testing.TB,
+	r *rand.Rand,
+	modules map[string]appmodulev2.AppModule,
+	weights simsx.WeightSource,
+	simulated func(name string) bool,
+	adversarialRate float64,
+) func() simsx.SimMsgFactoryX {
 	tb.Helper()
 	moduleNames := slices.Collect(maps.Keys(modules))
 	slices.Sort(moduleNames) // make deterministic
@@ -566,7 +740,14 @@ func prepareSimsMsgFactories(tb testing.TB, r *rand.Rand, modules map[string]app
 	}
 	// register all msg factories
 	factoryRegistry := simsx.NewUnorderedRegistry()
+	adversarialRegistry := simsx.NewUnorderedRegistry()
 	for _, n := range moduleNames {
+		if !simulated(n) {
+			continue
+		}
+		if xm, ok := modules[n].(HasAdversarialOperationsX); ok {
+			xm.AdversarialOperationsX(weights, adversarialRegistry)
+		}
 		switch xm := modules[n].(type) {
 		case HasWeightedOperationsX:
 			xm.WeightedOperationsX(weights, factoryRegistry)
@@ -574,7 +755,9 @@ func prepareSimsMsgFactories(tb testing.TB, r *rand.Rand, modules map[string]app
 			xm.WeightedOperationsX(weights, factoryRegistry, proposalRegistry.Iterator(), nil)
 		}
 	}
-	return simsxv2.NextFactoryFn(factoryRegistry.Elements(), r)
+	factories, err := simsx.MixAdversarialFactories(factoryRegistry.Elements(), adversarialRegistry.Elements(), adversarialRate)
+	require.NoError(tb, err)
+	return simsxv2.NextFactoryFn(factories, r)
 }
 
 func toLegacySimsModule(modules map[string]appmodule.AppModule) []module.AppModuleSimulation {
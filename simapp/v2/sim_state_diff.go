@@ -0,0 +1,102 @@
+package simapp
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cosmossdk.io/core/store"
+)
+
+// stateDiffMaxChanges is the maximum number of changes of a block written to the state diff, so that a block
+// rewriting a large part of the state does not produce an unbounded diff.
+const stateDiffMaxChanges = 10_000
+
+// stateChange is a JSON line of the state diff: the change of a key of a store at a height, or the number of
+// changes of the height left out once stateDiffMaxChanges is reached. Keys and values are hex encoded.
+type stateChange struct {
+	Height    uint64 `json:"height"`
+	Store     string `json:"store,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Old       string `json:"old,omitempty"`
+	New       string `json:"new,omitempty"`
+	Delete    bool   `json:"delete,omitempty"`
+	Truncated int    `json:"truncated,omitempty"`
+}
+
+// stateDiff writes the state changes of each block of a simulation to a file as JSON lines. The changes are
+// streamed to the file as they are read, so that the diff is never held in memory.
+type stateDiff struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newStateDiff(path string) (*stateDiff, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &stateDiff{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// write writes the changes of the block at the given height, the old values being read from prev, the state
+// before the block.
+func (d *stateDiff) write(height uint64, prev store.ReaderMap, changes []store.StateChanges) error {
+	var n, total int
+	for _, actorChanges := range changes {
+		total += len(actorChanges.StateChanges)
+		if n == stateDiffMaxChanges {
+			continue
+		}
+		reader, err := prev.GetReader(actorChanges.Actor)
+		if err != nil {
+			return err
+		}
+		for _, kv := range actorChanges.StateChanges {
+			if n == stateDiffMaxChanges {
+				break
+			}
+			old, err := reader.Get(kv.Key)
+			if err != nil {
+				return err
+			}
+			change := stateChange{
+				Height: height,
+				Store:  string(actorChanges.Actor),
+				Key:    hex.EncodeToString(kv.Key),
+				Old:    hex.EncodeToString(old),
+				Delete: kv.Remove,
+			}
+			if !kv.Remove {
+				change.New = hex.EncodeToString(kv.Value)
+			}
+			if err := d.enc.Encode(change); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	if total > n {
+		return d.enc.Encode(stateChange{Height: height, Truncated: total - n})
+	}
+	return nil
+}
+
+// Close flushes the diff and closes its file.
+func (d *stateDiff) Close() error {
+	return errors.Join(d.w.Flush(), d.f.Close())
+}
+
+// seedPath returns path with the seed inserted before its extension, so that the files of several seeds do not
+// overwrite each other.
+func seedPath(path string, seed int64) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), seed, ext)
+}
@@ -0,0 +1,58 @@
+package simapp
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cosmossdk.io/core/store"
+	coretesting "cosmossdk.io/core/testing"
+)
+
+func TestStateDiff(t *testing.T) {
+	ctx := coretesting.Context()
+	prev := coretesting.KVStoreService(ctx, "bank").OpenKVStore(ctx)
+	require.NoError(t, prev.Set([]byte{1}, []byte{0xa}))
+	require.NoError(t, prev.Set([]byte{2}, []byte{0xb}))
+
+	path := filepath.Join(t.TempDir(), "diff.jsonl")
+	diff, err := newStateDiff(path)
+	require.NoError(t, err)
+	require.NoError(t, diff.write(3, readerMap{"bank": prev}, []store.StateChanges{{
+		Actor: []byte("bank"),
+		StateChanges: store.KVPairs{
+			{Key: []byte{1}, Value: []byte{0xc}},
+			{Key: []byte{2}, Remove: true},
+			{Key: []byte{3}, Value: []byte{0xd}},
+		},
+	}}))
+	require.NoError(t, diff.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var got []stateChange
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var change stateChange
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &change))
+		got = append(got, change)
+	}
+	assert.Equal(t, []stateChange{
+		{Height: 3, Store: "bank", Key: "01", Old: "0a", New: "0c"},
+		{Height: 3, Store: "bank", Key: "02", Old: "0b", Delete: true},
+		{Height: 3, Store: "bank", Key: "03", New: "0d"},
+	}, got)
+}
+
+// readerMap is a store.ReaderMap over KV stores keyed by actor.
+type readerMap map[string]store.KVStore
+
+func (m readerMap) GetReader(actor []byte) (store.Reader, error) {
+	return m[string(actor)], nil
+}
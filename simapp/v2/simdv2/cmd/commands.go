@@ -20,6 +20,7 @@ import (
 	"cosmossdk.io/server/v2/cometbft"
 	serverstore "cosmossdk.io/server/v2/store"
 	"cosmossdk.io/simapp/v2"
+	storemetrics "cosmossdk.io/store/v2/metrics"
 	confixcmd "cosmossdk.io/tools/confix/cmd"
 
 	"github.com/cosmos/cosmos-sdk/client"
@@ -125,7 +126,10 @@ func InitRootCmd[T transaction.Tx](
 		return nil, err
 	}
 
-	telemetryServer, err := telemetry.New[T](logger, sdktelemetry.EnableTelemetry, deps.GlobalConfig)
+	telemetryServer, err := telemetry.New[T](logger, func() {
+		sdktelemetry.EnableTelemetry()
+		storemetrics.SetTelemetryEnabled(true)
+	}, deps.GlobalConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,82 @@
+package simsx
+
+import (
+	"fmt"
+	"math"
+
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+	"github.com/cosmos/cosmos-sdk/x/simulation"
+)
+
+// HasAdversarialOperationsX is implemented by the modules providing adversarial operations: messages that a
+// well-behaved chain must reject, like overspending or invalid signers. Their factories are expected to handle
+// the delivery error with DeliveryResultHandler and to fail when the message is accepted.
+//
+// The adversarial operations are only simulated when the AdversarialRate of the config is set, and then make up
+// that share of the operations, whatever their weights relative to the weighted operations of the modules.
+type HasAdversarialOperationsX interface {
+	AdversarialOperationsX(weights WeightSource, reg Registry)
+}
+
+// MixAdversarialFactories returns the weighted factories with the adversarial ones, whose weights are scaled so
+// that they are drawn with the given rate. The relative weights of the adversarial factories are preserved, but a
+// factory keeps a weight of at least 1.
+func MixAdversarialFactories(factories, adversarial []WeightedFactory, rate float64) ([]WeightedFactory, error) {
+	scale, err := adversarialScale(
+		Collect(factories, func(f WeightedFactory) int { return int(f.Weight) }),
+		Collect(adversarial, func(f WeightedFactory) int { return int(f.Weight) }),
+		rate,
+	)
+	if err != nil || scale == 0 {
+		return factories, err
+	}
+	mixed := append(make([]WeightedFactory, 0, len(factories)+len(adversarial)), factories...)
+	for _, f := range adversarial {
+		mixed = append(mixed, WeightedFactory{Weight: uint32(scaleWeight(int(f.Weight), scale)), Factory: f.Factory})
+	}
+	return mixed, nil
+}
+
+// mixAdversarialOps is the MixAdversarialFactories of the legacy weighted operations.
+func mixAdversarialOps(ops, adversarial []simtypes.WeightedOperation, rate float64) ([]simtypes.WeightedOperation, error) {
+	scale, err := adversarialScale(
+		Collect(ops, func(op simtypes.WeightedOperation) int { return op.Weight() }),
+		Collect(adversarial, func(op simtypes.WeightedOperation) int { return op.Weight() }),
+		rate,
+	)
+	if err != nil || scale == 0 {
+		return ops, err
+	}
+	mixed := append(make([]simtypes.WeightedOperation, 0, len(ops)+len(adversarial)), ops...)
+	for _, op := range adversarial {
+		mixed = append(mixed, simulation.NewWeightedOperation(scaleWeight(op.Weight(), scale), op.Op()))
+	}
+	return mixed, nil
+}
+
+// adversarialScale returns the factor to apply to the adversarial weights so that they weigh rate of the total
+// once mixed with the weights, or 0 when there is nothing to mix. With no weights, the adversarial weights are
+// kept as they are.
+func adversarialScale(weights, adversarial []int, rate float64) (float64, error) {
+	if rate < 0 || rate >= 1 {
+		return 0, fmt.Errorf("adversarial rate must be in [0, 1), got %v", rate)
+	}
+	var total, adversarialTotal int
+	for _, w := range weights {
+		total += w
+	}
+	for _, w := range adversarial {
+		adversarialTotal += w
+	}
+	switch {
+	case rate == 0 || adversarialTotal == 0:
+		return 0, nil
+	case total == 0:
+		return 1, nil
+	}
+	return rate * float64(total) / ((1 - rate) * float64(adversarialTotal)), nil
+}
+
+func scaleWeight(weight int, scale float64) int {
+	return max(1, int(math.Round(float64(weight)*scale)))
+}
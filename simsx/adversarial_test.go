@@ -0,0 +1,96 @@
+package simsx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cosmos/cosmos-sdk/testutil/testdata"
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+	"github.com/cosmos/cosmos-sdk/x/simulation"
+)
+
+func TestMixAdversarialOps(t *testing.T) {
+	weighted := func(weights ...int) []simtypes.WeightedOperation {
+		ops := make([]simtypes.WeightedOperation, len(weights))
+		for i, w := range weights {
+			ops[i] = simulation.NewWeightedOperation(w, nil)
+		}
+		return ops
+	}
+	specs := map[string]struct {
+		ops, adversarial []simtypes.WeightedOperation
+		rate             float64
+		expWeights       []int
+		expErr           bool
+	}{
+		"no rate": {
+			ops:         weighted(100, 10),
+			adversarial: weighted(5),
+			expWeights:  []int{100, 10},
+		},
+		"no adversarial ops": {
+			ops:        weighted(100, 10),
+			rate:       0.5,
+			expWeights: []int{100, 10},
+		},
+		"scaled to rate": {
+			ops:         weighted(60, 30),
+			adversarial: weighted(1, 2),
+			rate:        0.1,
+			expWeights:  []int{60, 30, 3, 7},
+		},
+		"min weight": {
+			ops:         weighted(100),
+			adversarial: weighted(1, 1000),
+			rate:        0.01,
+			expWeights:  []int{100, 1, 1},
+		},
+		"no weighted ops": {
+			adversarial: weighted(1, 2),
+			rate:        0.5,
+			expWeights:  []int{1, 2},
+		},
+		"negative rate": {
+			ops:         weighted(100),
+			adversarial: weighted(1),
+			rate:        -0.1,
+			expErr:      true,
+		},
+		"rate of 1": {
+			ops:         weighted(100),
+			adversarial: weighted(1),
+			rate:        1,
+			expErr:      true,
+		},
+	}
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			mixed, err := mixAdversarialOps(spec.ops, spec.adversarial, spec.rate)
+			if spec.expErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, spec.expWeights, Collect(mixed, func(op simtypes.WeightedOperation) int { return op.Weight() }))
+		})
+	}
+}
+
+func TestMixAdversarialFactories(t *testing.T) {
+	factory := SimMsgFactoryFn[*testdata.TestMsg](nil)
+	factories := []WeightedFactory{{Weight: 60, Factory: factory}, {Weight: 30, Factory: factory}}
+	adversarial := []WeightedFactory{{Weight: 1, Factory: factory}, {Weight: 2, Factory: factory}}
+
+	mixed, err := MixAdversarialFactories(factories, adversarial, 0.1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{60, 30, 3, 7}, Collect(mixed, func(f WeightedFactory) uint32 { return f.Weight }))
+
+	mixed, err = MixAdversarialFactories(factories, adversarial, 0)
+	require.NoError(t, err)
+	assert.Equal(t, factories, mixed)
+
+	_, err = MixAdversarialFactories(factories, adversarial, 1)
+	require.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package simsx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	abci "github.com/cometbft/cometbft/api/cometbft/abci/v1"
+
+	"cosmossdk.io/store/rootmulti"
+	storetypes "cosmossdk.io/store/types"
+
+	"github.com/cosmos/cosmos-sdk/baseapp"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// maxDiffEntries is the maximum number of state changes reported on non-determinism.
+const maxDiffEntries = 20
+
+var _ storetypes.ABCIListener = &determinismListener{}
+
+// committedBlock is the app hash and the state changes committed by a block.
+type committedBlock struct {
+	appHash   []byte
+	changeSet []*storetypes.StoreKVPair
+}
+
+// determinismListener records the blocks committed by a simulation or, when a reference is set, compares them
+// to the blocks committed by a reference simulation of the same seed and fails on the first divergence.
+type determinismListener struct {
+	tb        testing.TB
+	app       *baseapp.BaseApp
+	blocks    map[int64]committedBlock
+	reference map[int64]committedBlock
+}
+
+// listenCommits attaches a determinismListener to app, comparing its blocks to the ones of reference if not nil.
+// The state changes are only recorded when the app uses a rootmulti store.
+func listenCommits(tb testing.TB, app *baseapp.BaseApp, reference map[int64]committedBlock) *determinismListener {
+	tb.Helper()
+	l := &determinismListener{tb: tb, app: app, blocks: make(map[int64]committedBlock), reference: reference}
+	if rms, ok := app.CommitMultiStore().(*rootmulti.Store); ok {
+		keys := rms.StoreKeysByName()
+		rms.AddListeners(Collect(slices.Sorted(maps.Keys(keys)), func(name string) storetypes.StoreKey { return keys[name] }))
+	}
+	app.SetStreamingManager(storetypes.StreamingManager{ABCIListeners: []storetypes.ABCIListener{l}, StopNodeOnErr: true})
+	return l
+}
+
+func (l *determinismListener) ListenFinalizeBlock(context.Context, abci.FinalizeBlockRequest, abci.FinalizeBlockResponse) error {
+	return nil
+}
+
+func (l *determinismListener) ListenCommit(ctx context.Context, _ abci.CommitResponse, changeSet []*storetypes.StoreKVPair) error {
+	height := sdk.UnwrapSDKContext(ctx).BlockHeight()
+	block := committedBlock{appHash: l.app.LastCommitID().Hash, changeSet: changeSet}
+	if l.reference == nil {
+		l.blocks[height] = block
+		return nil
+	}
+	expected, ok := l.reference[height]
+	if !ok {
+		l.tb.Fatalf("non-determinism at height %d: block not committed by the reference run", height)
+	}
+	if !bytes.Equal(expected.appHash, block.appHash) {
+		l.tb.Fatalf("non-determinism at height %d: app hash %X, expected %X\n%s",
+			height, block.appHash, expected.appHash, diffChangeSets(expected.changeSet, block.changeSet))
+	}
+	return nil
+}
+
+// diffChangeSets returns the state changes that differ between the expected and the actual change sets.
+func diffChangeSets(expected, actual []*storetypes.StoreKVPair) string {
+	changes := func(changeSet []*storetypes.StoreKVPair) map[string]*storetypes.StoreKVPair {
+		m := make(map[string]*storetypes.StoreKVPair, len(changeSet))
+		for _, pair := range changeSet {
+			// the last change of a key wins
+			m[fmt.Sprintf("%s/%X", pair.StoreKey, pair.Key)] = pair
+		}
+		return m
+	}
+	formatChange := func(pair *storetypes.StoreKVPair) string {
+		switch {
+		case pair == nil:
+			return "<unchanged>"
+		case pair.Delete:
+			return "<deleted>"
+		default:
+			return fmt.Sprintf("%X", pair.Value)
+		}
+	}
+
+	expectedChanges, actualChanges := changes(expected), changes(actual)
+	keys := slices.Sorted(maps.Keys(expectedChanges))
+	for key := range actualChanges {
+		if _, ok := expectedChanges[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	var diff strings.Builder
+	var count int
+	for _, key := range keys {
+		e, a := expectedChanges[key], actualChanges[key]
+		if e != nil && a != nil && e.Delete == a.Delete && bytes.Equal(e.Value, a.Value) {
+			continue
+		}
+		if count++; count > maxDiffEntries {
+			diff.WriteString("...\n")
+			break
+		}
+		fmt.Fprintf(&diff, "%s: %s, expected %s\n", key, formatChange(a), formatChange(e))
+	}
+	if count == 0 {
+		return "no state change differs"
+	}
+	return diff.String()
+}
@@ -0,0 +1,28 @@
+package simsx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	storetypes "cosmossdk.io/store/types"
+)
+
+func TestDiffChangeSets(t *testing.T) {
+	expected := []*storetypes.StoreKVPair{
+		{StoreKey: "bank", Key: []byte{1}, Value: []byte{1}},
+		{StoreKey: "bank", Key: []byte{2}, Value: []byte{2}},
+		{StoreKey: "staking", Key: []byte{1}, Delete: true},
+	}
+	assert.Equal(t, "no state change differs", diffChangeSets(expected, expected))
+
+	actual := []*storetypes.StoreKVPair{
+		{StoreKey: "bank", Key: []byte{1}, Value: []byte{1}},
+		{StoreKey: "bank", Key: []byte{2}, Value: []byte{3}},
+		{StoreKey: "gov", Key: []byte{1}, Value: []byte{1}},
+	}
+	exp := "bank/02: 03, expected 02\n" +
+		"gov/01: 01, expected <unchanged>\n" +
+		"staking/01: <unchanged>, expected <deleted>\n"
+	assert.Equal(t, exp, diffChangeSets(expected, actual))
+}
@@ -0,0 +1,97 @@
+package simsx
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+)
+
+// OperationLogEntry is a single operation recorded in the OperationLog.
+type OperationLogEntry struct {
+	Step    int64    `json:"step"`
+	Height  int64    `json:"height"`
+	Module  string   `json:"module"`
+	MsgType string   `json:"msg_type"`
+	Signers []string `json:"signers,omitempty"`
+	OK      bool     `json:"ok"`
+	Comment string   `json:"comment,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// OperationLog writes the operations executed by a simulation as JSON lines, in their execution order.
+// The last entries are kept in memory so that they can be reported when the simulation fails.
+type OperationLog struct {
+	mtx  sync.Mutex
+	w    *bufio.Writer
+	enc  *json.Encoder
+	step int64
+	tail []OperationLogEntry
+	next int
+	err  error
+}
+
+// NewOperationLog returns an OperationLog writing to w and keeping the last tailSize entries in memory.
+func NewOperationLog(w io.Writer, tailSize int) *OperationLog {
+	bw := bufio.NewWriter(w)
+	return &OperationLog{
+		w:    bw,
+		enc:  json.NewEncoder(bw),
+		tail: make([]OperationLogEntry, 0, max(tailSize, 1)),
+	}
+}
+
+// Add records the entry with the next step number.
+func (l *OperationLog) Add(entry OperationLogEntry) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.step++
+	entry.Step = l.step
+	if l.err == nil {
+		l.err = l.enc.Encode(entry)
+	}
+	if len(l.tail) < cap(l.tail) {
+		l.tail = append(l.tail, entry)
+		return
+	}
+	l.tail[l.next] = entry
+	l.next = (l.next + 1) % len(l.tail)
+}
+
+// newOperationLogEntry returns the entry of an operation executed at the given height.
+func newOperationLogEntry(height int64, opMsg simtypes.OperationMsg, signers []SimAccount, err error) OperationLogEntry {
+	entry := OperationLogEntry{
+		Height:  height,
+		Module:  opMsg.Route,
+		MsgType: opMsg.Name,
+		OK:      opMsg.OK,
+		Comment: opMsg.Comment,
+	}
+	if len(signers) != 0 {
+		entry.Signers = Collect(signers, func(a SimAccount) string { return a.AddressBech32 })
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// Tail returns the last entries recorded, oldest first.
+func (l *OperationLog) Tail() []OperationLogEntry {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return slices.Concat(l.tail[l.next:], l.tail[:l.next])
+}
+
+// Flush writes the buffered entries, it returns the first error met while writing.
+func (l *OperationLog) Flush() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	return l.w.Flush()
+}
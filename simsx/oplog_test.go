@@ -0,0 +1,39 @@
+package simsx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+)
+
+func TestOperationLog(t *testing.T) {
+	var buf bytes.Buffer
+	opLog := NewOperationLog(&buf, 2)
+	signer := SimAccountFixture()
+	opLog.Add(newOperationLogEntry(1, simtypes.NewOperationMsgBasic("bank", "send", "", true), []SimAccount{signer}, nil))
+	opLog.Add(newOperationLogEntry(1, simtypes.NoOpMsg("bank", "multisend", "no balance"), nil, nil))
+	opLog.Add(newOperationLogEntry(2, simtypes.NewOperationMsgBasic("staking", "delegate", "", false), []SimAccount{signer}, errors.New("boom")))
+	require.NoError(t, opLog.Flush())
+
+	var got []OperationLogEntry
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry OperationLogEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		got = append(got, entry)
+	}
+	require.Len(t, got, 3)
+	assert.Equal(t, OperationLogEntry{Step: 1, Height: 1, Module: "bank", MsgType: "send", Signers: []string{signer.AddressBech32}, OK: true}, got[0])
+	assert.Equal(t, OperationLogEntry{Step: 2, Height: 1, Module: "bank", MsgType: "multisend", Comment: "no balance"}, got[1])
+	assert.Equal(t, "boom", got[2].Error)
+
+	// only the last entries are kept in memory
+	assert.Equal(t, got[1:], opLog.Tail())
+}
@@ -1,7 +1,11 @@
 package simsx
 
 import (
+	"encoding/json"
+	"fmt"
 	"math/rand"
+	"os"
+	"slices"
 
 	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
 )
@@ -50,3 +54,50 @@ func ParamWeightSource(p simtypes.AppParams) WeightSource {
 		return result
 	})
 }
+
+// OperationWeights are operation weights keyed by module name and then by the operation name passed to
+// WeightSource.Get, for example:
+//
+//	{"staking": {"msg_delegate": 200, "msg_undelegate": 200}, "bank": {"msg_send": 10}}
+type OperationWeights map[string]map[string]uint32
+
+// LoadOperationWeights reads the operation weights from the given JSON file.
+func LoadOperationWeights(path string) (OperationWeights, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var weights OperationWeights
+	if err := json.Unmarshal(bz, &weights); err != nil {
+		return nil, fmt.Errorf("invalid operation weights file %s: %w", path, err)
+	}
+	return weights, nil
+}
+
+// Source returns a WeightSource for the given module that returns the weights set for the module,
+// falling back to parent for the operations without one. The names of the operations looked up are
+// recorded in used.
+func (w OperationWeights) Source(module string, parent WeightSource, used map[string]struct{}) WeightSource {
+	return WeightSourceFn(func(name string, defaultValue uint32) uint32 {
+		used[name] = struct{}{}
+		if weight, ok := w[module][name]; ok {
+			return weight
+		}
+		return parent.Get(name, defaultValue)
+	})
+}
+
+// Unused returns the sorted "module/operation" names of the weights whose operation is not in the
+// used operations of their module.
+func (w OperationWeights) Unused(used map[string]map[string]struct{}) []string {
+	var unused []string
+	for module, ops := range w {
+		for name := range ops {
+			if _, ok := used[module][name]; !ok {
+				unused = append(unused, module+"/"+name)
+			}
+		}
+	}
+	slices.Sort(unused)
+	return unused
+}
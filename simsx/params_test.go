@@ -0,0 +1,31 @@
+package simsx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+)
+
+func TestOperationWeights(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "weights.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"bank": {"msg_send": 1, "msg_unknown": 2}, "unknown": {"msg_foo": 3}}`), 0o600))
+	weights, err := LoadOperationWeights(path)
+	require.NoError(t, err)
+
+	parent := ParamWeightSource(simtypes.AppParams{"op_weight_msg_multisend": []byte("20")})
+	used := map[string]map[string]struct{}{"bank": {}}
+	src := weights.Source("bank", parent, used["bank"])
+	assert.Equal(t, uint32(1), src.Get("msg_send", 100))
+	assert.Equal(t, uint32(20), src.Get("msg_multisend", 10))
+	assert.Equal(t, uint32(5), src.Get("msg_update_params", 5))
+	assert.Equal(t, []string{"bank/msg_unknown", "unknown/msg_foo"}, weights.Unused(used))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"bank": {"msg_send": -1}}`), 0o600))
+	_, err = LoadOperationWeights(path)
+	require.Error(t, err)
+}
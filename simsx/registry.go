@@ -49,6 +49,7 @@ type regCommon struct {
 	addressCodec address.Codec
 	txConfig     client.TxConfig
 	logger       log.Logger
+	opLog        *OperationLog
 }
 
 func (c regCommon) newChainDataSource(ctx context.Context, r *rand.Rand, accs ...simtypes.Account) *ChainDataSource {
@@ -93,6 +94,13 @@ func NewSimsMsgRegistryAdapter(
 	}
 }
 
+// WithOperationLog records the operations executed by the registered message factories, including their
+// future operations, in opLog.
+func (l *WeightedOperationRegistryAdapter) WithOperationLog(opLog *OperationLog) *WeightedOperationRegistryAdapter {
+	l.opLog = opLog
+	return l
+}
+
 // Add adds a new weighted operation to the collection
 func (l *WeightedOperationRegistryAdapter) Add(weight uint32, fx SimMsgFactoryX) {
 	if fx == nil {
@@ -127,6 +135,9 @@ func legacyOperationAdapter(l regCommon, fx SimMsgFactoryX) simtypes.Operation {
 		futOps := fOpsReg.legacyObjs
 		weightedOpsResult := DeliverSimsMsg(ctx, reporter, app, r, l.txConfig, l.ak, chainID, msg, fx.DeliveryResultHandler(), from...)
 		err := reporter.Close()
+		if l.opLog != nil {
+			l.opLog.Add(newOperationLogEntry(ctx.BlockHeight(), weightedOpsResult, from, err))
+		}
 		return weightedOpsResult, futOps, err
 	}
 }
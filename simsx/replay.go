@@ -0,0 +1,97 @@
+package simsx
+
+import (
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cosmossdk.io/core/server"
+	corestore "cosmossdk.io/core/store"
+	"cosmossdk.io/log"
+
+	"github.com/cosmos/cosmos-sdk/baseapp"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+	"github.com/cosmos/cosmos-sdk/x/simulation"
+)
+
+// replayStop is the panic value stopping a replayed simulation.
+type replayStop struct{}
+
+var _ simulation.ControlPanic = replayStop{}
+
+// SimulationControl marks replayStop as a simulation.ControlPanic, so that it is not recovered as a failure.
+func (replayStop) SimulationControl() {}
+
+// stepCounter counts the operations executed by a simulation and stops it after the operation at upToStep.
+type stepCounter struct {
+	step     int
+	upToStep int
+}
+
+// wrap returns op counting its executions, as well as the ones of the future operations it returns.
+func (c *stepCounter) wrap(op simtypes.Operation) simtypes.Operation {
+	return func(r *rand.Rand, app simtypes.AppEntrypoint, ctx sdk.Context, accs []simtypes.Account, chainID string) (simtypes.OperationMsg, []simtypes.FutureOperation, error) {
+		opMsg, futureOps, err := op(r, app, ctx, accs, chainID)
+		c.step++
+		for i := range futureOps {
+			futureOps[i].Op = c.wrap(futureOps[i].Op)
+		}
+		if c.step == c.upToStep {
+			panic(replayStop{})
+		}
+		return opMsg, futureOps, err
+	}
+}
+
+// ReplaySeed re-runs the simulation of the given seed as RunWithSeed does and stops it right after the operation
+// at upToStep was executed, so that the state of the returned instance can be asserted. The block of the step is
+// not committed, its state is read from a context of the app created with NewContext(false). The app is closed on
+// test cleanup.
+//
+// The operations are selected from the seed alone, so a replay executes the same operations as the run of the
+// seed. The steps count all the operations executed from 1, queued and future operations included. They match the
+// steps of the operation log when all the operations are simsx message factories.
+func ReplaySeed[T SimulationApp](
+	tb testing.TB,
+	cfg simtypes.Config,
+	appFactory func(logger log.Logger, db corestore.KVStoreWithBatch, traceStore io.Writer, loadLatest bool, appOpts server.DynamicConfig, baseAppOptions ...func(*baseapp.BaseApp)) T,
+	setupStateFactory func(app T) SimStateFactory,
+	seed int64,
+	upToStep int,
+) TestInstance[T] {
+	tb.Helper()
+	require.Positive(tb, upToStep, "step to replay up to")
+	tCfg := cfg.With(tb, seed, nil)
+	testInstance := NewSimulationAppInstance(tb, tCfg, appFactory)
+	tb.Cleanup(func() {
+		require.NoError(tb, testInstance.App.Close())
+	})
+	runLogger := newRunLogger(tb, tCfg.Seed)
+
+	app := testInstance.App
+	stateFactory := setupStateFactory(app)
+	ops, _ := prepareWeightedOps(app.SimulationManager(), stateFactory, tCfg, app.TxConfig(), runLogger, nil)
+	counter := &stepCounter{upToStep: upToStep}
+	for i, op := range ops {
+		ops[i] = simulation.NewWeightedOperation(op.Weight(), counter.wrap(op.Op()))
+	}
+
+	stopped := func() (stopped bool) {
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(replayStop); !ok {
+					panic(r)
+				}
+				stopped = true
+			}
+		}()
+		_, _, err := simulation.SimulateFromSeedX(tb, runLogger, WriteToDebugLog(runLogger), app.GetBaseApp(), stateFactory.AppStateFn, simtypes.RandomAccounts, ops, stateFactory.BlockedAddr, tCfg, stateFactory.Codec, &simulation.DummyLogWriter{})
+		require.NoError(tb, err)
+		return false
+	}()
+	require.True(tb, stopped, "simulation ended after %d steps, before step %d", counter.step, upToStep)
+	return testInstance
+}
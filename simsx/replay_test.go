@@ -0,0 +1,41 @@
+package simsx
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+)
+
+func TestStepCounter(t *testing.T) {
+	var executed int
+	futureOp := func(r *rand.Rand, app simtypes.AppEntrypoint, ctx sdk.Context, accs []simtypes.Account, chainID string) (simtypes.OperationMsg, []simtypes.FutureOperation, error) {
+		executed++
+		return simtypes.NoOpMsg("bank", "future", ""), nil, nil
+	}
+	op := func(r *rand.Rand, app simtypes.AppEntrypoint, ctx sdk.Context, accs []simtypes.Account, chainID string) (simtypes.OperationMsg, []simtypes.FutureOperation, error) {
+		executed++
+		return simtypes.NoOpMsg("bank", "op", ""), []simtypes.FutureOperation{{BlockTime: time.Now(), Op: futureOp}}, nil
+	}
+
+	counter := &stepCounter{upToStep: 3}
+	wrapped := counter.wrap(op)
+	_, futureOps, err := wrapped(nil, nil, sdk.Context{}, nil, "")
+	require.NoError(t, err)
+	require.Len(t, futureOps, 1)
+	_, _, err = futureOps[0].Op(nil, nil, sdk.Context{}, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, counter.step)
+
+	// the simulation is stopped right after the step
+	assert.PanicsWithValue(t, replayStop{}, func() {
+		_, _, _ = wrapped(nil, nil, sdk.Context{}, nil, "")
+	})
+	assert.Equal(t, 3, counter.step)
+	assert.Equal(t, 3, executed)
+}
@@ -2,11 +2,18 @@ package simsx
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
+	goruntime "runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	dbm "github.com/cosmos/cosmos-db"
 	"github.com/stretchr/testify/require"
@@ -24,12 +31,16 @@ import (
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/cosmos/cosmos-sdk/types/module"
 	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+	genutiltypes "github.com/cosmos/cosmos-sdk/x/genutil/types"
 	"github.com/cosmos/cosmos-sdk/x/simulation"
 	"github.com/cosmos/cosmos-sdk/x/simulation/client/cli"
 )
 
 const SimAppChainID = "simulation-app"
 
+// operationLogTail is the number of the last operations reported when a simulation fails.
+const operationLogTail = 50
+
 // this list of seeds was imported from the original simulation runner: https://github.com/cosmos/tools/blob/v1.0.0/cmd/runsim/main.go#L32
 var defaultSeeds = []int64{
 	1, 2, 4, 7,
@@ -56,6 +67,17 @@ type SimStateFactory struct {
 	BalanceSource BalanceSource
 }
 
+// WithGenesis returns a copy of the state factory starting the simulation from the given genesis, typically
+// exported with ExportGenesis, instead of a random genesis. The simulation accounts are still generated
+// from the seed, the seed of the exported simulation must be used so that their keys match the accounts
+// of the genesis. The validator set is the one of the genesis.
+func (s SimStateFactory) WithGenesis(genesis *genutiltypes.AppGenesis) SimStateFactory {
+	s.AppStateFn = func(_ *rand.Rand, accs []simtypes.Account, _ simtypes.Config) (json.RawMessage, []simtypes.Account, string, time.Time) {
+		return genesis.AppState, accs, genesis.ChainID, genesis.GenesisTime
+	}
+	return s
+}
+
 // SimulationApp abstract app that is used by sims
 type SimulationApp interface {
 	runtime.AppSimI
@@ -134,15 +156,72 @@ func RunWithSeedsAndRandAcc[T SimulationApp](
 	t.Helper()
 	cfg := cli.NewConfigFromFlags()
 	cfg.ChainID = SimAppChainID
+	corpus, err := OpenSeedCorpus(cfg.SeedCorpusPath)
+	require.NoError(t, err)
+	seeds = corpus.Seeds(seeds)
 	for i := range seeds {
 		seed := seeds[i]
 		t.Run(fmt.Sprintf("seed: %d", seed), func(t *testing.T) {
 			t.Parallel()
+			corpus.AddOnFailure(t, seed)
 			RunWithSeed(t, cfg, appFactory, setupStateFactory, seed, fuzzSeed, postRunActions...)
 		})
 	}
 }
 
+// RunWithSeedsParallel runs a simulation test for each of the given seeds, like RunWithSeeds, but with
+// at most parallelism simulations running at the same time. A parallelism of 0 or less defaults to GOMAXPROCS.
+//
+// Each seed runs in its own subtest with its own app instance and temp dir, so the outcome of a seed does
+// not depend on the other ones. Once all the seeds are done, the failed seeds are reported so that they
+// can be reproduced with the -Seed flag.
+func RunWithSeedsParallel[T SimulationApp](
+	t *testing.T,
+	cfg simtypes.Config,
+	appFactory func(logger log.Logger, db corestore.KVStoreWithBatch, traceStore io.Writer, loadLatest bool, appOpts server.DynamicConfig, baseAppOptions ...func(*baseapp.BaseApp)) T,
+	setupStateFactory func(app T) SimStateFactory,
+	seeds []int64,
+	parallelism int,
+	postRunActions ...func(t testing.TB, app TestInstance[T], accs []simtypes.Account),
+) {
+	t.Helper()
+	if parallelism <= 0 {
+		parallelism = goruntime.GOMAXPROCS(0)
+	}
+	corpus, err := OpenSeedCorpus(cfg.SeedCorpusPath)
+	require.NoError(t, err)
+	seeds = corpus.Seeds(seeds)
+	workers := make(chan struct{}, parallelism)
+	var (
+		mtx    sync.Mutex
+		failed []int64
+	)
+	// the group subtest returns once all its parallel subtests are done
+	t.Run("seeds", func(t *testing.T) {
+		for i := range seeds {
+			seed := seeds[i]
+			t.Run(fmt.Sprintf("seed: %d", seed), func(t *testing.T) {
+				t.Parallel()
+				workers <- struct{}{}
+				defer func() { <-workers }()
+				corpus.AddOnFailure(t, seed)
+				defer func() {
+					if t.Failed() {
+						mtx.Lock()
+						failed = append(failed, seed)
+						mtx.Unlock()
+					}
+				}()
+				RunWithSeed(t, cfg, appFactory, setupStateFactory, seed, nil, postRunActions...)
+			})
+		}
+	})
+	if len(failed) != 0 {
+		slices.Sort(failed)
+		t.Errorf("simulation failed for %d of %d seeds, reproduce with -Seed=<seed>: %v", len(failed), len(seeds), failed)
+	}
+}
+
 // RunWithSeed is a helper function that runs a simulation test with the given parameters.
 // It iterates over the provided seeds and runs the simulation test for each seed in parallel.
 //
@@ -177,17 +256,53 @@ func RunWithSeedAndRandAcc[T SimulationApp](
 	// setup environment
 	tCfg := cfg.With(tb, seed, fuzzSeed)
 	testInstance := NewSimulationAppInstance(tb, tCfg, appFactory)
-	var runLogger log.Logger
-	if cli.FlagVerboseValue {
-		runLogger = log.NewTestLogger(tb)
-	} else {
-		runLogger = log.NewTestLoggerInfo(tb)
-	}
-	runLogger = runLogger.With("seed", tCfg.Seed)
+	runLogger := newRunLogger(tb, tCfg.Seed)
 
 	app := testInstance.App
-	stateFactory := setupStateFactory(app)
-	ops, reporter := prepareWeightedOps(app.SimulationManager(), stateFactory, tCfg, testInstance.App.TxConfig(), runLogger)
+	if tCfg.CheckDeterminism {
+		reference := runReference(tb, tCfg, appFactory, setupStateFactory, randAccFn)
+		listenCommits(tb, app.GetBaseApp(), reference)
+	}
+	exportGenesis := func() {}
+	if tCfg.ExportGenesisPath != "" {
+		exportGenesis = sync.OnceFunc(func() {
+			path := seedPath(tCfg.ExportGenesisPath, seed)
+			if err := ExportGenesis(app, tCfg.ChainID, path); err != nil {
+				tb.Errorf("failed to export genesis to %s: %v", path, err)
+				return
+			}
+			runLogger.Info("exported genesis", "path", path)
+		})
+		// the genesis is exported even if the simulation fails or panics
+		defer exportGenesis()
+	}
+	var opLog *OperationLog
+	if tCfg.OperationLogPath != "" {
+		path := seedPath(tCfg.OperationLogPath, seed)
+		f, err := os.Create(path)
+		require.NoError(tb, err)
+		opLog = NewOperationLog(f, operationLogTail)
+		defer func() {
+			// the last operations are reported when the simulation fails or panics
+			r := recover()
+			if r != nil || tb.Failed() {
+				tb.Logf("last operations of seed %d:", seed)
+				for _, entry := range opLog.Tail() {
+					tb.Logf("%+v", entry)
+				}
+			}
+			if err := errors.Join(opLog.Flush(), f.Close()); err != nil {
+				tb.Errorf("failed to write the operation log to %s: %v", path, err)
+			} else {
+				runLogger.Info("wrote operation log", "path", path)
+			}
+			if r != nil {
+				panic(r)
+			}
+		}()
+	}
+	stateFactory := resumeGenesis(tb, &tCfg, setupStateFactory(app))
+	ops, reporter := prepareWeightedOps(app.SimulationManager(), stateFactory, tCfg, testInstance.App.TxConfig(), runLogger, opLog)
 	simParams, accs, err := simulation.SimulateFromSeedX(tb, runLogger, WriteToDebugLog(runLogger), app.GetBaseApp(), stateFactory.AppStateFn, randAccFn, ops, stateFactory.BlockedAddr, tCfg, stateFactory.Codec, testInstance.ExecLogWriter)
 	require.NoError(tb, err)
 	err = simtestutil.CheckExportSimulation(app, tCfg, simParams)
@@ -200,7 +315,78 @@ func RunWithSeedAndRandAcc[T SimulationApp](
 	for _, step := range postRunActions {
 		step(tb, testInstance, accs)
 	}
+	exportGenesis()
+	require.NoError(tb, app.Close())
+}
+
+// resumeGenesis returns the state factory starting from the genesis of the ResumeGenesisFile of the config,
+// if any, and sets the initial block height of the config to the height of the genesis.
+func resumeGenesis(tb testing.TB, tCfg *simtypes.Config, stateFactory SimStateFactory) SimStateFactory {
+	tb.Helper()
+	if tCfg.ResumeGenesisFile == "" {
+		return stateFactory
+	}
+	genesis, err := genutiltypes.AppGenesisFromFile(tCfg.ResumeGenesisFile)
+	require.NoError(tb, err)
+	require.Equal(tb, tCfg.ChainID, genesis.ChainID, "chain id of the resumed genesis")
+	tCfg.InitialBlockHeight = uint64(genesis.InitialHeight)
+	return stateFactory.WithGenesis(genesis)
+}
+
+// runReference runs the simulation of the config on a fresh app instance and returns the blocks it committed, for
+// the determinism check.
+func runReference[T SimulationApp](
+	tb testing.TB,
+	tCfg simtypes.Config,
+	appFactory func(logger log.Logger, db corestore.KVStoreWithBatch, traceStore io.Writer, loadLatest bool, appOpts server.DynamicConfig, baseAppOptions ...func(*baseapp.BaseApp)) T,
+	setupStateFactory func(app T) SimStateFactory,
+	randAccFn simtypes.RandomAccountFn,
+) map[int64]committedBlock {
+	tb.Helper()
+	testInstance := NewSimulationAppInstance(tb, tCfg, appFactory)
+	app := testInstance.App
+	listener := listenCommits(tb, app.GetBaseApp(), nil)
+	runLogger := newRunLogger(tb, tCfg.Seed).With("run", "reference")
+	stateFactory := resumeGenesis(tb, &tCfg, setupStateFactory(app))
+	ops, _ := prepareWeightedOps(app.SimulationManager(), stateFactory, tCfg, app.TxConfig(), runLogger, nil)
+	_, _, err := simulation.SimulateFromSeedX(tb, runLogger, WriteToDebugLog(runLogger), app.GetBaseApp(), stateFactory.AppStateFn, randAccFn, ops, stateFactory.BlockedAddr, tCfg, stateFactory.Codec, &simulation.DummyLogWriter{})
+	require.NoError(tb, err)
 	require.NoError(tb, app.Close())
+	return listener.blocks
+}
+
+// newRunLogger returns the logger of the simulation runs.
+func newRunLogger(tb testing.TB, seed int64) log.Logger {
+	tb.Helper()
+	var logger log.Logger
+	if cli.FlagVerboseValue {
+		logger = log.NewTestLogger(tb)
+	} else {
+		logger = log.NewTestLoggerInfo(tb)
+	}
+	return logger.With("seed", seed)
+}
+
+// ExportGenesis exports the app state and the validators of the app at its latest height as a genesis
+// file written to path. The genesis time is the time of the latest block, so that a simulation can be
+// resumed from the genesis with SimStateFactory.WithGenesis.
+func ExportGenesis(app SimulationApp, chainID, path string) error {
+	exported, err := app.ExportAppStateAndValidators(false, nil, nil)
+	if err != nil {
+		return err
+	}
+	genesis := genutiltypes.NewAppGenesisWithVersion(chainID, exported.AppState)
+	genesis.GenesisTime = app.GetBaseApp().NewContext(true).BlockTime()
+	genesis.InitialHeight = exported.Height
+	genesis.Consensus = genutiltypes.NewConsensusGenesis(exported.ConsensusParams, exported.Validators)
+	return genesis.SaveAs(path)
+}
+
+// seedPath returns path with the seed inserted before its extension, so that the files of several
+// seeds do not overwrite each other.
+func seedPath(path string, seed int64) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), seed, ext)
 }
 
 type (
@@ -260,6 +446,7 @@ func prepareWeightedOps(
 	config simtypes.Config,
 	txConfig client.TxConfig,
 	logger log.Logger,
+	opLog *OperationLog,
 ) (simulation.WeightedOperations, *BasicSimulationReporter) {
 	cdc := stateFact.Codec
 	signingCtx := cdc.InterfaceRegistry().SigningContext()
@@ -285,6 +472,27 @@ func prepareWeightedOps(
 	}
 
 	weights := ParamWeightSource(simState.AppParams)
+	var overrides OperationWeights
+	if config.WeightsFile != "" {
+		var err error
+		if overrides, err = LoadOperationWeights(config.WeightsFile); err != nil {
+			panic(err)
+		}
+	}
+	usedWeights := make(map[string]map[string]struct{})
+	// moduleWeights returns the weight source of the module, with the weights of the weights file if any.
+	moduleWeights := func(m module.AppModuleSimulation) WeightSource {
+		named, ok := m.(interface{ Name() string })
+		if !ok || overrides == nil {
+			return weights
+		}
+		used, ok := usedWeights[named.Name()]
+		if !ok {
+			used = make(map[string]struct{})
+			usedWeights[named.Name()] = used
+		}
+		return overrides.Source(named.Name(), weights, used)
+	}
 	reporter := NewBasicSimulationReporter()
 
 	pReg := make(UniqueTypeRegistry)
@@ -294,7 +502,7 @@ func prepareWeightedOps(
 	for _, m := range sm.Modules {
 		switch xm := m.(type) {
 		case HasProposalMsgsX:
-			xm.ProposalMsgsX(weights, pReg)
+			xm.ProposalMsgsX(moduleWeights(m), pReg)
 		case HasLegacyProposalMsgs:
 			for _, p := range xm.ProposalMsgs(simState) {
 				weight := weights.Get(p.AppParamsKey(), uint32(p.DefaultWeight()))
@@ -305,20 +513,37 @@ func prepareWeightedOps(
 		}
 	}
 
-	oReg := NewSimsMsgRegistryAdapter(reporter, stateFact.AccountSource, stateFact.BalanceSource, txConfig, logger)
+	oReg := NewSimsMsgRegistryAdapter(reporter, stateFact.AccountSource, stateFact.BalanceSource, txConfig, logger).
+		WithOperationLog(opLog)
+	// the adversarial operations are registered apart to be mixed in with the adversarial rate, if any
+	advReg := NewSimsMsgRegistryAdapter(reporter, stateFact.AccountSource, stateFact.BalanceSource, txConfig, logger).
+		WithOperationLog(opLog)
 	wOps := make([]simtypes.WeightedOperation, 0, len(sm.Modules))
 	for _, m := range sm.Modules {
+		if named, ok := m.(interface{ Name() string }); ok && !config.SimulatesModule(named.Name()) {
+			continue
+		}
+		if xm, ok := m.(HasAdversarialOperationsX); ok {
+			xm.AdversarialOperationsX(moduleWeights(m), advReg)
+		}
 		// add operations
 		switch xm := m.(type) {
 		case HasWeightedOperationsX:
-			xm.WeightedOperationsX(weights, oReg)
+			xm.WeightedOperationsX(moduleWeights(m), oReg)
 		case HasWeightedOperationsXWithProposals:
-			xm.WeightedOperationsX(weights, oReg, AppendIterators(legacyPReg.Iterator(), pReg.Iterator()), wContent)
+			xm.WeightedOperationsX(moduleWeights(m), oReg, AppendIterators(legacyPReg.Iterator(), pReg.Iterator()), wContent)
 		case HasLegacyWeightedOperations:
 			wOps = append(wOps, xm.WeightedOperations(simState)...)
 		}
 	}
-	return append(wOps, oReg.ToLegacyObjects()...), reporter
+	for _, name := range overrides.Unused(usedWeights) {
+		logger.Warn("ignoring unknown operation of the weights file", "operation", name)
+	}
+	wOps, err := mixAdversarialOps(append(wOps, oReg.ToLegacyObjects()...), advReg.ToLegacyObjects(), config.AdversarialRate)
+	if err != nil {
+		panic(err)
+	}
+	return wOps, reporter
 }
 
 // NewSimulationAppInstance initializes and returns a TestInstance of a SimulationApp.
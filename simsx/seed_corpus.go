@@ -0,0 +1,112 @@
+package simsx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// SeedCorpus is a regression corpus of simulation seeds, stored in a file holding one seed per line,
+// where blank lines and lines starting with # are ignored. The seeds of the corpus run before the other
+// ones and the seeds failing a simulation are appended to it, like fuzzing corpora.
+//
+// A nil SeedCorpus is valid and disabled: it runs the given seeds only and records nothing.
+type SeedCorpus struct {
+	mtx   sync.Mutex
+	path  string
+	seeds []int64
+}
+
+// OpenSeedCorpus reads the seed corpus at path, the file being created on the first failing seed if it
+// does not exist. It returns nil if path is empty.
+func OpenSeedCorpus(path string) (*SeedCorpus, error) {
+	if path == "" {
+		return nil, nil
+	}
+	c := &SeedCorpus{path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		seed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed at %s:%d: %w", path, line, err)
+		}
+		if !slices.Contains(c.seeds, seed) {
+			c.seeds = append(c.seeds, seed)
+		}
+	}
+	return c, scanner.Err()
+}
+
+// Seeds returns the seeds of the corpus followed by the given seeds which are not in the corpus.
+func (c *SeedCorpus) Seeds(seeds []int64) []int64 {
+	if c == nil {
+		return seeds
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	all := slices.Clone(c.seeds)
+	for _, seed := range seeds {
+		if !slices.Contains(all, seed) {
+			all = append(all, seed)
+		}
+	}
+	return all
+}
+
+// Add appends the seed to the corpus file, unless it is already in the corpus.
+func (c *SeedCorpus) Add(seed int64) error {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if slices.Contains(c.seeds, seed) {
+		return nil
+	}
+	f, err := os.OpenFile(c.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, seed); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	c.seeds = append(c.seeds, seed)
+	return nil
+}
+
+// AddOnFailure adds the seed to the corpus once the test of the seed is done, if it failed.
+func (c *SeedCorpus) AddOnFailure(tb testing.TB, seed int64) {
+	tb.Helper()
+	if c == nil {
+		return
+	}
+	tb.Cleanup(func() {
+		if !tb.Failed() {
+			return
+		}
+		if err := c.Add(seed); err != nil {
+			tb.Errorf("failed to add seed %d to the seed corpus %s: %v", seed, c.path, err)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package simsx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedCorpus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds.txt")
+
+	// a missing corpus is created on the first failing seed
+	corpus, err := OpenSeedCorpus(path)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, corpus.Seeds([]int64{1, 2}))
+	require.NoError(t, corpus.Add(7))
+	require.NoError(t, corpus.Add(7))
+	require.NoError(t, corpus.Add(-3))
+	assert.Equal(t, []int64{7, -3, 1, 2}, corpus.Seeds([]int64{1, 7, 2}))
+
+	bz, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "7\n-3\n", string(bz))
+	require.NoError(t, os.WriteFile(path, append([]byte("# found by nightly runs\n\n"), bz...), 0o600))
+	corpus, err = OpenSeedCorpus(path)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{7, -3, 1}, corpus.Seeds([]int64{1}))
+
+	require.NoError(t, os.WriteFile(path, []byte("seed\n"), 0o600))
+	_, err = OpenSeedCorpus(path)
+	require.ErrorContains(t, err, "seeds.txt:1")
+
+	// a nil corpus is disabled
+	corpus, err = OpenSeedCorpus("")
+	require.NoError(t, err)
+	assert.Nil(t, corpus)
+	assert.Equal(t, []int64{1}, corpus.Seeds([]int64{1}))
+	require.NoError(t, corpus.Add(1))
+}
@@ -21,10 +21,13 @@ type RandSource interface {
 var (
 	_ RandSource = &SeededRandomSource{}
 	_ RandSource = &ByteSource{}
+	_ RandSource = &ReaderSource{}
 )
 
 // SeededRandomSource wraps a random source with an associated seed value for reproducible random number generation.
 // It implements the RandSource interface, allowing access to both the random source and seed.
+// The numbers are read by a ReaderSource from the seeded standard random number generator, so that they are the
+// ones of rand.NewSource(seed).
 type SeededRandomSource struct {
 	rand.Source
 	seed int64
@@ -39,7 +42,7 @@ func NewSeededRandSource(seed int64) *SeededRandomSource {
 
 func (r *SeededRandomSource) Seed(seed int64) {
 	r.seed = seed
-	r.Source = rand.NewSource(seed)
+	r.Source = NewReaderSource(NewSeedReader(seed), seed)
 }
 
 func (r SeededRandomSource) GetSeed() int64 {
@@ -87,3 +90,73 @@ func (s *ByteSource) Seed(seed int64) {
 func (s ByteSource) GetSeed() int64 {
 	panic("not supported")
 }
+
+// ReaderSource offers deterministic pseudo-random numbers for math.Rand read from an io.Reader, so that an external
+// driver, e.g. a fuzzer, controls the exact stream of random choices. The data is read in big endian to uint64. When
+// the reader is exhausted, it falls back to a standard random number generator initialized with the 'seed' value.
+type ReaderSource struct {
+	r        io.Reader
+	seed     int64
+	fallback *rand.Rand
+}
+
+// NewReaderSource creates a new ReaderSource reading the numbers from r, then from the random number generator of
+// the given seed once r is exhausted.
+func NewReaderSource(r io.Reader, seed int64) *ReaderSource {
+	return &ReaderSource{r: r, seed: seed}
+}
+
+func (s *ReaderSource) Uint64() uint64 {
+	if s.fallback != nil {
+		return s.fallback.Uint64()
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(s.r, b[:]); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			panic(err)
+		}
+		s.fallback = rand.New(rand.NewSource(s.seed))
+		return s.fallback.Uint64()
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func (s *ReaderSource) Int63() int64 {
+	return int64(s.Uint64() & rngMask)
+}
+
+// Seed is not supported and will panic
+func (s *ReaderSource) Seed(seed int64) {
+	panic("not supported")
+}
+
+// GetSeed returns the seed of the fallback random number generator.
+func (s *ReaderSource) GetSeed() int64 {
+	return s.seed
+}
+
+// NewSeedReader returns an endless reader of the numbers of the standard random number generator initialized
+// with the given seed, in big endian, so that a ReaderSource reading it yields the numbers of rand.NewSource(seed).
+func NewSeedReader(seed int64) io.Reader {
+	return &seedReader{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+type seedReader struct {
+	src rand.Source64
+	buf [8]byte
+	n   int // number of unread bytes at the end of buf
+}
+
+func (r *seedReader) Read(p []byte) (int, error) {
+	var read int
+	for read < len(p) {
+		if r.n == 0 {
+			binary.BigEndian.PutUint64(r.buf[:], r.src.Uint64())
+			r.n = len(r.buf)
+		}
+		c := copy(p[read:], r.buf[len(r.buf)-r.n:])
+		r.n -= c
+		read += c
+	}
+	return read, nil
+}
@@ -1,6 +1,8 @@
 package v2
 
 import (
+	"bytes"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,3 +53,31 @@ func TestByteSource(t *testing.T) {
 		})
 	}
 }
+
+func TestReaderSource(t *testing.T) {
+	const (
+		seed1              = 1
+		firstValFromSeed1  = 0x4d65822107fcfd52
+		secondValFromSeed1 = 0x78629a0f5f3f164f
+	)
+	src := NewReaderSource(bytes.NewReader([]byte{
+		1, 2, 3, 4, 5, 6, 7, 8,
+		17, 18, // incomplete uint64, the fallback takes over
+	}), seed1)
+	for _, v := range []uint64{0x102030405060708, firstValFromSeed1, secondValFromSeed1} {
+		assert.Equal(t, v, src.Uint64())
+	}
+	assert.Equal(t, int64(seed1), src.GetSeed())
+
+	// the seed reader yields the numbers of the seeded standard source, whatever the size of the reads
+	reader, std := NewSeedReader(seed1), rand.New(rand.NewSource(seed1))
+	var b [24]byte
+	for pos, size := 0, 3; pos < len(b); pos, size = pos+size, size+5 {
+		_, err := reader.Read(b[pos : pos+size])
+		assert.NoError(t, err)
+	}
+	src = NewReaderSource(bytes.NewReader(b[:]), 2)
+	for range 3 {
+		assert.Equal(t, std.Int63(), src.Int63())
+	}
+}
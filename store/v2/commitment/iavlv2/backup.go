@@ -0,0 +1,221 @@
+package iavlv2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"cosmossdk.io/store/v2/commitment"
+	snapshotstypes "cosmossdk.io/store/v2/snapshots/types"
+)
+
+// backupMagic starts every backup written by BackupTo.
+var backupMagic = []byte("iavlv2-backup")
+
+// backupFormat is the version of the backup format written by BackupTo.
+const backupFormat = 1
+
+// backupEnd is the height byte marking the end of the nodes of a backup, node
+// heights are within [0, math.MaxInt8].
+const backupEnd = 0xff
+
+// BackupTo writes a full backup of the tree at the given version to w.
+//
+// The backup is made of the magic, the format and the version, followed by the
+// nodes of the tree in depth-first post-order, each encoded as its height byte,
+// its varint version and its length-prefixed key, plus its length-prefixed value
+// for a leaf. The nodes are terminated by a 0xff byte and the length-prefixed root
+// hash of the version, which RestoreFrom checks the restored tree against.
+func (t *Tree) BackupTo(w io.Writer, version uint64) error {
+	exporter, err := t.Export(version)
+	if err != nil {
+		return err
+	}
+	defer exporter.Close()
+
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 0, binary.MaxVarintLen64)
+	writeUvarint := func(x uint64) error {
+		_, err := bw.Write(binary.AppendUvarint(buf[:0], x))
+		return err
+	}
+	writeBytes := func(bz []byte) error {
+		if err := writeUvarint(uint64(len(bz))); err != nil {
+			return err
+		}
+		_, err := bw.Write(bz)
+		return err
+	}
+
+	if _, err := bw.Write(backupMagic); err != nil {
+		return err
+	}
+	if err := writeUvarint(backupFormat); err != nil {
+		return err
+	}
+	if err := writeUvarint(version); err != nil {
+		return err
+	}
+
+	for {
+		item, err := exporter.Next()
+		if errors.Is(err, commitment.ErrorExportDone) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("backup: failed to export version %d; path=%s: %w", version, t.path, err)
+		}
+		if err := bw.WriteByte(byte(item.Height)); err != nil {
+			return err
+		}
+		if _, err := bw.Write(binary.AppendVarint(buf[:0], item.Version)); err != nil {
+			return err
+		}
+		if err := writeBytes(item.Key); err != nil {
+			return err
+		}
+		if item.Height == 0 {
+			if err := writeBytes(item.Value); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bw.WriteByte(backupEnd); err != nil {
+		return err
+	}
+
+	hash, err := t.hashAt(version)
+	if err != nil {
+		return err
+	}
+	if err := writeBytes(hash); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// RestoreFrom restores the tree from a backup written by BackupTo. The tree must
+// be empty, it is left at the version of the backup once restored.
+func (t *Tree) RestoreFrom(r io.Reader) error {
+	if latest := t.Version(); latest != 0 {
+		return fmt.Errorf("restore: tree must be empty, found version %d; path=%s", latest, t.path)
+	}
+
+	br := bufio.NewReader(r)
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		bz := make([]byte, n)
+		_, err = io.ReadFull(br, bz)
+		return bz, err
+	}
+
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("restore: failed to read the header: %w", err)
+	}
+	if !bytes.Equal(magic, backupMagic) {
+		return fmt.Errorf("restore: not an iavlv2 backup")
+	}
+	format, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("restore: failed to read the header: %w", err)
+	}
+	if format != backupFormat {
+		return fmt.Errorf("restore: unsupported backup format %d", format)
+	}
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("restore: failed to read the header: %w", err)
+	}
+	if err := isHighBitSet(version); err != nil {
+		return err
+	}
+
+	// the importer is opened along with the first node, an empty tree is not
+	// imported.
+	var importer commitment.Importer
+	defer func() {
+		if importer != nil {
+			importer.Close()
+		}
+	}()
+	var count int
+	for {
+		height, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("restore: failed to read node %d: %w", count, err)
+		}
+		if height == backupEnd {
+			break
+		}
+		item := &snapshotstypes.SnapshotIAVLItem{Height: int32(height)}
+		if item.Version, err = binary.ReadVarint(br); err != nil {
+			return fmt.Errorf("restore: failed to read node %d: %w", count, err)
+		}
+		if item.Key, err = readBytes(); err != nil {
+			return fmt.Errorf("restore: failed to read node %d: %w", count, err)
+		}
+		if height == 0 {
+			if item.Value, err = readBytes(); err != nil {
+				return fmt.Errorf("restore: failed to read node %d: %w", count, err)
+			}
+		}
+		if importer == nil {
+			if importer, err = t.Import(version); err != nil {
+				return err
+			}
+		}
+		if err := importer.Add(item); err != nil {
+			return fmt.Errorf("restore: invalid node %d: %w", count, err)
+		}
+		count++
+	}
+	hash, err := readBytes()
+	if err != nil {
+		return fmt.Errorf("restore: failed to read the root hash: %w", err)
+	}
+
+	if importer != nil {
+		if err := importer.Commit(); err != nil {
+			return err
+		}
+	} else {
+		// an empty tree has no node to import, committing it at the version is
+		// enough.
+		if err := t.SetInitialVersion(version); err != nil {
+			return err
+		}
+		if _, _, err := t.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if t.Version() != version {
+		return fmt.Errorf("restore: restored version %d, expected %d; path=%s", t.Version(), version, t.path)
+	}
+	if !bytes.Equal(t.Hash(), hash) {
+		return fmt.Errorf("restore: restored root hash %X does not match backup root hash %X; path=%s", t.Hash(), hash, t.path)
+	}
+	t.log.Info("restored tree", "version", version, "nodes", count)
+
+	return nil
+}
+
+// hashAt returns the root hash of the tree at the given version.
+func (t *Tree) hashAt(version uint64) ([]byte, error) {
+	v := int64(version)
+	if v == t.tree.Version() {
+		return t.tree.Hash(), nil
+	}
+	cloned, err := t.loadClone(v)
+	if err != nil {
+		return nil, err
+	}
+	return cloned.Hash(), cloned.Close()
+}
@@ -0,0 +1,186 @@
+package iavlv2
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/cosmos/iavl/v2"
+)
+
+// pooledClone is a readonly clone of the tree loaded at a fixed version.
+type pooledClone struct {
+	version int64
+	tree    *iavl.Tree
+
+	// mtx serializes the reads on the clone, an iavl.Tree is not safe for
+	// concurrent use.
+	mtx sync.Mutex
+
+	// refs and evicted are guarded by the mutex of the pool.
+	refs    int
+	evicted bool
+}
+
+// clonePool is an LRU pool of readonly clones of the tree keyed by version, so
+// that concurrent reads of the same historical version share a single clone
+// instead of opening a new one each time. A clone is closed once it has been
+// evicted and is no longer in use.
+type clonePool struct {
+	mtx sync.Mutex
+	// loadMtx serializes the creation of the clones.
+	loadMtx sync.Mutex
+
+	size     int
+	lru      *list.List // of *pooledClone, most recently used first
+	versions map[int64]*list.Element
+	newClone func(version int64) (*iavl.Tree, error)
+}
+
+// newClonePool returns a pool holding at most size clones created by newClone.
+// A size of 0 disables pooling, each read then uses a clone of its own.
+func newClonePool(size int, newClone func(version int64) (*iavl.Tree, error)) *clonePool {
+	return &clonePool{
+		size:     size,
+		lru:      list.New(),
+		versions: make(map[int64]*list.Element),
+		newClone: newClone,
+	}
+}
+
+// withClone calls fn with a readonly clone of the tree loaded at the given
+// version. fn must not retain the clone after it returns.
+func (p *clonePool) withClone(version int64, fn func(tree *iavl.Tree) error) error {
+	if p.size <= 0 {
+		p.loadMtx.Lock()
+		tree, err := p.newClone(version)
+		p.loadMtx.Unlock()
+		if err != nil {
+			return err
+		}
+		return errors.Join(fn(tree), tree.Close())
+	}
+
+	c, err := p.acquire(version)
+	if err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	fnErr := fn(c.tree)
+	c.mtx.Unlock()
+	return errors.Join(fnErr, p.release(c))
+}
+
+// acquire returns the pooled clone at version, creating it if needed, with its
+// reference count incremented.
+func (p *clonePool) acquire(version int64) (*pooledClone, error) {
+	if c := p.get(version); c != nil {
+		return c, nil
+	}
+
+	// clones are created one at a time, opening the SQLite connections of
+	// several clones concurrently fails with a locked database. p.mtx is not
+	// held so that the reads of the pooled versions are not blocked meanwhile.
+	p.loadMtx.Lock()
+	defer p.loadMtx.Unlock()
+	if c := p.get(version); c != nil {
+		// another read created the clone while waiting for the lock.
+		return c, nil
+	}
+	tree, err := p.newClone(version)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	c := &pooledClone{version: version, tree: tree, refs: 1}
+	p.versions[version] = p.lru.PushFront(c)
+	var closeErr error
+	for p.lru.Len() > p.size {
+		closeErr = errors.Join(closeErr, p.evict(p.lru.Back()))
+	}
+	return c, closeErr
+}
+
+// get returns the pooled clone at version with its reference count incremented,
+// or nil if the version is not pooled.
+func (p *clonePool) get(version int64) *pooledClone {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	e, ok := p.versions[version]
+	if !ok {
+		return nil
+	}
+	c := e.Value.(*pooledClone)
+	c.refs++
+	p.lru.MoveToFront(e)
+	return c
+}
+
+// release decrements the reference count of the clone, closing it if it was
+// evicted while in use.
+func (p *clonePool) release(c *pooledClone) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	c.refs--
+	if c.evicted && c.refs == 0 {
+		return c.tree.Close()
+	}
+	return nil
+}
+
+// evict removes the clone in e from the pool, closing it unless it is in use in
+// which case it is closed by the last release. p.mtx must be held.
+func (p *clonePool) evict(e *list.Element) error {
+	c := p.lru.Remove(e).(*pooledClone)
+	delete(p.versions, c.version)
+	c.evicted = true
+	if c.refs == 0 {
+		return c.tree.Close()
+	}
+	return nil
+}
+
+// evictUpTo evicts the clones at versions less than or equal to version.
+func (p *clonePool) evictUpTo(version int64) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var err error
+	for e := p.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*pooledClone).version <= version {
+			err = errors.Join(err, p.evict(e))
+		}
+		e = next
+	}
+	return err
+}
+
+// evictVersion evicts the clone at the given version, if any.
+func (p *clonePool) evictVersion(version int64) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if e, ok := p.versions[version]; ok {
+		return p.evict(e)
+	}
+	return nil
+}
+
+// purge evicts all the clones of the pool.
+func (p *clonePool) purge() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var err error
+	for p.lru.Len() > 0 {
+		err = errors.Join(err, p.evict(p.lru.Back()))
+	}
+	return err
+}
+
+// len returns the number of clones in the pool.
+func (p *clonePool) len() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.lru.Len()
+}
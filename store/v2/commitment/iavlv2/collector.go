@@ -0,0 +1,98 @@
+package iavlv2
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// Collector is a prometheus.Collector exporting the stats of a tree, see
+// TreeStats, so that they can be registered with the registry of the node. The
+// metrics are labelled with the store name of the tree, so that the collectors of
+// several trees can be registered with the same registry.
+type Collector struct {
+	tree *Tree
+
+	version         *prometheus.Desc
+	earliestVersion *prometheus.Desc
+	keys            *prometheus.Desc
+	height          *prometheus.Desc
+	workingBytes    *prometheus.Desc
+	diskBytes       *prometheus.Desc
+	pageCacheBytes  *prometheus.Desc
+	pages           *prometheus.Desc
+	clones          *prometheus.Desc
+	commitLatency   *prometheus.Desc
+	commitChanges   *prometheus.Desc
+}
+
+// NewCollector returns a collector of the stats of the given tree.
+func NewCollector(tree *Tree) *Collector {
+	labels := prometheus.Labels{"store": tree.storeName}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsKey, "", name), help, nil, labels)
+	}
+	return &Collector{
+		tree:            tree,
+		version:         desc("version", "Latest committed version of the tree."),
+		earliestVersion: desc("earliest_version", "Earliest version of the tree which has not been pruned."),
+		keys:            desc("keys", "Number of keys in the latest committed version of the tree."),
+		height:          desc("height", "Height of the root node of the latest committed version of the tree."),
+		workingBytes:    desc("working_bytes", "Size of the nodes created by the changes since the tree was loaded."),
+		diskBytes:       desc("disk_bytes", "Size of the SQLite files backing the tree."),
+		pageCacheBytes:  desc("page_cache_bytes", "Maximum size of the page cache of each SQLite connection of the tree."),
+		pages:           desc("pages", "Number of pages of the SQLite databases of the tree."),
+		clones:          desc("clones", "Number of readonly clones of the tree pooled to serve historical reads."),
+		commitLatency:   desc("commit_latency_ms", "Duration of the commits of the tree in milliseconds."),
+		commitChanges:   desc("commit_changes", "Number of sets and removes of the commits of the tree."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.version
+	ch <- c.earliestVersion
+	ch <- c.keys
+	ch <- c.height
+	ch <- c.workingBytes
+	ch <- c.diskBytes
+	ch <- c.pageCacheBytes
+	ch <- c.pages
+	ch <- c.clones
+	ch <- c.commitLatency
+	ch <- c.commitChanges
+}
+
+// Collect implements prometheus.Collector. A closed tree reports no metric.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.tree.closed.Load() {
+		return
+	}
+	stats := c.tree.Stats()
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+	}
+	gauge(c.version, float64(stats.Version))
+	gauge(c.earliestVersion, float64(c.tree.earliest.Load()))
+	gauge(c.keys, float64(stats.Size))
+	gauge(c.height, float64(stats.Height))
+	gauge(c.workingBytes, float64(stats.WorkingBytes))
+	gauge(c.diskBytes, float64(stats.DiskSize))
+	gauge(c.pageCacheBytes, float64(stats.PageCacheSize))
+	gauge(c.pages, float64(stats.PageCount))
+	gauge(c.clones, float64(c.tree.clones.len()))
+	ch <- constHistogram(c.commitLatency, stats.CommitLatency)
+	ch <- constHistogram(c.commitChanges, stats.CommitChanges)
+}
+
+// constHistogram converts the given histogram to a prometheus histogram, whose
+// buckets count the observations cumulatively.
+func constHistogram(desc *prometheus.Desc, h Histogram) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.Bounds))
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		buckets[bound] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, h.Count, h.Sum, buckets)
+}
@@ -1,20 +1,74 @@
 package iavlv2
 
 import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/cosmos/iavl/v2"
 	"github.com/cosmos/iavl/v2/metrics"
 )
 
+// journalModes are the valid values of the journal_mode pragma. Only the switch
+// between WAL and rollback journal is persisted in the database, the other modes
+// would be lost with the connection applying them.
+var journalModes = []string{"delete", "wal"}
+
+// maxPreloadDepth bounds Config.PreloadDepth, the preloading reading a leaf per
+// node of the deepest preloaded level.
+const maxPreloadDepth = 20
+
 // Config is the configuration for the IAVL v2 tree.
 type Config struct {
-	CheckpointInterval  int64         `mapstructure:"checkpoint-interval" toml:"checkpoint-interval" comment:"CheckpointInterval set the interval of the checkpoint."`
-	CheckpointMemory    uint64        `mapstructure:"checkpoint-memory" toml:"checkpoint-memory" comment:"CheckpointMemory set the memory of the checkpoint."`
-	StateStorage        bool          `mapstructure:"state-storage" toml:"state-storage" comment:"StateStorage set the state storage."`
-	HeightFilter        int8          `mapstructure:"height-filter" toml:"height-filter" comment:"HeightFilter set the height filter."`
-	EvictionDepth       int8          `mapstructure:"eviction-depth" toml:"eviction-depth" comment:"EvictionDepth set the eviction depth."`
-	MetricsProxy        metrics.Proxy `mapstructure:"metrics-proxy" toml:"metrics-proxy" comment:"MetricsProxy set the metrics proxy."`
-	PruneRatio          float64       `mapstructure:"prune-ratio" toml:"prune-ratio" comment:"PruneRatio set the prune ratio."`
-	MinimumKeepVersions int64         `mapstructure:"minimum-keep-versions" toml:"minimum-keep-versions" comment:"MinimumKeepVersions set the minimum keep versions."`
+	CheckpointInterval   int64          `mapstructure:"checkpoint-interval" toml:"checkpoint-interval" comment:"CheckpointInterval set the number of versions between two checkpoints of the tree to SQLite, 0 disables periodic checkpoints."`
+	CheckpointMemory     uint64         `mapstructure:"checkpoint-memory" toml:"checkpoint-memory" comment:"CheckpointMemory set the memory of the checkpoint."`
+	StateStorage         bool           `mapstructure:"state-storage" toml:"state-storage" comment:"StateStorage set the state storage."`
+	HeightFilter         int8           `mapstructure:"height-filter" toml:"height-filter" comment:"HeightFilter set the height filter."`
+	EvictionDepth        int8           `mapstructure:"eviction-depth" toml:"eviction-depth" comment:"EvictionDepth set the eviction depth."`
+	MetricsProxy         metrics.Proxy  `mapstructure:"metrics-proxy" toml:"metrics-proxy" comment:"MetricsProxy set the metrics proxy."`
+	PruneRatio           float64        `mapstructure:"prune-ratio" toml:"prune-ratio" comment:"PruneRatio set the prune ratio."`
+	MinimumKeepVersions  int64          `mapstructure:"minimum-keep-versions" toml:"minimum-keep-versions" comment:"MinimumKeepVersions set the minimum keep versions."`
+	ClonePoolSize        int            `mapstructure:"clone-pool-size" toml:"clone-pool-size" comment:"ClonePoolSize set the maximum number of readonly clones kept open to serve historical reads, 0 disables the pool."`
+	AutoCompactThreshold float64        `mapstructure:"auto-compact-threshold" toml:"auto-compact-threshold" comment:"AutoCompactThreshold set the ratio of free SQLite pages above which the tree is compacted after pruning, 0 disables the automatic compaction."`
+	PreloadDepth         int8           `mapstructure:"preload-depth" toml:"preload-depth" comment:"PreloadDepth set the number of levels of the tree whose nodes are loaded when the tree is loaded, so that the first reads hit warm nodes, 0 disables the preloading."`
+	ProofCacheSize       int            `mapstructure:"proof-cache-size" toml:"proof-cache-size" comment:"ProofCacheSize set the maximum number of proofs cached by version and key to serve repeated proof requests, 0 disables the cache."`
+	SlowCommitThreshold  time.Duration  `mapstructure:"slow-commit-threshold" toml:"slow-commit-threshold" comment:"SlowCommitThreshold set the duration above which a commit is logged as slow with its version and number of writes, 0 disables the logging."`
+	Pruning              PruningOptions `mapstructure:"pruning" toml:"pruning" comment:"Pruning set the retention policy of the versions of the tree, applied on commit."`
+	// Pragmas does not support synchronous: IAVL v2 runs PRAGMA synchronous=OFF on
+	// every write connection it opens, after the options of the tree are applied,
+	// and exposes no option to change it, so the pragma is rejected rather than
+	// silently overridden. See Tree.Checkpoint to flush a version to the database
+	// files.
+	Pragmas map[string]string `mapstructure:"pragmas" toml:"pragmas" comment:"Pragmas set the SQLite pragmas of the tree among journal_mode (wal or delete), mmap_size and wal_autocheckpoint, journal_mode applies to the existing databases when the tree is opened."`
+}
+
+// PruningOptions is the retention policy of the versions of a tree, applied when
+// committing. IAVL v2 deletes the versions up to a given version, so only the most
+// recent snapshot version older than the recent versions is retained, along with
+// the versions following it.
+type PruningOptions struct {
+	KeepRecent uint64 `mapstructure:"keep-recent" toml:"keep-recent" comment:"KeepRecent set the number of versions retained before the latest one, 0 disables the pruning on commit."`
+	KeepEvery  uint64 `mapstructure:"keep-every" toml:"keep-every" comment:"KeepEvery set the interval of the snapshot versions, the latest snapshot version older than the recent versions is retained, 0 disables the snapshots."`
+}
+
+// PruneTo returns the version up to which the versions are deleted once the given
+// version is committed, and false if no version is to be deleted.
+func (o PruningOptions) PruneTo(version uint64) (uint64, bool) {
+	if o.KeepRecent == 0 || version <= o.KeepRecent+1 {
+		return 0, false
+	}
+	pruneTo := version - o.KeepRecent - 1
+	if o.KeepEvery != 0 {
+		// keep the latest snapshot version within the pruned versions
+		snapshot := pruneTo - pruneTo%o.KeepEvery
+		if snapshot == 0 {
+			return 0, false
+		}
+		pruneTo = snapshot - 1
+	}
+	return pruneTo, pruneTo != 0
 }
 
 // ToTreeOptions converts the configuration to IAVL v2 tree options.
@@ -31,7 +85,48 @@ func (c *Config) ToTreeOptions() iavl.TreeOptions {
 	}
 }
 
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpoint interval must not be negative, got %d", c.CheckpointInterval)
+	}
+	if c.ClonePoolSize < 0 {
+		return fmt.Errorf("clone pool size must not be negative, got %d", c.ClonePoolSize)
+	}
+	if c.ProofCacheSize < 0 {
+		return fmt.Errorf("proof cache size must not be negative, got %d", c.ProofCacheSize)
+	}
+	if c.SlowCommitThreshold < 0 {
+		return fmt.Errorf("slow commit threshold must not be negative, got %v", c.SlowCommitThreshold)
+	}
+	if c.AutoCompactThreshold < 0 || c.AutoCompactThreshold >= 1 {
+		return fmt.Errorf("auto compact threshold must be in [0, 1), got %v", c.AutoCompactThreshold)
+	}
+	if c.PreloadDepth < 0 || c.PreloadDepth > maxPreloadDepth {
+		return fmt.Errorf("preload depth must be in [0, %d], got %d", maxPreloadDepth, c.PreloadDepth)
+	}
+	if c.Pruning.KeepEvery != 0 && c.Pruning.KeepRecent == 0 {
+		return fmt.Errorf("pruning keep every %d requires keep recent to be set", c.Pruning.KeepEvery)
+	}
+	for name, value := range c.Pragmas {
+		switch name {
+		case "journal_mode":
+			if !slices.Contains(journalModes, strings.ToLower(value)) {
+				return fmt.Errorf("invalid journal_mode pragma %q, expected one of %v", value, journalModes)
+			}
+		case "mmap_size", "wal_autocheckpoint":
+			if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+				return fmt.Errorf("invalid %s pragma %q: %w", name, value, err)
+			}
+		default:
+			return fmt.Errorf("unsupported SQLite pragma %q", name)
+		}
+	}
+	return nil
+}
+
 // DefaultConfig returns the default configuration for the IAVL tree.
+// The tree is checkpointed to SQLite every 200 versions by default.
 func DefaultConfig() Config {
 	defaultOptions := iavl.DefaultTreeOptions()
 
@@ -44,5 +139,6 @@ func DefaultConfig() Config {
 		MetricsProxy:        defaultOptions.MetricsProxy,
 		PruneRatio:          1,
 		MinimumKeepVersions: defaultOptions.MinimumKeepVersions,
+		ClonePoolSize:       8,
 	}
 }
@@ -0,0 +1,378 @@
+package iavlv2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"unsafe"
+
+	"github.com/cosmos/iavl/v2"
+
+	"cosmossdk.io/store/v2/commitment"
+	snapshotstypes "cosmossdk.io/store/v2/snapshots/types"
+)
+
+// ExportDiff returns an exporter streaming the nodes of the tree at toVersion
+// which are not in the tree at fromVersion, so that a consumer holding the tree at
+// fromVersion receives an incremental snapshot instead of a full one, see
+// ImportDiff.
+//
+// The nodes are streamed in depth-first post-order like Export. Each subtree left
+// unchanged since fromVersion is replaced by references to its nodes at fromVersion:
+// its leftmost leaf, then its root if it is not a leaf. The references are the
+// items of version not greater than fromVersion, the nodes of IAVL being immutable;
+// they carry the key, version and height of the node but no value. The walk stops
+// at the unchanged subtrees, so that only the changed nodes of the tree at
+// toVersion and the edges of the unchanged subtrees are read.
+func (t *Tree) ExportDiff(fromVersion, toVersion uint64) (commitment.Exporter, error) {
+	if err := isHighBitSet(toVersion); err != nil {
+		return nil, err
+	}
+	if fromVersion > toVersion {
+		return nil, fmt.Errorf("export diff: from version %d is greater than to version %d; path=%s", fromVersion, toVersion, t.path)
+	}
+	if err := t.checkOpen("export diff"); err != nil {
+		return nil, err
+	}
+	if err := t.checkPruned("export diff", int64(fromVersion)); err != nil {
+		return nil, err
+	}
+	if layout.err != nil {
+		return nil, fmt.Errorf("export diff: unsupported version of IAVL v2, cannot read its nodes; path=%s: %w", t.path, layout.err)
+	}
+	to, h := int64(toVersion), t.tree.Version()
+	if to > h {
+		return nil, fmt.Errorf("export diff: cannot export future version %d; h: %d path=%s: %w", to, h, t.path, ErrFutureVersion)
+	}
+	cloned, err := t.loadClone(to)
+	if err != nil {
+		return nil, err
+	}
+	if isEmpty(cloned) {
+		return &EmptyExporter{}, cloned.Close()
+	}
+	reader, err := newNodeReader(t.dbOptions.Path)
+	if err != nil {
+		return nil, errors.Join(err, cloned.Close())
+	}
+	root := *(**iavl.Node)(unsafe.Add(unsafe.Pointer(cloned), layout.root))
+	return &DiffExporter{
+		clone:  cloned,
+		reader: reader,
+		from:   int64(fromVersion),
+		stack:  []diffFrame{{node: root}},
+	}, nil
+}
+
+// diffFrame is a node of the tree exported by a DiffExporter, expanded once its
+// children are pushed onto the stack.
+type diffFrame struct {
+	node     *iavl.Node
+	expanded bool
+}
+
+// DiffExporter is the exporter returned by ExportDiff.
+type DiffExporter struct {
+	// clone is the readonly clone of the tree loaded at the to version, whose
+	// nodes are read by reader.
+	clone  *iavl.Tree
+	reader *nodeReader
+	from   int64
+
+	// stack holds the nodes of the walk in depth-first post-order.
+	stack []diffFrame
+	// queue holds the items ready to be returned by Next.
+	queue []*snapshotstypes.SnapshotIAVLItem
+}
+
+// Next returns the next item of the diff.
+func (e *DiffExporter) Next() (*snapshotstypes.SnapshotIAVLItem, error) {
+	for len(e.queue) == 0 {
+		if len(e.stack) == 0 {
+			return nil, commitment.ErrorExportDone
+		}
+		frame := &e.stack[len(e.stack)-1]
+		node := frame.node
+		switch {
+		case node.Version() <= e.from:
+			// the subtree is unchanged, the nodes of IAVL being immutable
+			e.stack = e.stack[:len(e.stack)-1]
+			if node.Height() > 0 {
+				first, err := e.reader.edge(node, false)
+				if err != nil {
+					return nil, err
+				}
+				e.queue = append(e.queue, diffRef(first))
+			}
+			e.queue = append(e.queue, diffRef(node))
+		case node.Height() == 0:
+			e.stack = e.stack[:len(e.stack)-1]
+			e.queue = append(e.queue, &snapshotstypes.SnapshotIAVLItem{
+				Key:     node.Key(),
+				Value:   node.Value(),
+				Version: node.Version(),
+			})
+		case !frame.expanded:
+			frame.expanded = true
+			left, err := e.reader.child(node, false)
+			if err != nil {
+				return nil, err
+			}
+			right, err := e.reader.child(node, true)
+			if err != nil {
+				return nil, err
+			}
+			e.stack = append(e.stack, diffFrame{node: right}, diffFrame{node: left})
+		default:
+			e.stack = e.stack[:len(e.stack)-1]
+			e.queue = append(e.queue, &snapshotstypes.SnapshotIAVLItem{
+				Key:     node.Key(),
+				Version: node.Version(),
+				Height:  int32(node.Height()),
+			})
+		}
+	}
+	item := e.queue[0]
+	e.queue = e.queue[1:]
+	return item, nil
+}
+
+// diffRef returns the reference to the given unchanged node.
+func diffRef(node *iavl.Node) *snapshotstypes.SnapshotIAVLItem {
+	return &snapshotstypes.SnapshotIAVLItem{Key: node.Key(), Version: node.Version(), Height: int32(node.Height())}
+}
+
+// Close closes the exporter and the clone of the tree.
+func (e *DiffExporter) Close() error {
+	e.stack, e.queue = nil, nil
+	return errors.Join(e.reader.Close(), e.clone.Close())
+}
+
+// ImportDiff returns an importer which applies the items of
+// ExportDiff(fromVersion, toVersion) onto the tree, moving it from fromVersion to
+// toVersion. The tree must be at fromVersion, its latest version, without
+// uncommitted changes, and fromVersion must be a checkpoint, e.g. restored by
+// Import or a previous ImportDiff, so that the unchanged subtrees the diff refers
+// to are saved. The changed nodes are written next to them, and the nodes of
+// fromVersion left out of toVersion are orphaned at toVersion like by a Commit.
+// The tree must not be written to until the importer is committed or closed; once
+// committed, the tree is reopened at toVersion, saved as a checkpoint, the
+// versions in between being skipped.
+func (t *Tree) ImportDiff(fromVersion, toVersion uint64) (_ commitment.Importer, err error) {
+	if err := isHighBitSet(toVersion); err != nil {
+		return nil, err
+	}
+	if fromVersion >= toVersion {
+		return nil, fmt.Errorf("import diff: from version %d is not less than to version %d; path=%s", fromVersion, toVersion, t.path)
+	}
+	if err := t.checkWritable("import diff"); err != nil {
+		return nil, err
+	}
+	if layout.err != nil {
+		return nil, fmt.Errorf("import diff: unsupported version of IAVL v2, cannot read its nodes; path=%s: %w", t.path, layout.err)
+	}
+	if t.pendingSets+t.pendingRemoves > 0 {
+		return nil, fmt.Errorf("import diff: tree has uncommitted changes; path=%s", t.path)
+	}
+	from := int64(fromVersion)
+	latest, err := latestVersion(t.dbOptions.Path)
+	if err != nil {
+		return nil, err
+	}
+	if v := t.tree.Version(); v != from || latest != from {
+		return nil, fmt.Errorf("import diff: tree must be at version %d, found version %d, latest %d; path=%s", from, v, latest, t.path)
+	}
+	if checkpoint, err := isCheckpoint(t.dbOptions.Path, from); err != nil {
+		return nil, err
+	} else if !checkpoint {
+		return nil, fmt.Errorf("import diff: version %d is not a checkpoint, its nodes are not saved; path=%s", from, t.path)
+	}
+	// IAVL v2 reads the nodes of a version from the latest shard not newer than it,
+	// i.e. the latest shard for the versions after the latest one
+	shards, err := shardVersions(t.dbOptions.Path)
+	if err != nil {
+		return nil, err
+	}
+	unlocked, err := unlockedShards(t.dbOptions.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("import diff: no shard found; path=%s", t.path)
+	}
+	shard := slices.Max(shards)
+	if shard > from || !slices.Contains(unlocked, shard) {
+		return nil, fmt.Errorf("import diff: shard %d cannot be written, it is newer than version %d or locked by a pruning; path=%s", shard, from, t.path)
+	}
+
+	reader, err := newNodeReader(t.dbOptions.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, reader.Close())
+		}
+	}()
+	root, err := reader.root(from)
+	if err != nil {
+		return nil, err
+	}
+	importer, err := newExtendingImporter(t, from, int64(toVersion), shard)
+	if err != nil {
+		return nil, fmt.Errorf("import diff: failed to open the shard %d; path=%s: %w", shard, t.path, err)
+	}
+	return &DiffImporter{
+		importer: importer,
+		reader:   reader,
+		from:     from,
+		root:     root,
+		reused:   make(map[iavl.NodeKey]struct{}),
+	}, nil
+}
+
+// DiffImporter is the importer returned by ImportDiff.
+type DiffImporter struct {
+	importer *Importer
+	// reader reads the nodes of the tree at from, rooted at root, the unchanged
+	// subtrees being resolved from it.
+	reader *nodeReader
+	from   int64
+	root   *iavl.Node
+
+	// pending is the leaf referred to last, either an unchanged subtree or the
+	// leftmost leaf of the unchanged subtree referred to next.
+	pending *iavl.Node
+	// reused holds the keys of the roots of the unchanged subtrees.
+	reused map[iavl.NodeKey]struct{}
+}
+
+// Add adds the given item of the diff to the importer, the unchanged subtree the
+// item refers to being resolved in the tree at the from version.
+func (i *DiffImporter) Add(item *snapshotstypes.SnapshotIAVLItem) error {
+	if item == nil {
+		return errors.New("import node cannot be nil")
+	}
+	if item.Version > i.from {
+		if err := i.flush(); err != nil {
+			return err
+		}
+		return i.importer.Add(item)
+	}
+	node, err := i.resolve(item)
+	if err != nil {
+		return err
+	}
+	if node.Height() == 0 {
+		if err := i.flush(); err != nil {
+			return err
+		}
+		i.pending = node
+		return nil
+	}
+	first, err := i.reader.edge(node, false)
+	if err != nil {
+		return err
+	}
+	if i.pending == nil || nodeKeyOf(first) != nodeKeyOf(i.pending) {
+		return fmt.Errorf("import diff: node %X at height %d is not preceded by its leftmost leaf", item.Key, item.Height)
+	}
+	last, err := i.reader.edge(node, true)
+	if err != nil {
+		return err
+	}
+	i.pending = nil
+	return i.addSubtree(node, first.Key(), last.Key())
+}
+
+// resolve returns the node of the tree at the from version referred to by the
+// given item, searching the tree for its key down to its height.
+func (i *DiffImporter) resolve(item *snapshotstypes.SnapshotIAVLItem) (node *iavl.Node, err error) {
+	if node = i.root; node == nil {
+		return nil, fmt.Errorf("import diff: node %X at height %d not found, version %d is empty", item.Key, item.Height, i.from)
+	}
+	for int32(node.Height()) > item.Height {
+		if node, err = i.reader.child(node, bytes.Compare(item.Key, node.Key()) >= 0); err != nil {
+			return nil, err
+		}
+	}
+	if int32(node.Height()) != item.Height || !bytes.Equal(node.Key(), item.Key) {
+		return nil, fmt.Errorf("import diff: node %X at height %d not found at version %d", item.Key, item.Height, i.from)
+	}
+	if node.Version() != item.Version {
+		return nil, fmt.Errorf("import diff: node %X at height %d has version %d at version %d, expected %d",
+			item.Key, item.Height, node.Version(), i.from, item.Version)
+	}
+	return node, nil
+}
+
+// flush adds the pending leaf as an unchanged subtree.
+func (i *DiffImporter) flush() error {
+	if i.pending == nil {
+		return nil
+	}
+	leaf := i.pending
+	i.pending = nil
+	return i.addSubtree(leaf, leaf.Key(), leaf.Key())
+}
+
+// addSubtree adds the unchanged subtree of the given root, whose leaves have keys
+// from minKey to maxKey, to the importer.
+func (i *DiffImporter) addSubtree(root *iavl.Node, minKey, maxKey []byte) error {
+	bz, err := root.Bytes()
+	if err != nil {
+		return err
+	}
+	nk := nodeKeyOf(root)
+	i.reused[nk] = struct{}{}
+	return i.importer.addSubtree(importNode{
+		nodeKey: nk,
+		height:  root.Height(),
+		size:    nodeSize(root),
+		hash:    root.GetHash(),
+		bytes:   bz,
+		minKey:  minKey,
+	}, maxKey)
+}
+
+// Commit orphans the nodes of the tree at the from version which are not in the
+// unchanged subtrees and commits the importer.
+func (i *DiffImporter) Commit() error {
+	if err := i.flush(); err != nil {
+		return err
+	}
+	var branches, leaves []iavl.NodeKey
+	var stack []*iavl.Node
+	if i.root != nil {
+		stack = append(stack, i.root)
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := i.reused[nodeKeyOf(node)]; ok {
+			continue
+		}
+		if node.Height() == 0 {
+			leaves = append(leaves, nodeKeyOf(node))
+			continue
+		}
+		branches = append(branches, nodeKeyOf(node))
+		for _, right := range []bool{false, true} {
+			child, err := i.reader.child(node, right)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, child)
+		}
+	}
+	if err := i.importer.addOrphans(branches, leaves); err != nil {
+		return err
+	}
+	return i.importer.Commit()
+}
+
+// Close closes the importer. The nodes of an uncommitted import are deleted.
+func (i *DiffImporter) Close() error {
+	return errors.Join(i.importer.Close(), i.reader.Close())
+}
@@ -0,0 +1,19 @@
+package iavlv2
+
+import "fmt"
+
+// DryRunCommit returns the root hash the tree would have once the uncommitted
+// changes are committed, without committing them: the version is not advanced and
+// the uncommitted changes are left untouched, so that Commit can follow. The hash
+// is computed from the staged root in memory, like WorkingHash, the hashes of the
+// unchanged nodes evicted from memory being read from the databases.
+func (t *Tree) DryRunCommit() ([]byte, error) {
+	if err := t.checkWritable("dry run commit"); err != nil {
+		return nil, err
+	}
+	hash, err := t.stagedHash()
+	if err != nil {
+		return nil, fmt.Errorf("dry run commit: %w", err)
+	}
+	return hash, nil
+}
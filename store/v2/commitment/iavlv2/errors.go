@@ -0,0 +1,43 @@
+package iavlv2
+
+import "errors"
+
+var (
+	// ErrFutureVersion is returned when reading a version newer than the latest
+	// version of the tree.
+	ErrFutureVersion = errors.New("future version")
+
+	// ErrVersionPruned is returned when reading a version older than the earliest
+	// version retained by the tree.
+	ErrVersionPruned = errors.New("version pruned")
+
+	// ErrVersionNotFound is returned when deleting a version which has not been
+	// saved or has already been deleted.
+	ErrVersionNotFound = errors.New("version not found")
+
+	// ErrCorruptVersion is returned when loading a version which is saved but whose
+	// nodes are missing, e.g. after an interrupted pruning. The tree must then be
+	// resynced, e.g. from a snapshot.
+	ErrCorruptVersion = errors.New("corrupt version")
+
+	// ErrRootMismatch is returned when committing an import whose root hash is not
+	// the expected one.
+	ErrRootMismatch = errors.New("root hash mismatch")
+
+	// ErrNilValue is returned when setting a nil value, a key being deleted by
+	// Remove only.
+	ErrNilValue = errors.New("nil value")
+
+	// ErrReadOnly is returned when writing to a tree opened in read-only mode.
+	ErrReadOnly = errors.New("tree is read-only")
+
+	// ErrClosed is returned when using a tree which has been closed.
+	ErrClosed = errors.New("tree is closed")
+
+	// ErrEmptyRange is returned when proving a range of keys which holds no key.
+	ErrEmptyRange = errors.New("empty range")
+
+	// ErrSchemaMismatch is returned when opening a tree whose SQLite databases do
+	// not have the schema of IAVL v2.0.0-alpha.4, which the tree writes to directly.
+	ErrSchemaMismatch = errors.New("schema mismatch")
+)
@@ -0,0 +1,84 @@
+package iavlv2
+
+import (
+	"math"
+	"slices"
+	"sync"
+)
+
+// Histogram is a snapshot of the distribution of observed values, counted in
+// buckets of growing upper bounds.
+type Histogram struct {
+	// Bounds are the inclusive upper bounds of the buckets, in increasing order.
+	Bounds []float64
+	// Counts are the number of observations of each bucket, the last one counting
+	// the observations above the last bound.
+	Counts []uint64
+	// Count is the total number of observations.
+	Count uint64
+	// Sum is the sum of the observed values.
+	Sum float64
+	// Max is the largest observed value.
+	Max float64
+}
+
+// Quantile returns an estimate of the q-quantile of the observations, 0 < q <= 1,
+// as the upper bound of the bucket it falls in, capped to the largest observed
+// value. It returns 0 if there is no observation.
+func (h Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := min(max(uint64(math.Ceil(q*float64(h.Count))), 1), h.Count)
+	var cumulative uint64
+	for i, count := range h.Counts {
+		cumulative += count
+		if cumulative >= rank && i < len(h.Bounds) {
+			return min(h.Bounds[i], h.Max)
+		}
+	}
+	return h.Max
+}
+
+// histogram records observations, it is safe for concurrent use.
+type histogram struct {
+	mtx    sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+	max    float64
+}
+
+// newHistogram returns a histogram of n buckets, the upper bound of the first
+// one being start and each following bound doubling the previous one.
+func newHistogram(start float64, n int) *histogram {
+	bounds := make([]float64, n)
+	for i := range bounds {
+		bounds[i] = start
+		start *= 2
+	}
+	return &histogram{bounds: bounds, counts: make([]uint64, n+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.max = max(h.max, v)
+}
+
+func (h *histogram) snapshot() Histogram {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return Histogram{
+		Bounds: h.bounds,
+		Counts: slices.Clone(h.counts),
+		Count:  h.count,
+		Sum:    h.sum,
+		Max:    h.max,
+	}
+}
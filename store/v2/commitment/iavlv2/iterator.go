@@ -0,0 +1,182 @@
+package iavlv2
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	corestore "cosmossdk.io/core/store"
+)
+
+// BufferedIterator returns an iterator over the domain [start, end) of the tree at
+// the given version, a version of 0 standing for the latest committed version as
+// in Get, which prefetches the pairs bufSize at a time.
+//
+// IAVL v2 reads the nodes from SQLite lazily as the iteration moves on. The
+// buffered iterator reads them in a background goroutine, from a readonly clone of
+// the tree, while the caller consumes the previous batch, so that the range scans
+// spending time on each pair do not wait for SQLite. The gain is bounded by the
+// time the caller spends on the pairs and needs a spare CPU core: on a single core
+// a scan takes as long as with Iterator, see BenchmarkBufferedIterator. The clone
+// is released when the iterator is closed, which must not be forgotten.
+func (t *Tree) BufferedIterator(version uint64, start, end []byte, ascending bool, bufSize int) (corestore.Iterator, error) {
+	if err := isHighBitSet(version); err != nil {
+		return nil, err
+	}
+	if err := t.checkOpen("buffered iterator"); err != nil {
+		return nil, err
+	}
+	if bufSize <= 0 {
+		return nil, fmt.Errorf("buffered iterator: buffer size must be positive, got %d; path=%s", bufSize, t.path)
+	}
+	v := int64(version)
+	h := t.tree.Version()
+	if v == 0 {
+		v = h
+	}
+	if v > h {
+		return nil, fmt.Errorf("buffered iterator: cannot read future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
+	}
+	if err := t.checkPruned("buffered iterator", v); err != nil {
+		return nil, err
+	}
+	itr, err := t.cloneIterator(v, start, end, ascending)
+	if err != nil {
+		return nil, err
+	}
+	return newBufferedIterator(itr, start, end, bufSize), nil
+}
+
+// iteratorBatch is a batch of pairs prefetched by a bufferedIterator, or the error
+// which stopped the prefetching.
+type iteratorBatch struct {
+	pairs []corestore.KVPair
+	err   error
+}
+
+// bufferedIterator is the iterator returned by Tree.BufferedIterator.
+type bufferedIterator struct {
+	start, end []byte
+
+	// batches receives the batches prefetched from the underlying iterator, and is
+	// closed once it is exhausted.
+	batches chan iteratorBatch
+	// done stops the prefetching and stopped is closed once it has stopped, closeErr
+	// being the error of closing the underlying iterator.
+	done      chan struct{}
+	closeOnce sync.Once
+	stopped   chan struct{}
+	closeErr  error
+
+	pairs []corestore.KVPair
+	pos   int
+	err   error
+}
+
+func newBufferedIterator(itr corestore.Iterator, start, end []byte, bufSize int) *bufferedIterator {
+	i := &bufferedIterator{
+		start: start,
+		end:   end,
+		// a batch is prefetched while the previous one is consumed
+		batches: make(chan iteratorBatch, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go i.prefetch(itr, bufSize)
+	i.fetch()
+	return i
+}
+
+// prefetch reads the pairs of itr into batches of bufSize pairs until it is
+// exhausted or the iterator is closed.
+func (i *bufferedIterator) prefetch(itr corestore.Iterator, bufSize int) {
+	defer close(i.stopped)
+	defer func() {
+		i.closeErr = itr.Close()
+	}()
+	defer close(i.batches)
+	send := func(b iteratorBatch) bool {
+		select {
+		case i.batches <- b:
+			return true
+		case <-i.done:
+			return false
+		}
+	}
+	for itr.Valid() {
+		pairs := make([]corestore.KVPair, 0, bufSize)
+		for ; len(pairs) < bufSize && itr.Valid(); itr.Next() {
+			// the pairs outlive the position of the underlying iterator
+			pairs = append(pairs, corestore.KVPair{Key: bytes.Clone(itr.Key()), Value: bytes.Clone(itr.Value())})
+		}
+		if !send(iteratorBatch{pairs: pairs}) {
+			return
+		}
+	}
+	if err := itr.Error(); err != nil {
+		send(iteratorBatch{err: err})
+	}
+}
+
+// fetch moves the iterator to the next prefetched batch.
+func (i *bufferedIterator) fetch() {
+	i.pairs, i.pos = nil, 0
+	b, ok := <-i.batches
+	if !ok {
+		return
+	}
+	i.pairs, i.err = b.pairs, b.err
+}
+
+// Domain implements corestore.Iterator.
+func (i *bufferedIterator) Domain() (start, end []byte) {
+	return i.start, i.end
+}
+
+// Valid implements corestore.Iterator.
+func (i *bufferedIterator) Valid() bool {
+	return i.pos < len(i.pairs)
+}
+
+// Next implements corestore.Iterator.
+func (i *bufferedIterator) Next() {
+	i.assertValid()
+	i.pos++
+	if i.pos == len(i.pairs) {
+		i.fetch()
+	}
+}
+
+// Key implements corestore.Iterator.
+func (i *bufferedIterator) Key() []byte {
+	i.assertValid()
+	return i.pairs[i.pos].Key
+}
+
+// Value implements corestore.Iterator.
+func (i *bufferedIterator) Value() []byte {
+	i.assertValid()
+	return i.pairs[i.pos].Value
+}
+
+// Error implements corestore.Iterator.
+func (i *bufferedIterator) Error() error {
+	return i.err
+}
+
+// Close stops the prefetching and closes the underlying iterator along with the
+// clone of the tree it reads.
+func (i *bufferedIterator) Close() error {
+	i.closeOnce.Do(func() {
+		close(i.done)
+	})
+	<-i.stopped
+	i.pairs, i.pos = nil, 0
+	return i.closeErr
+}
+
+func (i *bufferedIterator) assertValid() {
+	if !i.Valid() {
+		panic("iterator is invalid")
+	}
+}
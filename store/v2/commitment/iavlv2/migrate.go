@@ -0,0 +1,97 @@
+package iavlv2
+
+import (
+	"errors"
+	"fmt"
+
+	"cosmossdk.io/store/v2/commitment"
+)
+
+// migrateLogInterval is the number of migrated keys between two progress logs.
+const migrateLogInterval = 100_000
+
+// Migrate imports the tree of src at the given version, typically an IAVL v1
+// tree, into dst at the same version. The nodes are streamed from the export of
+// src to the importer of dst, keeping their versions, so that dst has the root
+// hash of src.
+//
+// dst must either be empty or already hold the given version, in which case the
+// migration is considered done and nothing is written. The version is only saved
+// once all the nodes are imported, so an interrupted migration leaves no version
+// behind in dst and can be resumed by calling Migrate again.
+func Migrate(src commitment.Tree, dst *Tree, version uint64) (err error) {
+	if err := isHighBitSet(version); err != nil {
+		return err
+	}
+	latest := dst.Version()
+	if latest == version && !isEmpty(dst.tree) {
+		dst.log.Info("tree already migrated", "version", version)
+		return nil
+	}
+	if latest != 0 {
+		return fmt.Errorf("migrate: destination tree must be empty, found version %d; path=%s", latest, dst.path)
+	}
+
+	exporter, err := src.Export(version)
+	if err != nil {
+		return fmt.Errorf("migrate: failed to export source tree at version %d: %w", version, err)
+	}
+	defer func() {
+		err = errors.Join(err, exporter.Close())
+	}()
+
+	// the importer is opened along with the first node, an empty tree is not
+	// imported.
+	var importer commitment.Importer
+	defer func() {
+		if importer != nil {
+			err = errors.Join(err, importer.Close())
+		}
+	}()
+	var count int
+	for {
+		item, err := exporter.Next()
+		if errors.Is(err, commitment.ErrorExportDone) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("migrate: failed to export source tree at version %d: %w", version, err)
+		}
+		if importer == nil {
+			if importer, err = dst.Import(version); err != nil {
+				return err
+			}
+		}
+		if err := importer.Add(item); err != nil {
+			return fmt.Errorf("migrate: failed to import node %X at height %d: %w", item.Key, item.Height, err)
+		}
+		if item.Height != 0 {
+			continue
+		}
+		count++
+		if count%migrateLogInterval == 0 {
+			dst.log.Info("migrating tree", "version", version, "keys", count)
+		}
+	}
+
+	if importer != nil {
+		if err := importer.Commit(); err != nil {
+			return err
+		}
+	} else {
+		// an empty tree has no node to import, committing it at the version is
+		// enough.
+		if err := dst.SetInitialVersion(version); err != nil {
+			return err
+		}
+		if _, _, err := dst.Commit(); err != nil {
+			return err
+		}
+	}
+	if committed := dst.Version(); committed != version {
+		return fmt.Errorf("migrate: committed version %d, expected %d; path=%s", committed, version, dst.path)
+	}
+	dst.log.Info("migrated tree", "version", version, "keys", count)
+
+	return nil
+}
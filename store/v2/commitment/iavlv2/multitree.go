@@ -0,0 +1,264 @@
+package iavlv2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/cosmos/iavl/v2"
+
+	"cosmossdk.io/core/log"
+	"cosmossdk.io/store/v2/proof"
+)
+
+// manifestName is the name of the manifest of a MultiTree, in its directory.
+const manifestName = "manifest.json"
+
+// manifest records the last version committed by all the trees of a MultiTree.
+type manifest struct {
+	Version uint64 `json:"version"`
+	Hash    []byte `json:"hash"`
+}
+
+// MultiTree coordinates the commits of several trees, one per store key, stored
+// in the sub-directories of a common directory named after the store keys.
+//
+// SQLite has no transaction spanning several databases, so the trees are committed
+// one after the other and the version is then recorded in a manifest. A crash in
+// between leaves some trees ahead of the manifest: they are rolled back to the
+// version of the manifest when the MultiTree is opened again, so that all the trees
+// are at the last version committed by CommitAll.
+//
+// Trees opened without a manifest, e.g. existing trees or after the manifest was
+// lost, are never rolled back past the first version: their common version is
+// adopted and recorded in a new manifest, see recoverVersion.
+type MultiTree struct {
+	path  string
+	log   log.Logger
+	trees map[string]*Tree
+	// storeKeys are the store keys of the trees, sorted.
+	storeKeys []string
+	version   uint64
+}
+
+// NewMultiTree opens the trees of the given store keys at path, loading the version
+// of the manifest and rolling back the trees committed past it.
+func NewMultiTree(cfg Config, path string, storeKeys []string, log log.Logger) (_ *MultiTree, err error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	m, found, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	mt := &MultiTree{
+		path:      path,
+		log:       log,
+		trees:     make(map[string]*Tree, len(storeKeys)),
+		storeKeys: slices.Sorted(slices.Values(storeKeys)),
+		version:   m.Version,
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, mt.Close())
+		}
+	}()
+	for _, storeKey := range mt.storeKeys {
+		if _, ok := mt.trees[storeKey]; ok {
+			return nil, fmt.Errorf("duplicate store key %s", storeKey)
+		}
+		tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: filepath.Join(path, storeKey)}, log)
+		if err != nil {
+			return nil, err
+		}
+		mt.trees[storeKey] = tree
+	}
+	if !found {
+		if mt.version, err = mt.recoverVersion(); err != nil {
+			return nil, err
+		}
+	}
+	for _, storeKey := range mt.storeKeys {
+		if err := mt.load(storeKey, mt.trees[storeKey]); err != nil {
+			return nil, err
+		}
+	}
+	if !found && mt.version != 0 {
+		if err := writeManifest(path, manifest{Version: mt.version, Hash: mt.hash()}); err != nil {
+			return nil, fmt.Errorf("failed to write the manifest; path=%s: %w", path, err)
+		}
+		log.Info("recorded the version of the trees in a new manifest", "version", mt.version)
+	}
+	return mt, nil
+}
+
+// recoverVersion returns the version to load in the trees opened without a
+// manifest: their version if they are all at the same one, else 0 if none of them
+// is past the first version, as after a crash in the first CommitAll. Trees at
+// different versions past the first one are not rolled back, as that would delete
+// committed versions which the lost manifest may have recorded.
+func (mt *MultiTree) recoverVersion() (uint64, error) {
+	var lowest, highest int64
+	for i, storeKey := range mt.storeKeys {
+		latest, err := latestVersion(mt.trees[storeKey].path)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || latest < lowest {
+			lowest = latest
+		}
+		highest = max(highest, latest)
+	}
+	switch {
+	case lowest == highest:
+		return uint64(lowest), nil
+	case highest <= 1:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("no manifest found and the trees are at versions %d to %d, cannot tell the last committed version; path=%s",
+			lowest, highest, mt.path)
+	}
+}
+
+// load loads the version of the manifest in the tree of the given store key,
+// rolling back the versions committed past it.
+func (mt *MultiTree) load(storeKey string, tree *Tree) error {
+	latest, err := latestVersion(tree.path)
+	if err != nil {
+		return err
+	}
+	switch v := uint64(latest); {
+	case v > mt.version:
+		mt.log.Warn("rolling back partially committed tree", "store_key", storeKey, "version", v, "manifest_version", mt.version)
+		return tree.LoadVersionForOverwriting(mt.version)
+	case v < mt.version:
+		return fmt.Errorf("tree %s is at version %d, behind the manifest version %d; path=%s", storeKey, v, mt.version, mt.path)
+	case v == 0:
+		return nil
+	default:
+		return tree.LoadVersion(v)
+	}
+}
+
+// hash returns the root hash of the commit info of the trees at their latest
+// version, as returned by CommitAll.
+func (mt *MultiTree) hash() []byte {
+	storeInfos := make([]*proof.StoreInfo, 0, len(mt.storeKeys))
+	for _, storeKey := range mt.storeKeys {
+		tree := mt.trees[storeKey]
+		storeInfos = append(storeInfos, &proof.StoreInfo{
+			Name:     storeKey,
+			CommitId: &proof.CommitID{Version: int64(tree.Version()), Hash: tree.Hash()},
+		})
+	}
+	return (&proof.CommitInfo{Version: int64(mt.version), StoreInfos: storeInfos}).Hash()
+}
+
+// Tree returns the tree of the given store key, or nil if there is none. The tree
+// must not be committed directly.
+func (mt *MultiTree) Tree(storeKey string) *Tree {
+	return mt.trees[storeKey]
+}
+
+// Version returns the last version committed by CommitAll.
+func (mt *MultiTree) Version() uint64 {
+	return mt.version
+}
+
+// CommitAll commits all the trees at the given version, which must follow the
+// last committed version, records it in the manifest and returns the root hash of
+// the commit info of the trees. If a commit fails the trees are left at different
+// versions and must be reopened, which rolls them back to the last version
+// committed by CommitAll.
+func (mt *MultiTree) CommitAll(version uint64) ([]byte, error) {
+	if version != mt.version+1 {
+		return nil, fmt.Errorf("commit all: version %d does not follow the last committed version %d; path=%s", version, mt.version, mt.path)
+	}
+	storeInfos := make([]*proof.StoreInfo, 0, len(mt.storeKeys))
+	for _, storeKey := range mt.storeKeys {
+		h, v, err := mt.trees[storeKey].Commit()
+		if err != nil {
+			return nil, fmt.Errorf("commit all: failed to commit %s; path=%s: %w", storeKey, mt.path, err)
+		}
+		if v != version {
+			return nil, fmt.Errorf("commit all: %s committed version %d instead of %d; path=%s", storeKey, v, version, mt.path)
+		}
+		storeInfos = append(storeInfos, &proof.StoreInfo{
+			Name:     storeKey,
+			CommitId: &proof.CommitID{Version: int64(v), Hash: h},
+		})
+	}
+	cInfo := &proof.CommitInfo{Version: int64(version), StoreInfos: storeInfos}
+	hash := cInfo.Hash()
+	if err := writeManifest(mt.path, manifest{Version: version, Hash: hash}); err != nil {
+		return nil, fmt.Errorf("commit all: failed to write the manifest; path=%s: %w", mt.path, err)
+	}
+	mt.version = version
+	return hash, nil
+}
+
+// Close closes all the trees.
+func (mt *MultiTree) Close() error {
+	var err error
+	for _, tree := range mt.trees {
+		err = errors.Join(err, tree.Close())
+	}
+	return err
+}
+
+// readManifest reads the manifest at path, returning false if there is none.
+func readManifest(path string) (m manifest, found bool, err error) {
+	bz, err := os.ReadFile(filepath.Join(path, manifestName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return m, false, nil
+		}
+		return m, false, err
+	}
+	if err := json.Unmarshal(bz, &m); err != nil {
+		return m, false, fmt.Errorf("invalid manifest; path=%s: %w", path, err)
+	}
+	return m, true, nil
+}
+
+// writeManifest atomically replaces the manifest at path, through a synced
+// temporary file renamed over it, the directory being synced for the rename to
+// survive a crash.
+func writeManifest(path string, m manifest) (err error) {
+	bz, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(path, manifestName+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the temporary file is gone once renamed
+		if err != nil {
+			if rmErr := os.Remove(f.Name()); !errors.Is(rmErr, os.ErrNotExist) {
+				err = errors.Join(err, rmErr)
+			}
+		}
+	}()
+	if _, err := f.Write(bz); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(path, manifestName)); err != nil {
+		return err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return errors.Join(dir.Sync(), dir.Close())
+}
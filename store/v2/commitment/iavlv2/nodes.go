@@ -0,0 +1,219 @@
+package iavlv2
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"unsafe"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/cosmos/iavl/v2"
+)
+
+// IAVL v2 keeps the roots of a tree, including the staged root holding the
+// uncommitted changes, and the children and sizes of the nodes unexported as of
+// v2.0.0-alpha.4, and only hashes the staged root when saving a version, which
+// also queues the new nodes to be saved. The nodes are thus read through the
+// offsets of their fields, checked once against the layout of the types of
+// alpha.4, without being mutated.
+
+// nodeLayout holds the offsets of the unexported fields of iavl.Tree and
+// iavl.Node read to walk the nodes of a tree.
+type nodeLayout struct {
+	// err is set if the types of IAVL v2 do not have the expected fields, the
+	// nodes then cannot be walked.
+	err error
+
+	root         uintptr
+	stagedRoot   uintptr
+	nodeKey      uintptr
+	size         uintptr
+	leftNode     uintptr
+	rightNode    uintptr
+	leftNodeKey  uintptr
+	rightNodeKey uintptr
+}
+
+var layout = newNodeLayout()
+
+func newNodeLayout() (l nodeLayout) {
+	treeType, nodeType := reflect.TypeOf(iavl.Tree{}), reflect.TypeOf(iavl.Node{})
+	nodePtr, nodeKey := reflect.TypeOf((*iavl.Node)(nil)), reflect.TypeOf(iavl.NodeKey{})
+	var errs []error
+	offset := func(typ reflect.Type, name string, want reflect.Type) uintptr {
+		f, ok := typ.FieldByName(name)
+		if !ok || f.Type != want || len(f.Index) != 1 {
+			errs = append(errs, fmt.Errorf("%s has no field %s of type %s", typ, name, want))
+			return 0
+		}
+		return f.Offset
+	}
+	l.root = offset(treeType, "root", nodePtr)
+	l.stagedRoot = offset(treeType, "stagedRoot", nodePtr)
+	l.nodeKey = offset(nodeType, "nodeKey", nodeKey)
+	l.size = offset(nodeType, "size", reflect.TypeOf(int64(0)))
+	l.leftNode = offset(nodeType, "leftNode", nodePtr)
+	l.rightNode = offset(nodeType, "rightNode", nodePtr)
+	l.leftNodeKey = offset(nodeType, "leftNodeKey", nodeKey)
+	l.rightNodeKey = offset(nodeType, "rightNodeKey", nodeKey)
+	l.err = errors.Join(errs...)
+	return l
+}
+
+// nodeReader reads the nodes of a tree from its shards, keeping a connection to
+// each shard read open until it is closed. It reads the unexported fields of the
+// nodes, see nodeLayout.
+type nodeReader struct {
+	path   string
+	pool   *iavl.NodePool
+	shards []int64
+	conns  map[int64]*sqlite3.Conn
+}
+
+// newNodeReader returns a reader of the nodes saved to the shards at path, which
+// are not locked by a pruning of IAVL v2.
+func newNodeReader(path string) (*nodeReader, error) {
+	shards, err := unlockedShards(path)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(shards)
+	return &nodeReader{path: path, pool: iavl.NewNodePool(), shards: shards, conns: make(map[int64]*sqlite3.Conn)}, nil
+}
+
+// root returns the root node of the given version, nil if the tree is empty at
+// the version.
+func (r *nodeReader) root(version int64) (*iavl.Node, error) {
+	var (
+		nk    iavl.NodeKey
+		bz    []byte
+		found bool
+	)
+	if err := queryRows(filepath.Join(r.path, rootDbName),
+		"SELECT node_version, node_sequence, bytes FROM root WHERE version = ?",
+		func(q *sqlite3.Stmt) error {
+			var nodeVersion, nodeSequence int64
+			if err := q.Scan(&nodeVersion, &nodeSequence, &bz); err != nil {
+				return err
+			}
+			nk, found = iavl.NewNodeKey(nodeVersion, uint32(nodeSequence)), true
+			return nil
+		}, version); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("root of version %d not found; path=%s: %w", version, r.path, ErrVersionNotFound)
+	}
+	if bz == nil {
+		return nil, nil
+	}
+	return iavl.MakeNode(r.pool, nk, bz)
+}
+
+// node returns the node of the given key. Like IAVL v2, the node is looked up in
+// the latest shard not newer than its version, the other shards being looked up if
+// it is not found, e.g. once copied to a new shard by a pruning, and the leaves are
+// looked up in the branches too, where the imported and checkpointed leaves may be
+// saved.
+func (r *nodeReader) node(nk iavl.NodeKey) (*iavl.Node, error) {
+	if len(r.shards) == 0 {
+		return nil, fmt.Errorf("node %s not found; path=%s", nk, r.path)
+	}
+	first := 0
+	for i, shard := range r.shards {
+		if shard <= nk.Version() {
+			first = i
+		}
+	}
+	shards := append([]int64{r.shards[first]}, r.shards[:first]...)
+	shards = append(shards, r.shards[first+1:]...)
+	tables := []string{"tree"}
+	if nk.Sequence()&leafSequenceBit != 0 {
+		tables = []string{"leaf", "tree"}
+	}
+	for _, shard := range shards {
+		for _, table := range tables {
+			bz, err := r.query(shard, table, nk)
+			if err != nil {
+				return nil, err
+			}
+			if bz != nil {
+				return iavl.MakeNode(r.pool, nk, bz)
+			}
+		}
+	}
+	return nil, fmt.Errorf("node %s not found; path=%s", nk, r.path)
+}
+
+// query returns the bytes of the node of the given key in the given table of a
+// shard, nil if it is not found.
+func (r *nodeReader) query(shard int64, table string, nk iavl.NodeKey) (bz []byte, topErr error) {
+	conn, ok := r.conns[shard]
+	if !ok {
+		var err error
+		if conn, err = sqlite3.Open(shardPath(r.path, shard) + shardSuffix); err != nil {
+			return nil, err
+		}
+		conn.BusyTimeout(busyTimeout)
+		r.conns[shard] = conn
+	}
+	q, err := conn.Prepare(fmt.Sprintf("SELECT bytes FROM %s WHERE version = ? AND sequence = ?", table), nk.Version(), int64(nk.Sequence()))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		topErr = errors.Join(topErr, q.Close())
+	}()
+	hasRow, err := q.Step()
+	if err != nil || !hasRow {
+		return nil, err
+	}
+	return bz, q.Scan(&bz)
+}
+
+// child returns the left or right child of the given node, from memory if it is
+// loaded, from the shards otherwise.
+func (r *nodeReader) child(node *iavl.Node, right bool) (*iavl.Node, error) {
+	nodeOffset, keyOffset := layout.leftNode, layout.leftNodeKey
+	if right {
+		nodeOffset, keyOffset = layout.rightNode, layout.rightNodeKey
+	}
+	ptr := unsafe.Pointer(node)
+	if child := *(**iavl.Node)(unsafe.Add(ptr, nodeOffset)); child != nil {
+		return child, nil
+	}
+	return r.node(*(*iavl.NodeKey)(unsafe.Add(ptr, keyOffset)))
+}
+
+// edge returns the leftmost or rightmost leaf of the subtree of the given node.
+func (r *nodeReader) edge(node *iavl.Node, right bool) (*iavl.Node, error) {
+	for node.Height() > 0 {
+		var err error
+		if node, err = r.child(node, right); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// Close closes the connections to the shards.
+func (r *nodeReader) Close() error {
+	var err error
+	for _, conn := range r.conns {
+		err = errors.Join(err, conn.Close())
+	}
+	r.conns = nil
+	return err
+}
+
+// nodeSize returns the number of leaves of the subtree of the given node.
+func nodeSize(node *iavl.Node) int64 {
+	return *(*int64)(unsafe.Add(unsafe.Pointer(node), layout.size))
+}
+
+// nodeKeyOf returns the key of the given node in the shards of its tree.
+func nodeKeyOf(node *iavl.Node) iavl.NodeKey {
+	return *(*iavl.NodeKey)(unsafe.Add(unsafe.Pointer(node), layout.nodeKey))
+}
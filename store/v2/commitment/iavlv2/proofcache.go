@@ -0,0 +1,121 @@
+package iavlv2
+
+import (
+	"container/list"
+	"sync"
+
+	ics23 "github.com/cosmos/ics23/go"
+)
+
+// proofKey is the key of a proof in the proof cache.
+type proofKey struct {
+	version int64
+	key     string
+}
+
+type cachedProof struct {
+	proofKey
+	proof *ics23.CommitmentProof
+}
+
+// proofCache is an LRU cache of the proofs returned by GetProof keyed by version
+// and key, so that the proofs requested repeatedly, e.g. by relayers querying the
+// same packet commitments, are not rebuilt from the tree each time.
+type proofCache struct {
+	mtx   sync.Mutex
+	size  int
+	lru   *list.List // of *cachedProof, most recently used first
+	items map[proofKey]*list.Element
+}
+
+// newProofCache returns a cache holding at most size proofs. A size of 0 disables
+// the cache.
+func newProofCache(size int) *proofCache {
+	return &proofCache{
+		size:  size,
+		lru:   list.New(),
+		items: make(map[proofKey]*list.Element),
+	}
+}
+
+// get returns the cached proof of key at version, or nil if it is not cached.
+func (c *proofCache) get(version int64, key []byte) *ics23.CommitmentProof {
+	if c.size <= 0 {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.items[proofKey{version, string(key)}]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedProof).proof
+}
+
+// add caches the proof of key at version, evicting the least recently used proofs
+// beyond the size of the cache.
+func (c *proofCache) add(version int64, key []byte, proof *ics23.CommitmentProof) {
+	if c.size <= 0 {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	k := proofKey{version, string(key)}
+	if e, ok := c.items[k]; ok {
+		e.Value.(*cachedProof).proof = proof
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.items[k] = c.lru.PushFront(&cachedProof{proofKey: k, proof: proof})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the proof in e from the cache. c.mtx must be held.
+func (c *proofCache) remove(e *list.Element) {
+	p := c.lru.Remove(e).(*cachedProof)
+	delete(c.items, p.proofKey)
+}
+
+// removeUpTo removes the proofs at versions less than or equal to version.
+func (c *proofCache) removeUpTo(version int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cachedProof).version <= version {
+			c.remove(e)
+		}
+		e = next
+	}
+}
+
+// removeVersion removes the proofs at the given version.
+func (c *proofCache) removeVersion(version int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cachedProof).version == version {
+			c.remove(e)
+		}
+		e = next
+	}
+}
+
+// purge removes all the proofs of the cache.
+func (c *proofCache) purge() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.lru.Init()
+	clear(c.items)
+}
+
+// len returns the number of proofs in the cache.
+func (c *proofCache) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lru.Len()
+}
@@ -1,8 +1,18 @@
 package iavlv2
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
 
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	protoio "github.com/cosmos/gogoproto/io"
 	"github.com/cosmos/iavl/v2"
 
 	"cosmossdk.io/store/v2/commitment"
@@ -12,10 +22,16 @@ import (
 // Exporter is a wrapper around iavl.Exporter.
 type Exporter struct {
 	exporter *iavl.Exporter
+	// ctx stops the export once cancelled.
+	ctx context.Context
 }
 
-// Next returns the next item in the exporter.
+// Next returns the next item in the exporter, or ctx.Err() once the context of
+// the export is cancelled.
 func (e *Exporter) Next() (*snapshotstypes.SnapshotIAVLItem, error) {
+	if err := e.ctx.Err(); err != nil {
+		return nil, err
+	}
 	item, err := e.exporter.Next()
 	if err != nil {
 		if errors.Is(err, iavl.ErrorExportDone) {
@@ -32,28 +48,387 @@ func (e *Exporter) Next() (*snapshotstypes.SnapshotIAVLItem, error) {
 	}, nil
 }
 
-// Close closes the exporter.
+// Close closes the exporter. IAVL v2 cannot stop the goroutine streaming the
+// nodes, which reads them from the clone, so the nodes left are drained first,
+// e.g. after the context of the export is cancelled, not to close the clone
+// underneath it.
 func (e *Exporter) Close() error {
+	for {
+		// the errors are drained along with the nodes
+		if _, err := e.exporter.Next(); errors.Is(err, iavl.ErrorExportDone) {
+			break
+		}
+	}
 	return e.exporter.Close()
 }
 
+// EmptyExporter is an Exporter for an empty tree.
+type EmptyExporter struct{}
+
+// Next returns ExportDone.
+func (e *EmptyExporter) Next() (*snapshotstypes.SnapshotIAVLItem, error) {
+	return nil, commitment.ErrorExportDone
+}
+
+// Close does nothing.
+func (e *EmptyExporter) Close() error {
+	return nil
+}
+
+// WriteSnapshot writes the nodes of the tree at the given version to w as the
+// length-delimited SnapshotItem messages, each holding a SnapshotIAVLItem, which
+// CommitStore.Snapshot writes for a tree after its store item. w is meant to be
+// the snapshot stream, e.g. the zlib stream of the chunks of snapshots.StreamWriter,
+// so that the tree serves state sync like the IAVL v1 trees.
+func (t *Tree) WriteSnapshot(version uint64, w io.Writer) (err error) {
+	exporter, err := t.Export(version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, exporter.Close())
+	}()
+	// the delimited writer is not closed, as closing it would close w
+	protoWriter := protoio.NewDelimitedWriter(w)
+	for {
+		item, err := exporter.Next()
+		if errors.Is(err, commitment.ErrorExportDone) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get the next export node: %w", err)
+		}
+		if err := protoWriter.WriteMsg(&snapshotstypes.SnapshotItem{
+			Item: &snapshotstypes.SnapshotItem_IAVL{IAVL: item},
+		}); err != nil {
+			return fmt.Errorf("failed to write iavl node: %w", err)
+		}
+	}
+}
+
+// leafSequenceBit is set in the sequence of the node keys of the leaves, which IAVL
+// v2 reads from the leaf table of the shards while the branches are read from the
+// tree table.
+const leafSequenceBit = uint32(1 << 31)
+
+// importBatchSize is the number of nodes an Importer writes per SQLite transaction.
+const importBatchSize = 100_000
+
+// Importer restores a tree from the nodes produced by Export. IAVL v2 does not
+// flag the node keys of the leaves it imports as leaves, so that they cannot be
+// read back as of v2.0.0-alpha.4; the importer thus writes the nodes to a new
+// tree shard itself, in the layout of IAVL v2, and the tree is reopened at the
+// imported version once committed. The nodes are validated to form a well-formed
+// tree in depth-first post-order, so that malformed snapshots fail with an error.
 type Importer struct {
-	importer *iavl.Importer
+	version int64
+	// target is the tree imported into and expectedRoot the root hash it must have
+	// once committed, nil if unchecked.
+	target       *Tree
+	expectedRoot []byte
+	// base is the version of the target the import extends, see ImportDiff, 0 if
+	// the target is empty. shard is the version of the shard the nodes are written
+	// to, created by the importer unless the import extends the target.
+	base  int64
+	shard int64
+
+	// conn writes the nodes to the shard of the imported version, nil once the
+	// importer is closed.
+	conn         *sqlite3.Conn
+	leafInsert   *sqlite3.Stmt
+	branchInsert *sqlite3.Stmt
+	// batched is the number of nodes written by the current transaction.
+	batched   int
+	committed bool
+
+	// sequences holds by version the last sequence of the node keys assigned.
+	sequences map[int64]uint32
+	// stack holds the subtrees which are not yet attached to a parent.
+	stack []importNode
+	// lastKey is the key of the last imported leaf node.
+	lastKey []byte
+	// branchOrphans and leafOrphans are the numbers of nodes of the base version
+	// recorded as orphaned by the imported version.
+	branchOrphans int
+	leafOrphans   int
+}
+
+// importNode is the root of a subtree imported by an Importer.
+type importNode struct {
+	nodeKey iavl.NodeKey
+	height  int8
+	size    int64
+	hash    []byte
+	bytes   []byte
+	// minKey is the key of the leftmost leaf of the subtree.
+	minKey []byte
+}
+
+// newImporter returns an importer of the given version into t, creating the shard
+// of the version.
+func newImporter(t *Tree, version int64, expectedRoot []byte) (*Importer, error) {
+	conn, err := createShard(t.dbOptions.Path, version)
+	if err != nil {
+		return nil, err
+	}
+	return startImport(&Importer{
+		version:      version,
+		target:       t,
+		expectedRoot: expectedRoot,
+		shard:        version,
+		conn:         conn,
+		sequences:    make(map[int64]uint32),
+	})
+}
+
+// newExtendingImporter returns an importer of the given version into t, which is
+// at the base version, the nodes being written to the given shard of t, which IAVL
+// v2 reads the nodes of the versions after base from. The nodes left in the shard
+// by an interrupted import are deleted first.
+func newExtendingImporter(t *Tree, base, version, shard int64) (*Importer, error) {
+	if err := deleteVersionsAfter(shardPath(t.dbOptions.Path, shard)+shardSuffix, base); err != nil {
+		return nil, err
+	}
+	conn, err := sqlite3.Open(shardPath(t.dbOptions.Path, shard) + shardSuffix)
+	if err != nil {
+		return nil, err
+	}
+	conn.BusyTimeout(busyTimeout)
+	return startImport(&Importer{
+		version:   version,
+		target:    t,
+		base:      base,
+		shard:     shard,
+		conn:      conn,
+		sequences: make(map[int64]uint32),
+	})
+}
+
+// startImport prepares the statements of the given importer and begins its first
+// transaction.
+func startImport(i *Importer) (_ *Importer, err error) {
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, i.Close())
+		}
+	}()
+	if i.leafInsert, err = i.conn.Prepare("INSERT INTO leaf (version, sequence, bytes) VALUES (?, ?, ?)"); err != nil {
+		return nil, err
+	}
+	if i.branchInsert, err = i.conn.Prepare("INSERT INTO tree (version, sequence, bytes) VALUES (?, ?, ?)"); err != nil {
+		return nil, err
+	}
+	if err = i.conn.Begin(); err != nil {
+		return nil, err
+	}
+	return i, nil
 }
 
 // Add adds the given item to the importer.
 func (i *Importer) Add(item *snapshotstypes.SnapshotIAVLItem) error {
-	return i.importer.Add(iavl.NewImportNode(item.Key, item.Value, item.Version, int8(item.Height)))
+	if i.conn == nil {
+		return errors.New("import: importer is closed")
+	}
+	if err := i.validate(item); err != nil {
+		return err
+	}
+
+	node := importNode{height: int8(item.Height)}
+	i.sequences[item.Version]++
+	seq := i.sequences[item.Version]
+	hash := sha256.New()
+	hash.Write(binary.AppendVarint(nil, int64(node.height)))
+	var children [2]importNode
+	if node.height == 0 {
+		seq |= leafSequenceBit
+		node.size = 1
+		hash.Write(binary.AppendVarint(binary.AppendVarint(nil, node.size), item.Version))
+		valueHash := sha256.Sum256(item.Value)
+		_ = iavl.EncodeBytes(hash, item.Key)
+		_ = iavl.EncodeBytes(hash, valueHash[:])
+		i.lastKey = item.Key
+		node.minKey = item.Key
+	} else {
+		n := len(i.stack)
+		children = [2]importNode{i.stack[n-2], i.stack[n-1]}
+		i.stack = i.stack[:n-2]
+		node.size = children[0].size + children[1].size
+		node.minKey = children[0].minKey
+		hash.Write(binary.AppendVarint(binary.AppendVarint(nil, node.size), item.Version))
+		_ = iavl.EncodeBytes(hash, children[0].hash)
+		_ = iavl.EncodeBytes(hash, children[1].hash)
+	}
+	node.nodeKey = iavl.NewNodeKey(item.Version, seq)
+	node.hash = hash.Sum(nil)
+
+	// the nodes are encoded like iavl.Node.WriteBytes
+	buf := bytes.NewBuffer(binary.AppendVarint(binary.AppendVarint(nil, int64(node.height)), node.size))
+	_ = iavl.EncodeBytes(buf, item.Key)
+	_ = iavl.EncodeBytes(buf, node.hash)
+	insert := i.branchInsert
+	if node.height == 0 {
+		value := item.Value
+		if !i.target.cfg.StateStorage {
+			// IAVL v2 only stores the hash of the values without state storage
+			value = nil
+		}
+		_ = iavl.EncodeBytes(buf, value)
+		insert = i.leafInsert
+	} else {
+		_ = iavl.EncodeBytes(buf, children[0].nodeKey[:])
+		_ = iavl.EncodeBytes(buf, children[1].nodeKey[:])
+	}
+	node.bytes = buf.Bytes()
+
+	if err := insert.Exec(item.Version, int64(seq), node.bytes); err != nil {
+		return fmt.Errorf("import: failed to write node %s: %w", node.nodeKey, err)
+	}
+	if i.batched++; i.batched >= importBatchSize {
+		if err := i.conn.Commit(); err != nil {
+			return err
+		}
+		if err := i.conn.Begin(); err != nil {
+			return err
+		}
+		i.batched = 0
+	}
+	i.stack = append(i.stack, node)
+	return nil
 }
 
-// Commit commits the importer.
-func (i *Importer) Commit() error {
-	return i.importer.Commit()
+// addSubtree adds the given subtree of the base version, whose leaves have keys
+// from node.minKey to maxKey, to the importer. Its nodes are already saved, the
+// imported nodes refer to them.
+func (i *Importer) addSubtree(node importNode, maxKey []byte) error {
+	if i.conn == nil {
+		return errors.New("import: importer is closed")
+	}
+	if i.lastKey != nil && bytes.Compare(node.minKey, i.lastKey) <= 0 {
+		return fmt.Errorf("leaf key %X is not greater than previous leaf key %X", node.minKey, i.lastKey)
+	}
+	i.lastKey = maxKey
+	i.stack = append(i.stack, node)
+	return nil
 }
 
-// Close closes the importer.
-func (i *Importer) Close() error {
-	i.importer.Close()
+// addOrphans records the given branches and leaves of the base version as
+// orphaned by the imported version, so that they are deleted once the versions
+// before it are pruned.
+func (i *Importer) addOrphans(branches, leaves []iavl.NodeKey) error {
+	for table, keys := range map[string][]iavl.NodeKey{"orphan": branches, "leaf_orphan": leaves} {
+		stmt, err := i.conn.Prepare(fmt.Sprintf("INSERT INTO %s (version, sequence, at) VALUES (?, ?, ?)", table))
+		if err != nil {
+			return err
+		}
+		for _, nk := range keys {
+			if err := stmt.Exec(nk.Version(), int64(nk.Sequence()), i.version); err != nil {
+				return errors.Join(err, stmt.Close())
+			}
+		}
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	i.branchOrphans += len(branches)
+	i.leafOrphans += len(leaves)
+	return nil
+}
+
+// validate checks the given item against the nodes imported so far.
+func (i *Importer) validate(item *snapshotstypes.SnapshotIAVLItem) error {
+	if item == nil {
+		return errors.New("import node cannot be nil")
+	}
+	if item.Version < 0 || item.Version > i.version {
+		return fmt.Errorf("node version %d must be within [0, %d]", item.Version, i.version)
+	}
+	if item.Height < 0 || item.Height > math.MaxInt8 {
+		return fmt.Errorf("node height %d must be within [0, %d]", item.Height, math.MaxInt8)
+	}
 
+	height := int8(item.Height)
+	if height == 0 {
+		if i.lastKey != nil && bytes.Compare(item.Key, i.lastKey) <= 0 {
+			return fmt.Errorf("leaf key %X is not greater than previous leaf key %X", item.Key, i.lastKey)
+		}
+		return nil
+	}
+
+	stackSize := len(i.stack)
+	if stackSize < 2 {
+		return fmt.Errorf("inner node at height %d is missing its children", height)
+	}
+	left, right := i.stack[stackSize-2].height, i.stack[stackSize-1].height
+	if height != max(left, right)+1 {
+		return fmt.Errorf("inner node height %d does not match children heights %d and %d", height, left, right)
+	}
+	// the key of an inner node is not hashed, IAVL v2 searches the tree with it:
+	// it must be the leftmost leaf key of its right subtree
+	if minKey := i.stack[stackSize-1].minKey; !bytes.Equal(item.Key, minKey) {
+		return fmt.Errorf("inner node key %X does not match the leftmost leaf key %X of its right child", item.Key, minKey)
+	}
 	return nil
 }
+
+// Commit saves the imported version as a checkpoint of the tree and reopens the
+// tree at it. If the root hash is not the expected one, the imported nodes are
+// deleted, leaving the tree empty.
+func (i *Importer) Commit() error {
+	if i.conn == nil {
+		return errors.New("import: importer is closed")
+	}
+	if len(i.stack) != 1 {
+		return fmt.Errorf("invalid node structure, found %d unattached subtrees when committing", len(i.stack))
+	}
+	t, root := i.target, i.stack[0]
+	if i.expectedRoot != nil && !bytes.Equal(root.hash, i.expectedRoot) {
+		err := fmt.Errorf("import: root %X of version %d is not the expected root %X; path=%s: %w",
+			root.hash, i.version, i.expectedRoot, t.path, ErrRootMismatch)
+		return errors.Join(err, i.Close())
+	}
+	if err := i.conn.Exec("INSERT INTO checkpoints VALUES (?, 0, ?, ?)", i.version, i.branchOrphans, i.leafOrphans); err != nil {
+		return err
+	}
+	if err := i.conn.Exec(`
+CREATE UNIQUE INDEX IF NOT EXISTS leaf_idx ON leaf (version, sequence);
+CREATE INDEX IF NOT EXISTS tree_idx ON tree (version, sequence);`); err != nil {
+		return err
+	}
+	if err := i.conn.Commit(); err != nil {
+		return err
+	}
+	// the version is visible once its root is saved
+	if err := execSqlite(filepath.Join(t.dbOptions.Path, rootDbName), []string{
+		"INSERT INTO root (version, node_version, node_sequence, bytes, checkpoint, pruned) VALUES (?, ?, ?, ?, true, false)",
+	}, i.version, root.nodeKey.Version(), int64(root.nodeKey.Sequence()), root.bytes); err != nil {
+		return fmt.Errorf("import: failed to save the root of version %d; path=%s: %w", i.version, t.path, err)
+	}
+	i.committed = true
+	if err := i.Close(); err != nil {
+		return err
+	}
+	return t.reopen(i.version, func() error { return nil })
+}
+
+// Close closes the importer. The nodes of an uncommitted import are deleted.
+func (i *Importer) Close() error {
+	if i.conn == nil {
+		return nil
+	}
+	var err error
+	for _, stmt := range []*sqlite3.Stmt{i.leafInsert, i.branchInsert} {
+		if stmt != nil {
+			err = errors.Join(err, stmt.Close())
+		}
+	}
+	err = errors.Join(err, i.conn.Close())
+	i.conn = nil
+	switch {
+	case i.committed:
+	case i.base == 0:
+		err = errors.Join(err, removeShard(i.target.dbOptions.Path, i.shard))
+	default:
+		err = errors.Join(err, deleteVersionsAfter(shardPath(i.target.dbOptions.Path, i.shard)+shardSuffix, i.base))
+	}
+	return err
+}
@@ -0,0 +1,899 @@
+package iavlv2
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/cosmos/iavl/v2"
+)
+
+// The file layout below mirrors the one used by iavl.SqliteDb: a root database
+// holding the root node of every version, and tree shards named after the first
+// version they hold.
+const (
+	rootDbName      = "root.sqlite"
+	shardPrefix     = "tree_"
+	shardSuffix     = ".sqlite"
+	shardLockSuffix = ".lock"
+)
+
+// busyTimeout is how long a query waits for the locks held by the connections
+// of the tree, or of its clones, before failing.
+const busyTimeout = 5 * time.Second
+
+// shardVersions returns the versions of the tree shards found at path.
+func shardVersions(path string) ([]int64, error) {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, shardPrefix) || !strings.HasSuffix(name, shardSuffix) {
+			continue
+		}
+		version, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, shardPrefix), shardSuffix), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// shardPath returns the path of the tree shard starting at the given version.
+func shardPath(path string, version int64) string {
+	return filepath.Join(path, fmt.Sprintf("%s%013d", shardPrefix, version))
+}
+
+// execSqlite runs the given statements against the SQLite database at dbPath.
+func execSqlite(dbPath string, stmts []string, args ...interface{}) (topErr error) {
+	conn, err := sqlite3.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	conn.BusyTimeout(busyTimeout)
+	defer func() {
+		topErr = errors.Join(topErr, conn.Close())
+	}()
+	for _, stmt := range stmts {
+		if err := conn.Exec(stmt, args...); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// truncateVersions deletes the data of all the versions greater than the given
+// version from the SQLite databases at path. The tree must be closed.
+func truncateVersions(path string, version int64) error {
+	shards, err := shardVersions(path)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if shard > version {
+			if err := removeShard(path, shard); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := deleteVersionsAfter(shardPath(path, shard)+shardSuffix, version); err != nil {
+			return err
+		}
+	}
+
+	if err := execSqlite(filepath.Join(path, rootDbName), []string{
+		"DELETE FROM root WHERE version > ?",
+	}, version); err != nil {
+		return err
+	}
+	// the latest leaves are the state of the latest version, which is deleted. As
+	// of v2.0.0-alpha.4 IAVL v2 neither writes nor reads them, whatever the state
+	// storage, the table is cleared so that no value of the deleted versions
+	// survives them.
+	return execSqlite(filepath.Join(path, rootDbName), []string{"DELETE FROM latest"})
+}
+
+// deleteVersionsAfter deletes the data of all the versions greater than the given
+// version from the tree shard at dbPath.
+func deleteVersionsAfter(dbPath string, version int64) error {
+	return execSqlite(dbPath, []string{
+		"DELETE FROM tree WHERE version > ?",
+		"DELETE FROM leaf WHERE version > ?",
+		"DELETE FROM leaf_delete WHERE version > ?",
+		"DELETE FROM orphan WHERE at > ?",
+		"DELETE FROM leaf_orphan WHERE at > ?",
+		"DELETE FROM checkpoints WHERE version > ?",
+	}, version)
+}
+
+// rootSchema and shardSchema are the tables and indexes of the root database and
+// of the tree shards as of IAVL v2.0.0-alpha.4, by name, as saved to sqlite_master.
+// The tree writes to them directly, e.g. createShard, pruneVersions and the
+// Importer, so the databases are checked against them when the tree is opened,
+// see checkSchema. The indexes of a shard may be missing, IAVL v2 creating them
+// at its first checkpoint.
+var (
+	rootSchema = map[string]string{
+		"latest": "CREATE TABLE latest (key blob, value blob, PRIMARY KEY (key))",
+		"root": "CREATE TABLE root ( version int, node_version int, node_sequence int, bytes blob, " +
+			"checkpoint bool, pruned bool, PRIMARY KEY (version))",
+	}
+	shardSchema = map[string]string{
+		"tree":        "CREATE TABLE tree (version int, sequence int, bytes blob, orphaned int)",
+		"orphan":      "CREATE TABLE orphan (version int, sequence int, at int)",
+		"leaf":        "CREATE TABLE leaf (version int, sequence int, bytes blob, orphaned int)",
+		"leaf_delete": "CREATE TABLE leaf_delete (version int, sequence int, key blob, PRIMARY KEY (version, sequence))",
+		"leaf_orphan": "CREATE TABLE leaf_orphan (version int, sequence int, at int)",
+		"checkpoints": "CREATE TABLE checkpoints (version int, prune_to int, branch_orphans int, leaf_orphans int, PRIMARY KEY (version))",
+		"leaf_idx":    "CREATE UNIQUE INDEX leaf_idx ON leaf (version, sequence)",
+		"tree_idx":    "CREATE INDEX tree_idx ON tree (version, sequence)",
+	}
+)
+
+// checkSchema returns ErrSchemaMismatch unless the root database and the tree
+// shards at path have the tables and indexes of rootSchema and shardSchema. The
+// other tables and indexes, e.g. of the snapshots of IAVL v2, are not checked.
+func checkSchema(path string) error {
+	shards, err := shardVersions(path)
+	if err != nil {
+		return err
+	}
+	dbPaths := map[string]map[string]string{filepath.Join(path, rootDbName): rootSchema}
+	for _, shard := range shards {
+		dbPaths[shardPath(path, shard)+shardSuffix] = shardSchema
+	}
+	for dbPath, schema := range dbPaths {
+		found := make(map[string]bool)
+		if err := queryRows(dbPath, "SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL",
+			func(q *sqlite3.Stmt) error {
+				var typ, name, sql string
+				if err := q.Scan(&typ, &name, &sql); err != nil {
+					return err
+				}
+				expected, ok := schema[name]
+				if !ok {
+					return nil
+				}
+				if sql = strings.Join(strings.Fields(sql), " "); sql != expected {
+					return fmt.Errorf("%s %s of %s is %q, expected %q: %w", typ, name, dbPath, sql, expected, ErrSchemaMismatch)
+				}
+				found[name] = true
+				return nil
+			}); err != nil {
+			return err
+		}
+		for name, sql := range schema {
+			if !found[name] && strings.HasPrefix(sql, "CREATE TABLE") {
+				return fmt.Errorf("table %s of %s not found: %w", name, dbPath, ErrSchemaMismatch)
+			}
+		}
+	}
+	return nil
+}
+
+// createShard creates the tree shard starting at the given version at path, with
+// the schema of the shards created by IAVL v2, and returns a connection to it.
+func createShard(path string, version int64) (_ *sqlite3.Conn, topErr error) {
+	dbPath := shardPath(path, version) + shardSuffix
+	if _, err := os.Stat(dbPath); err == nil {
+		return nil, fmt.Errorf("shard %d already exists", version)
+	}
+	conn, err := sqlite3.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	conn.BusyTimeout(busyTimeout)
+	defer func() {
+		if topErr != nil {
+			topErr = errors.Join(topErr, conn.Close())
+		}
+	}()
+	if err := conn.Exec(`
+CREATE TABLE tree (version int, sequence int, bytes blob, orphaned int);
+CREATE TABLE orphan (version int, sequence int, at int);
+CREATE TABLE leaf (version int, sequence int, bytes blob, orphaned int);
+CREATE TABLE leaf_delete (version int, sequence int, key blob, PRIMARY KEY (version, sequence));
+CREATE TABLE leaf_orphan (version int, sequence int, at int);
+CREATE TABLE checkpoints (version int, prune_to int, branch_orphans int, leaf_orphans int, PRIMARY KEY (version))
+`); err != nil {
+		return nil, err
+	}
+	if err := conn.Exec(fmt.Sprintf("PRAGMA page_size=%d; VACUUM;", os.Getpagesize())); err != nil {
+		return nil, err
+	}
+	if err := conn.Exec("PRAGMA journal_mode=WAL;"); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// removeShard deletes the files of the tree shard starting at the given version
+// at path. The shard must not be in use.
+func removeShard(path string, version int64) error {
+	shardFile := shardPath(path, version)
+	for _, suffix := range []string{shardSuffix, shardSuffix + "-wal", shardSuffix + "-shm", shardLockSuffix} {
+		if err := os.Remove(shardFile + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// databasePaths returns the paths of the root database and of the tree shards
+// found at path.
+func databasePaths(path string) ([]string, error) {
+	shards, err := shardVersions(path)
+	if err != nil {
+		return nil, err
+	}
+	dbPaths := []string{filepath.Join(path, rootDbName)}
+	for _, shard := range shards {
+		dbPaths = append(dbPaths, shardPath(path, shard)+shardSuffix)
+	}
+	return dbPaths, nil
+}
+
+// vacuum rebuilds the SQLite databases at path to reclaim their free pages.
+// The tree must be closed.
+func vacuum(path string) error {
+	dbPaths, err := databasePaths(path)
+	if err != nil {
+		return err
+	}
+	for _, dbPath := range dbPaths {
+		if err := execSqlite(dbPath, []string{"VACUUM", "PRAGMA wal_checkpoint(TRUNCATE)"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDatabases checkpoints the WAL of the SQLite databases at path into the
+// database files and syncs them to disk, so that the files alone hold all the
+// committed versions. It fails if a reader prevents the WAL from being fully
+// checkpointed.
+func syncDatabases(path string) error {
+	dbPaths, err := databasePaths(path)
+	if err != nil {
+		return err
+	}
+	for _, dbPath := range dbPaths {
+		// the first column is 1 if the checkpoint could not complete
+		busy, err := queryInt64(dbPath, "PRAGMA wal_checkpoint(TRUNCATE)")
+		if err != nil {
+			return err
+		}
+		if busy != 0 {
+			return fmt.Errorf("failed to checkpoint the WAL of %s: database is busy", dbPath)
+		}
+		if err := syncFile(dbPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncFile flushes the file at path to disk.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	return errors.Join(f.Sync(), f.Close())
+}
+
+// isCheckpoint returns true if the nodes of the given version are checkpointed
+// to the SQLite databases at path, so that loading it replays no change.
+func isCheckpoint(path string, version int64) (bool, error) {
+	n, err := queryInt64(filepath.Join(path, rootDbName),
+		"SELECT COUNT(*) FROM root WHERE version = ? AND checkpoint = true", version)
+	return n != 0, err
+}
+
+// hasVersion returns true if the root of the given version is saved to the SQLite
+// databases at path.
+func hasVersion(path string, version int64) (bool, error) {
+	n, err := queryInt64(filepath.Join(path, rootDbName),
+		"SELECT COUNT(*) FROM root WHERE version = ?", version)
+	return n != 0, err
+}
+
+// freePageRatio returns the ratio of free pages over all the pages of the SQLite
+// databases at path, i.e. the share of their size a VACUUM would reclaim.
+func freePageRatio(path string) (float64, error) {
+	dbPaths, err := databasePaths(path)
+	if err != nil {
+		return 0, err
+	}
+	var free, total int64
+	for _, dbPath := range dbPaths {
+		n, err := queryInt64(dbPath, "PRAGMA freelist_count")
+		if err != nil {
+			return 0, err
+		}
+		free += n
+		n, err = queryInt64(dbPath, "PRAGMA page_count")
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(free) / float64(total), nil
+}
+
+// applyPragmas applies the given SQLite pragmas, validated by Config.Validate, to
+// dbOptions and to the databases at dbOptions.Path, which must not be in use. It
+// returns the updated options and the effective settings as key/value pairs.
+func applyPragmas(pragmas map[string]string, dbOptions iavl.SqliteDbOptions) (iavl.SqliteDbOptions, []any, error) {
+	var settings []any
+	for _, name := range slices.Sorted(maps.Keys(pragmas)) {
+		value := pragmas[name]
+		switch name {
+		case "journal_mode":
+			mode, err := setJournalMode(dbOptions.Path, value)
+			if err != nil {
+				return dbOptions, nil, err
+			}
+			if mode == "" {
+				// the databases are yet to be created by iavl.SqliteDb, in WAL mode.
+				mode = "wal"
+			}
+			settings = append(settings, name, mode)
+		case "mmap_size":
+			size, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return dbOptions, nil, err
+			}
+			dbOptions.MmapSize = size
+			settings = append(settings, name, size)
+		case "wal_autocheckpoint":
+			pages, err := strconv.Atoi(value)
+			if err != nil {
+				return dbOptions, nil, err
+			}
+			// iavl.SqliteDb sets wal_autocheckpoint to WalSize in pages
+			dbOptions.WalSize = pages * os.Getpagesize()
+			settings = append(settings, name, pages)
+		default:
+			return dbOptions, nil, fmt.Errorf("unsupported SQLite pragma %q", name)
+		}
+	}
+	return dbOptions, settings, nil
+}
+
+// setJournalMode sets the journal mode of the existing SQLite databases at path and
+// returns the effective mode of the root database, or "" if the tree has not been
+// created yet. The tree must be closed, as SQLite does not switch the journal mode
+// of a database in use.
+func setJournalMode(path, mode string) (string, error) {
+	dbPaths, err := databasePaths(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	var effective string
+	for _, dbPath := range dbPaths {
+		if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		res, err := queryString(dbPath, "PRAGMA journal_mode="+mode)
+		if err != nil {
+			return "", fmt.Errorf("failed to set journal mode of %s: %w", dbPath, err)
+		}
+		if effective == "" {
+			effective = res
+		}
+	}
+	return effective, nil
+}
+
+// queryInt64 returns the single integer result of the given query against the
+// SQLite database at dbPath. A NULL result is returned as 0.
+func queryInt64(dbPath, query string, args ...interface{}) (res int64, err error) {
+	err = queryRow(dbPath, query, &res, args...)
+	return res, err
+}
+
+// queryString returns the single text result of the given query against the
+// SQLite database at dbPath. A NULL result is returned as "".
+func queryString(dbPath, query string, args ...interface{}) (res string, err error) {
+	err = queryRow(dbPath, query, &res, args...)
+	return res, err
+}
+
+// queryRow scans the first row of the given query against the SQLite database at
+// dbPath into dest, leaving it untouched if the query returns no row.
+func queryRow(dbPath, query string, dest interface{}, args ...interface{}) (topErr error) {
+	conn, err := sqlite3.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	conn.BusyTimeout(busyTimeout)
+	defer func() {
+		topErr = errors.Join(topErr, conn.Close())
+	}()
+	q, err := conn.Prepare(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		topErr = errors.Join(topErr, q.Close())
+	}()
+	hasRow, err := q.Step()
+	if err != nil || !hasRow {
+		return err
+	}
+	return q.Scan(dest)
+}
+
+// loadableVersions returns which of the given versions can be loaded from the
+// SQLite databases at path, i.e. have a root and are not older than the earliest
+// version, in a single query.
+func loadableVersions(path string, versions []int64) (map[int64]bool, error) {
+	res := make(map[int64]bool, len(versions))
+	if len(versions) == 0 {
+		return res, nil
+	}
+	list := make([]string, len(versions))
+	for i, v := range versions {
+		list[i] = strconv.FormatInt(v, 10)
+	}
+	err := queryRows(filepath.Join(path, rootDbName), fmt.Sprintf(`SELECT version FROM root WHERE version IN (%s)
+	AND version >= (SELECT MIN(version) FROM root WHERE checkpoint = true AND pruned = false)`,
+		strings.Join(list, ",")), func(q *sqlite3.Stmt) error {
+		var v int64
+		if err := q.Scan(&v); err != nil {
+			return err
+		}
+		res[v] = true
+		return nil
+	})
+	return res, err
+}
+
+// snapshotVersions returns in ascending order the versions multiple of interval,
+// up to latest, which can be loaded from the SQLite databases at path, as
+// loadableVersions.
+func snapshotVersions(path string, interval, latest int64) ([]int64, error) {
+	var res []int64
+	err := queryRows(filepath.Join(path, rootDbName), `SELECT version FROM root WHERE version % ? = 0 AND version <= ?
+	AND version >= (SELECT MIN(version) FROM root WHERE checkpoint = true AND pruned = false) ORDER BY version`,
+		func(q *sqlite3.Stmt) error {
+			var v int64
+			if err := q.Scan(&v); err != nil {
+				return err
+			}
+			res = append(res, v)
+			return nil
+		}, interval, latest)
+	return res, err
+}
+
+// queryRows calls fn with the statement positioned on each row of the given query
+// against the SQLite database at dbPath.
+func queryRows(dbPath, query string, fn func(q *sqlite3.Stmt) error, args ...interface{}) (topErr error) {
+	conn, err := sqlite3.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	conn.BusyTimeout(busyTimeout)
+	defer func() {
+		topErr = errors.Join(topErr, conn.Close())
+	}()
+	q, err := conn.Prepare(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		topErr = errors.Join(topErr, q.Close())
+	}()
+	for {
+		hasRow, err := q.Step()
+		if err != nil || !hasRow {
+			return err
+		}
+		if err := fn(q); err != nil {
+			return err
+		}
+	}
+}
+
+// nodeKey is the version and sequence of a node in the tree shards.
+type nodeKey [2]int64
+
+// orphanTables maps the tables of the branch and leaf nodes of a tree shard to the
+// tables recording their orphans.
+var orphanTables = map[string]string{"tree": "orphan", "leaf": "leaf_orphan"}
+
+// orphans returns by node table the keys of the nodes orphaned at or before the
+// given version, which are unreachable from the versions after it, as recorded by
+// the tree shards at path.
+func orphans(path string, shards []int64, version int64) (map[string]map[nodeKey]struct{}, error) {
+	res := make(map[string]map[nodeKey]struct{}, len(orphanTables))
+	for table, orphanTable := range orphanTables {
+		keys := make(map[nodeKey]struct{})
+		for _, shard := range shards {
+			if err := queryRows(shardPath(path, shard)+shardSuffix,
+				fmt.Sprintf("SELECT version, sequence FROM %s WHERE at <= ?", orphanTable),
+				func(q *sqlite3.Stmt) error {
+					var key nodeKey
+					if err := q.Scan(&key[0], &key[1]); err != nil {
+						return err
+					}
+					keys[key] = struct{}{}
+					return nil
+				}, version); err != nil {
+				return nil, err
+			}
+		}
+		res[table] = keys
+	}
+	return res, nil
+}
+
+// orphanStats returns the number of the nodes orphaned at or before the given
+// version still stored in the tree shards at path, and the total size of their
+// bytes. If reclaim is set, the nodes are deleted along with the records of the
+// orphans.
+func orphanStats(path string, version int64, reclaim bool) (count, size uint64, err error) {
+	shards, err := shardVersions(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	keys, err := orphans(path, shards, version)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, shard := range shards {
+		dbPath := shardPath(path, shard) + shardSuffix
+		found := make(map[string][]nodeKey, len(orphanTables))
+		for table := range orphanTables {
+			// an orphan is older than the version it was orphaned at
+			if err := queryRows(dbPath,
+				fmt.Sprintf("SELECT version, sequence, LENGTH(bytes) FROM %s WHERE version < ?", table),
+				func(q *sqlite3.Stmt) error {
+					var (
+						key nodeKey
+						n   int64
+					)
+					if err := q.Scan(&key[0], &key[1], &n); err != nil {
+						return err
+					}
+					if _, ok := keys[table][key]; ok {
+						found[table] = append(found[table], key)
+						count++
+						size += uint64(n)
+					}
+					return nil
+				}, version); err != nil {
+				return 0, 0, err
+			}
+		}
+		if reclaim {
+			if err := deleteOrphans(dbPath, version, found); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	return count, size, nil
+}
+
+// deleteOrphans deletes the given nodes, by node table, from the SQLite database
+// at dbPath, along with the records of the nodes orphaned at or before the given
+// version, in a single transaction.
+func deleteOrphans(dbPath string, version int64, nodes map[string][]nodeKey) (topErr error) {
+	conn, err := sqlite3.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	conn.BusyTimeout(busyTimeout)
+	defer func() {
+		topErr = errors.Join(topErr, conn.Close())
+	}()
+	if err := conn.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if topErr != nil {
+			topErr = errors.Join(topErr, conn.Rollback())
+		}
+	}()
+	for table, keys := range nodes {
+		stmt, err := conn.Prepare(fmt.Sprintf("DELETE FROM %s WHERE version = ? AND sequence = ?", table))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := stmt.Exec(key[0], key[1]); err != nil {
+				return errors.Join(err, stmt.Close())
+			}
+		}
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+	}
+	for _, orphanTable := range orphanTables {
+		if err := conn.Exec(fmt.Sprintf("DELETE FROM %s WHERE at <= ?", orphanTable), version); err != nil {
+			return err
+		}
+	}
+	return conn.Commit()
+}
+
+// checkpointsBetween returns in ascending order the checkpoints which have not
+// been pruned from the SQLite databases at path, greater than from and not
+// greater than to.
+func checkpointsBetween(path string, from, to int64) ([]int64, error) {
+	var res []int64
+	err := queryRows(filepath.Join(path, rootDbName),
+		"SELECT version FROM root WHERE checkpoint = true AND pruned = false AND version > ? AND version <= ? ORDER BY version",
+		func(q *sqlite3.Stmt) error {
+			var v int64
+			if err := q.Scan(&v); err != nil {
+				return err
+			}
+			res = append(res, v)
+			return nil
+		}, from, to)
+	return res, err
+}
+
+// pruneVersions deletes the versions older than the given checkpoint from the
+// SQLite databases at path: their roots are flagged as pruned, as IAVL v2 does,
+// then the nodes orphaned at or before the checkpoint are deleted along with the
+// changes replayed to load them. The roots are flagged first so that an
+// interruption leaves the nodes to ReclaimOrphans, never a readable version with
+// missing nodes.
+//
+// The shards locked by IAVL v2, which rewrites them when pruning on its own, are
+// left as is.
+func pruneVersions(path string, checkpoint int64) error {
+	if err := execSqlite(filepath.Join(path, rootDbName), []string{
+		"UPDATE root SET pruned = true WHERE version < ?",
+	}, checkpoint); err != nil {
+		return err
+	}
+	shards, err := unlockedShards(path)
+	if err != nil {
+		return err
+	}
+	keys, err := orphans(path, shards, checkpoint)
+	if err != nil {
+		return err
+	}
+	// a node is stored in a single shard, deleting it from the others is a no-op
+	nodes := make(map[string][]nodeKey, len(keys))
+	for table, tableKeys := range keys {
+		nodes[table] = slices.Collect(maps.Keys(tableKeys))
+	}
+	for _, shard := range shards {
+		dbPath := shardPath(path, shard) + shardSuffix
+		if err := deleteOrphans(dbPath, checkpoint, nodes); err != nil {
+			return err
+		}
+		if err := execSqlite(dbPath, []string{"DELETE FROM leaf_delete WHERE version <= ?"}, checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReplayedChanges deletes from the SQLite databases at path the changes of
+// the deleted versions around the given deleted version which no version replays
+// any more, i.e. if the versions up to the next checkpoint are deleted too: the
+// versions before it are loaded by replaying the changes since the previous
+// checkpoint only, and the ones after it from the next checkpoint. The leaves of
+// these versions orphaned at or before the next checkpoint are deleted along with
+// the records of their orphaning and the deletes, the other leaves being nodes of
+// the next checkpoint. It returns the number of leaves deleted.
+func deleteReplayedChanges(path string, version int64) (int, error) {
+	rootPath := filepath.Join(path, rootDbName)
+	next, err := queryInt64(rootPath, "SELECT MIN(version) FROM root WHERE checkpoint = true AND version > ?", version)
+	if err != nil || next == 0 {
+		return 0, err
+	}
+	retained, err := queryInt64(rootPath, "SELECT COUNT(*) FROM root WHERE version > ? AND version < ?", version, next)
+	if err != nil || retained != 0 {
+		return 0, err
+	}
+	prev, err := queryInt64(rootPath, "SELECT MAX(version) FROM root WHERE version < ?", version)
+	if err != nil {
+		return 0, err
+	}
+
+	shards, err := unlockedShards(path)
+	if err != nil {
+		return 0, err
+	}
+	var leaves []nodeKey
+	for _, shard := range shards {
+		if err := queryRows(shardPath(path, shard)+shardSuffix,
+			"SELECT version, sequence FROM leaf_orphan WHERE version > ? AND version < ? AND at <= ?",
+			func(q *sqlite3.Stmt) error {
+				var key nodeKey
+				if err := q.Scan(&key[0], &key[1]); err != nil {
+					return err
+				}
+				leaves = append(leaves, key)
+				return nil
+			}, prev, next, next); err != nil {
+			return 0, err
+		}
+	}
+	// a leaf is stored in a single shard, deleting it from the others is a no-op
+	for _, shard := range shards {
+		if err := deleteChanges(shardPath(path, shard)+shardSuffix, prev, next, leaves); err != nil {
+			return 0, err
+		}
+	}
+	return len(leaves), nil
+}
+
+// deleteChanges deletes the given leaves from the SQLite database at dbPath, along
+// with the records of the leaves of the versions greater than from and lower than
+// the checkpoint to orphaned at or before it and the deletes of these versions, in
+// a single transaction.
+func deleteChanges(dbPath string, from, to int64, leaves []nodeKey) (topErr error) {
+	conn, err := sqlite3.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	conn.BusyTimeout(busyTimeout)
+	defer func() {
+		topErr = errors.Join(topErr, conn.Close())
+	}()
+	if err := conn.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if topErr != nil {
+			topErr = errors.Join(topErr, conn.Rollback())
+		}
+	}()
+	stmt, err := conn.Prepare("DELETE FROM leaf WHERE version = ? AND sequence = ?")
+	if err != nil {
+		return err
+	}
+	for _, key := range leaves {
+		if err := stmt.Exec(key[0], key[1]); err != nil {
+			return errors.Join(err, stmt.Close())
+		}
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	if err := conn.Exec("DELETE FROM leaf_orphan WHERE version > ? AND version < ? AND at <= ?", from, to, to); err != nil {
+		return err
+	}
+	if err := conn.Exec("DELETE FROM leaf_delete WHERE version > ? AND version < ?", from, to); err != nil {
+		return err
+	}
+	return conn.Commit()
+}
+
+// unlockedShards returns the versions of the tree shards found at path which are
+// not locked by a pruning of IAVL v2, the shards being written or read by it.
+func unlockedShards(path string) ([]int64, error) {
+	versions, err := shardVersions(path)
+	if err != nil {
+		return nil, err
+	}
+	var shards []int64
+	for _, shard := range versions {
+		if _, err := os.Stat(shardPath(path, shard) + shardLockSuffix); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return shards, nil
+}
+
+// vacuumOpen rebuilds the SQLite databases at path to reclaim their free pages
+// while the tree is open, skipping the shards locked by a pruning of IAVL v2 and
+// the databases not in WAL mode. In WAL mode the VACUUM is written to the WAL, the
+// connections of the tree reading their snapshot meanwhile, which is then
+// checkpointed unless a reader still uses it. The writes to the databases must be
+// serialized with it, IAVL v2 writing without a busy timeout.
+func vacuumOpen(path string) error {
+	shards, err := unlockedShards(path)
+	if err != nil {
+		return err
+	}
+	dbPaths := []string{filepath.Join(path, rootDbName)}
+	for _, shard := range shards {
+		dbPaths = append(dbPaths, shardPath(path, shard)+shardSuffix)
+	}
+	for _, dbPath := range dbPaths {
+		mode, err := queryString(dbPath, "PRAGMA journal_mode")
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(mode, "wal") {
+			continue
+		}
+		if err := execSqlite(dbPath, []string{"VACUUM"}); err != nil {
+			return err
+		}
+		// the first column is 1 if a reader prevented the WAL from being truncated
+		if _, err := queryInt64(dbPath, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// earliestVersion returns the earliest version which can still be loaded from
+// the SQLite databases at path, i.e. the first checkpoint which has not been
+// pruned. It returns 0 if no version has been saved yet.
+func earliestVersion(path string) (int64, error) {
+	return queryInt64(filepath.Join(path, rootDbName),
+		"SELECT MIN(version) FROM root WHERE checkpoint = true AND pruned = false")
+}
+
+// latestVersion returns the latest version saved to the SQLite databases at path,
+// or 0 if no version has been saved yet.
+func latestVersion(path string) (int64, error) {
+	return queryInt64(filepath.Join(path, rootDbName), "SELECT MAX(version) FROM root")
+}
+
+// pageStats returns the maximum size in bytes of the page cache of a connection to
+// the SQLite databases at path, which IAVL v2 leaves to the default of SQLite, and
+// the total number of pages of the databases.
+func pageStats(path string) (cacheSize, pages int64, err error) {
+	dbPaths, err := databasePaths(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, dbPath := range dbPaths {
+		n, err := queryInt64(dbPath, "PRAGMA page_count")
+		if err != nil {
+			return 0, 0, err
+		}
+		pages += n
+	}
+	rootPath := filepath.Join(path, rootDbName)
+	// a negative cache size is in KiB, a positive one in pages
+	if cacheSize, err = queryInt64(rootPath, "PRAGMA cache_size"); err != nil || cacheSize < 0 {
+		return -cacheSize * 1024, pages, err
+	}
+	pageSize, err := queryInt64(rootPath, "PRAGMA page_size")
+	return cacheSize * pageSize, pages, err
+}
+
+// dirSize returns the total size in bytes of the files directly under path.
+func dirSize(path string) (int64, error) {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
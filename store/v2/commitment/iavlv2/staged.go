@@ -0,0 +1,59 @@
+package iavlv2
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/cosmos/iavl/v2"
+)
+
+// stagedHash returns the root hash of the staged root of the tree, i.e. the hash
+// the tree has once the uncommitted changes are committed. The children of the
+// staged nodes evicted from memory are unchanged, their hash is read from the
+// SQLite databases of the tree.
+func (t *Tree) stagedHash() (_ []byte, err error) {
+	if layout.err != nil {
+		return nil, fmt.Errorf("unsupported version of IAVL v2, cannot read its staged root; path=%s: %w", t.path, layout.err)
+	}
+	// the databases are read in between two writes, e.g. not while a pruning
+	// deletes a shard
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	root := *(**iavl.Node)(unsafe.Add(unsafe.Pointer(t.tree), layout.stagedRoot))
+	if root == nil {
+		return emptyHash, nil
+	}
+	reader, err := newNodeReader(t.dbOptions.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, reader.Close())
+	}()
+	return t.stagedNodeHash(reader, root)
+}
+
+// stagedNodeHash returns the hash of the given staged node. The nodes changed
+// since the last commit have no hash yet, their hash is computed from the ones of
+// their children, the others are returned as is.
+func (t *Tree) stagedNodeHash(reader *nodeReader, node *iavl.Node) ([]byte, error) {
+	if hash := node.GetHash(); hash != nil {
+		return hash, nil
+	}
+	if node.Height() == 0 {
+		// IAVL v2 hashes the leaves when setting them
+		return nil, fmt.Errorf("staged leaf %X has no hash; path=%s", node.Key(), t.path)
+	}
+	var children [2][]byte
+	for i := range children {
+		child, err := reader.child(node, i == 1)
+		if err != nil {
+			return nil, err
+		}
+		if children[i], err = t.stagedNodeHash(reader, child); err != nil {
+			return nil, err
+		}
+	}
+	return nodeHash(node.Height(), nodeSize(node), node.Version(), children[0], children[1]), nil
+}
@@ -0,0 +1,138 @@
+package iavlv2
+
+import (
+	"fmt"
+
+	"github.com/cosmos/iavl/v2"
+)
+
+// approxSizeSamples is the number of leaves sampled by ApproxSize.
+const approxSizeSamples = 64
+
+// TreeStats reports the memory and disk footprint of a Tree.
+//
+// IAVL v2 v2.0.0-alpha.4 does not expose the following counters, which are thus
+// not reported:
+//   - the node pool size: iavl.NodePool allocates every node and drops the nodes
+//     put back, it holds no node;
+//   - the dirty node count: the tree only counts the nodes created since it was
+//     loaded, unexported, and lists the dirty nodes when saving a version;
+//   - the in-memory node count: the tree keeps no count, and walking the nodes
+//     would race with the writes to the tree.
+type TreeStats struct {
+	// Version is the latest committed version of the tree.
+	Version uint64
+	// Size is the number of leaves in the latest committed version.
+	Size int64
+	// Height is the height of the root node of the latest committed version.
+	Height int8
+	// WorkingBytes is the size of the nodes created by the changes since the tree
+	// was loaded, as counted by IAVL v2 to checkpoint the tree by memory. It is not
+	// reset by the commits.
+	WorkingBytes uint64
+	// DiskSize is the size in bytes of the SQLite files backing the tree.
+	DiskSize int64
+	// PageCacheSize is the maximum size in bytes of the page cache of each SQLite
+	// connection of the tree, as set by PRAGMA cache_size. IAVL v2 does not expose
+	// the number of connections it holds open.
+	PageCacheSize int64
+	// PageCount is the number of pages of the SQLite databases of the tree, as
+	// reported by PRAGMA page_count.
+	PageCount int64
+	// CommitLatency is the distribution of the commit durations in milliseconds
+	// since the tree was opened.
+	CommitLatency Histogram
+	// CommitChanges is the distribution of the number of sets and removes of the
+	// commits since the tree was opened, large batches being the usual cause of
+	// the commit tail latency.
+	CommitChanges Histogram
+}
+
+// Stats returns the current stats of the tree. The disk size and the page stats
+// are reported as 0 if the SQLite files cannot be read.
+func (t *Tree) Stats() TreeStats {
+	stats := TreeStats{
+		Version:       uint64(t.tree.Version()),
+		WorkingBytes:  t.tree.WorkingBytes(),
+		CommitLatency: t.commitLatency.snapshot(),
+		CommitChanges: t.commitChanges.snapshot(),
+	}
+	if !isEmpty(t.tree) {
+		stats.Size = t.tree.Size()
+		stats.Height = t.tree.Height()
+	}
+	diskSize, err := dirSize(t.dbOptions.Path)
+	if err != nil {
+		t.log.Error("failed to compute the disk size of the tree", "err", err)
+	}
+	stats.DiskSize = diskSize
+	if stats.PageCacheSize, stats.PageCount, err = pageStats(t.dbOptions.Path); err != nil {
+		t.log.Error("failed to query the page stats of the tree", "err", err)
+	}
+
+	return stats
+}
+
+// KeyCount returns the number of keys in the tree at the given version, a version
+// of 0 standing for the latest committed version as in Get. The count is read from
+// the root node of the version, historical versions being loaded in a readonly
+// clone of the tree.
+func (t *Tree) KeyCount(version uint64) (count uint64, err error) {
+	err = t.withVersion("key count", version, func(tree *iavl.Tree) error {
+		if !isEmpty(tree) {
+			count = uint64(tree.Size())
+		}
+		return nil
+	})
+	return count, err
+}
+
+// ApproxSize returns an estimate of the total size in bytes of the keys and values
+// in the tree at the given version, a version of 0 standing for the latest committed
+// version as in Get. The size is extrapolated from up to approxSizeSamples leaves
+// evenly spread over the keys of the version, so it is exact for the small trees
+// only. The overhead of the inner nodes and of SQLite is not included, see
+// TreeStats.DiskSize for the size on disk.
+func (t *Tree) ApproxSize(version uint64) (size uint64, err error) {
+	err = t.withVersion("approx size", version, func(tree *iavl.Tree) error {
+		if isEmpty(tree) {
+			return nil
+		}
+		count := tree.Size()
+		samples := min(count, approxSizeSamples)
+		var sampled uint64
+		for i := int64(0); i < samples; i++ {
+			key, value, err := tree.GetByIndex(i * count / samples)
+			if err != nil {
+				return err
+			}
+			sampled += uint64(len(key) + len(value))
+		}
+		size = sampled * uint64(count) / uint64(samples)
+		return nil
+	})
+	return size, err
+}
+
+// withVersion calls fn with the live tree if version is the latest committed
+// version, or 0, and with a readonly clone of the tree loaded at version otherwise.
+func (t *Tree) withVersion(op string, version uint64, fn func(tree *iavl.Tree) error) error {
+	if err := isHighBitSet(version); err != nil {
+		return err
+	}
+	if err := t.checkOpen(op); err != nil {
+		return err
+	}
+	v := int64(version)
+	h := t.tree.Version()
+	if v == 0 || v == h {
+		return fn(t.tree)
+	}
+	if v > h {
+		return fmt.Errorf("%s: cannot read future version %d; h: %d path=%s: %w", op, v, h, t.path, ErrFutureVersion)
+	}
+	if err := t.checkPruned(op, v); err != nil {
+		return err
+	}
+	return t.clones.withClone(v, fn)
+}
@@ -0,0 +1,47 @@
+package iavlv2
+
+import (
+	"time"
+
+	gometrics "github.com/hashicorp/go-metrics"
+
+	"cosmossdk.io/store/v2/metrics"
+)
+
+// metricsKey is the key prefix of the metrics emitted by the tree.
+const metricsKey = "iavl_v2"
+
+// storeLabel is the name of the label of the metrics holding the store name of
+// the tree.
+const storeLabel = "store"
+
+// measureSince emits the time elapsed since start as the metric of the tree with
+// the given key, if the telemetry of the stores is enabled.
+func (t *Tree) measureSince(start time.Time, key string) {
+	if !metrics.IsTelemetryEnabled() {
+		return
+	}
+	gometrics.MeasureSinceWithLabels([]string{metricsKey, key}, start.UTC(), t.metricsLabels())
+}
+
+// setGauge sets the gauge of the tree with the given key, if the telemetry of the
+// stores is enabled.
+func (t *Tree) setGauge(key string, val float32) {
+	if !metrics.IsTelemetryEnabled() {
+		return
+	}
+	gometrics.SetGaugeWithLabels([]string{metricsKey, key}, val, t.metricsLabels())
+}
+
+// incrCounter increments the counter of the tree with the given key, if the
+// telemetry of the stores is enabled.
+func (t *Tree) incrCounter(key string) {
+	if !metrics.IsTelemetryEnabled() {
+		return
+	}
+	gometrics.IncrCounterWithLabels([]string{metricsKey, key}, 1, t.metricsLabels())
+}
+
+func (t *Tree) metricsLabels() []gometrics.Label {
+	return []gometrics.Label{{Name: storeLabel, Value: t.storeName}}
+}
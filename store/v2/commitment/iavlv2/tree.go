@@ -1,7 +1,16 @@
 package iavlv2
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cosmos/iavl/v2"
 	ics23 "github.com/cosmos/ics23/go"
@@ -18,40 +27,313 @@ var (
 	_ store.PausablePruner = (*Tree)(nil)
 )
 
+// emptyHash is the root hash of an empty tree.
+var emptyHash = sha256.New().Sum(nil)
+
+// pruneProgressInterval is the number of versions deleted between two reports of
+// the progress of PruneWithProgress.
+const pruneProgressInterval = 1000
+
 type Tree struct {
 	tree *iavl.Tree
 	log  log.Logger
 	path string
+
+	cfg       Config
+	dbOptions iavl.SqliteDbOptions
+
+	// storeName is the base name of path, used as the store label of the metrics
+	// of the tree.
+	storeName string
+	// the number of set and remove operations since the last commit.
+	pendingSets    int
+	pendingRemoves int
+	// pending are the set and remove operations since the last commit, in order,
+	// passed to the commit listener. They are only kept if it is set, see
+	// recordChanges.
+	pending []corestore.KVPair
+	// commitListener is called with the changes of every commit, nil if unset.
+	commitListener func(version uint64, changes []corestore.KVPair)
+	// initialVersion is the version set by SetInitialVersion, 0 if unset.
+	initialVersion uint64
+
+	// clones is the pool of readonly clones serving the reads of historical
+	// versions.
+	clones *clonePool
+	// proofs caches the proofs returned by GetProof, purged on commit.
+	proofs *proofCache
+	// pruneMtx serializes the prunings, which may run in the background.
+	pruneMtx sync.Mutex
+	// writeMtx serializes the writes to the SQLite databases of the tree: the
+	// saves of the versions, the steps of the prunings and the reopenings. IAVL v2
+	// writes without a busy timeout, a saved version would fail on a lock held by
+	// a pruning.
+	writeMtx sync.Mutex
+	// earliest caches the earliest version of the tree, refreshed on commit so
+	// that the reads do not query SQLite to reject the pruned versions.
+	earliest atomic.Int64
+	// readOnly is set if the tree was opened with dbOptions.Readonly, any write
+	// then fails with ErrReadOnly.
+	readOnly bool
+	// pinned is set if the tree was opened by NewReadOnlyTreeAtVersion, no other
+	// version can then be loaded.
+	pinned bool
+	// closed is set once the tree is closed, any operation then fails with
+	// ErrClosed.
+	closed atomic.Bool
+	// commitLatency records the duration of the commits in milliseconds and
+	// commitChanges their number of sets and removes.
+	commitLatency *histogram
+	commitChanges *histogram
 }
 
+// NewTree opens the IAVL v2 tree stored in SQLite at dbOptions.Path. If
+// dbOptions.Readonly is set the tree is opened in read-only mode: it serves reads,
+// from any version committed by the process writing to the same path once loaded,
+// and never takes the SQLite write locks, as Set, Remove, Commit and Prune fail
+// with ErrReadOnly.
 func NewTree(
 	cfg Config,
 	dbOptions iavl.SqliteDbOptions,
 	log log.Logger,
 ) (*Tree, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	log = withPath(log, dbOptions.Path)
+	tree, err := openTree(cfg, dbOptions, log)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema(dbOptions.Path); err != nil {
+		return nil, errors.Join(fmt.Errorf("unsupported SQLite schema; path=%s: %w", dbOptions.Path, err), tree.Close())
+	}
+	t := &Tree{
+		tree:      tree,
+		log:       log,
+		path:      dbOptions.Path,
+		cfg:       cfg,
+		dbOptions: dbOptions,
+		storeName: filepath.Base(dbOptions.Path),
+		readOnly:  dbOptions.Readonly,
+		// from 0.25ms to 8s
+		commitLatency: newHistogram(0.25, 16),
+		// from 1 to 1M changes
+		commitChanges: newHistogram(1, 21),
+	}
+	t.clones = newClonePool(cfg.ClonePoolSize, t.loadClone)
+	t.proofs = newProofCache(cfg.ProofCacheSize)
+	if _, err := t.EarliestVersion(); err != nil {
+		return nil, errors.Join(err, t.tree.Close())
+	}
+	return t, nil
+}
+
+// NewReadOnlyTreeAtVersion opens the IAVL v2 tree stored in SQLite at
+// dbOptions.Path in read-only mode, see NewTree, pinned at the given version, e.g.
+// for an archive query replica serving a fixed snapshot. The version is loaded
+// once: the reads of version 0 or of the pinned version are served by the loaded
+// tree without cloning it, the writes fail with ErrReadOnly and loading another
+// version fails.
+func NewReadOnlyTreeAtVersion(cfg Config, dbOptions iavl.SqliteDbOptions, version uint64, log log.Logger) (_ *Tree, err error) {
+	if err := isHighBitSet(version); err != nil {
+		return nil, err
+	}
+	dbOptions.Readonly = true
+	t, err := NewTree(cfg, dbOptions, log)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, t.Close())
+		}
+	}()
+	v := int64(version)
+	latest, err := latestVersion(t.dbOptions.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the latest version; path=%s: %w", t.path, err)
+	}
+	if v > latest {
+		return nil, fmt.Errorf("cannot open future version %d; latest: %d path=%s: %w", v, latest, t.path, ErrFutureVersion)
+	}
+	if err := t.checkPruned("open", v); err != nil {
+		return nil, err
+	}
+	if v != 0 {
+		ok, err := hasVersion(t.dbOptions.Path, v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query version %d; path=%s: %w", v, t.path, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot open version %d; path=%s: %w", v, t.path, ErrVersionNotFound)
+		}
+	}
+	if err := t.LoadVersion(version); err != nil {
+		return nil, err
+	}
+	t.pinned = true
+	return t, nil
+}
+
+// pathLogger is a logger attaching the path of the tree to every log line, as
+// log.Logger has no With.
+type pathLogger struct {
+	log.Logger
+	path string
+}
+
+// withPath returns the logger with the given tree path attached to every log line.
+func withPath(logger log.Logger, path string) log.Logger {
+	return pathLogger{Logger: logger, path: path}
+}
+
+func (l pathLogger) Info(msg string, keyVals ...any) {
+	l.Logger.Info(msg, append([]any{"path", l.path}, keyVals...)...)
+}
+
+func (l pathLogger) Warn(msg string, keyVals ...any) {
+	l.Logger.Warn(msg, append([]any{"path", l.path}, keyVals...)...)
+}
+
+func (l pathLogger) Error(msg string, keyVals ...any) {
+	l.Logger.Error(msg, append([]any{"path", l.path}, keyVals...)...)
+}
+
+func (l pathLogger) Debug(msg string, keyVals ...any) {
+	l.Logger.Debug(msg, append([]any{"path", l.path}, keyVals...)...)
+}
+
+// openTree opens the SQLite database described by dbOptions and returns a new
+// IAVL v2 tree backed by it.
+func openTree(cfg Config, dbOptions iavl.SqliteDbOptions, log log.Logger) (*iavl.Tree, error) {
+	if len(cfg.Pragmas) > 0 {
+		var (
+			settings []any
+			err      error
+		)
+		dbOptions, settings, err = applyPragmas(cfg.Pragmas, dbOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply SQLite pragmas; path=%s: %w", dbOptions.Path, err)
+		}
+		log.Info("applied SQLite pragmas", settings...)
+	}
 	pool := iavl.NewNodePool()
 	sql, err := iavl.NewSqliteDb(pool, dbOptions)
 	if err != nil {
 		return nil, err
 	}
-	tree := iavl.NewTree(sql, pool, cfg.ToTreeOptions())
-	return &Tree{tree: tree, log: log, path: dbOptions.Path}, nil
+	opts := cfg.ToTreeOptions()
+	// periodic checkpoints are triggered by Tree.Commit
+	opts.CheckpointInterval = 0
+	return iavl.NewTree(sql, pool, opts), nil
+}
+
+// loadClone returns a readonly clone of the tree loaded at the given version.
+func (t *Tree) loadClone(version int64) (*iavl.Tree, error) {
+	cloned, err := t.tree.ReadonlyClone()
+	if err != nil {
+		return nil, err
+	}
+	if err = t.loadVersion(cloned, version); err != nil {
+		return nil, errors.Join(err, cloned.Close())
+	}
+	return cloned, nil
+}
+
+// loadVersion loads the given version into tree, the tree or one of its clones.
+// IAVL v2 fails with a misleading error, or panics, when nodes of the version are
+// missing; as the version is then still saved in the root database, the failure is
+// reported as ErrCorruptVersion.
+func (t *Tree) loadVersion(tree *iavl.Tree, version int64) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		if err == nil {
+			return
+		}
+		if saved, hasErr := hasVersion(t.dbOptions.Path, version); hasErr == nil && saved {
+			err = fmt.Errorf("load version: version %d is saved but its nodes cannot be loaded; path=%s: %w: %w", version, t.path, ErrCorruptVersion, err)
+		}
+	}()
+	return tree.LoadVersion(version)
 }
 
+// Set sets the value of the given key. A non-nil value, even empty, makes the key
+// present: Get then returns it as an empty, non-nil slice. A nil value is rejected
+// with ErrNilValue, only Remove deletes a key.
 func (t *Tree) Set(key, value []byte) error {
-	_, err := t.tree.Set(key, value)
-	return err
+	if err := t.checkWritable("set"); err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("set: cannot set a nil value for key %X, use Remove to delete it; path=%s: %w", key, t.path, ErrNilValue)
+	}
+	if _, err := t.tree.Set(key, value); err != nil {
+		return err
+	}
+	t.pendingSets++
+	t.recordChanges(corestore.KVPair{Key: key, Value: value})
+	return nil
 }
 
 func (t *Tree) Remove(key []byte) error {
-	_, _, err := t.tree.Remove(key)
+	_, err := t.RemoveWithResult(key)
 	return err
 }
 
+// RemoveWithResult is like Remove but also returns whether the key existed, so
+// that the callers can tell a removal from a no-op.
+func (t *Tree) RemoveWithResult(key []byte) (removed bool, err error) {
+	if err := t.checkWritable("remove"); err != nil {
+		return false, err
+	}
+	if _, removed, err = t.tree.Remove(key); err != nil {
+		return false, err
+	}
+	t.pendingRemoves++
+	t.recordChanges(corestore.KVPair{Key: key, Remove: true})
+	return removed, nil
+}
+
+// SetBatch applies the given pairs to the tree in order, with the same semantics
+// as calling Set, or Remove for the pairs flagged for removal, sequentially. It
+// returns on the first error, reporting the index of the pair which failed.
+func (t *Tree) SetBatch(pairs []corestore.KVPair) error {
+	if err := t.checkWritable("set batch"); err != nil {
+		return err
+	}
+	var sets, removes int
+	defer func() {
+		t.pendingSets += sets
+		t.pendingRemoves += removes
+		t.recordChanges(pairs[:sets+removes]...)
+	}()
+	for i, pair := range pairs {
+		if pair.Remove {
+			if _, _, err := t.tree.Remove(pair.Key); err != nil {
+				return fmt.Errorf("set batch: failed to remove pair %d: %w", i, err)
+			}
+			removes++
+			continue
+		}
+		if pair.Value == nil {
+			return fmt.Errorf("set batch: cannot set a nil value for pair %d, flag it for removal to delete it; path=%s: %w", i, t.path, ErrNilValue)
+		}
+		if _, err := t.tree.Set(pair.Key, pair.Value); err != nil {
+			return fmt.Errorf("set batch: failed to set pair %d: %w", i, err)
+		}
+		sets++
+	}
+	return nil
+}
+
 func (t *Tree) GetLatestVersion() (uint64, error) {
 	return uint64(t.tree.Version()), nil
 }
 
+// Hash returns the root hash of the last committed version of the tree.
 func (t *Tree) Hash() []byte {
 	return t.tree.Hash()
 }
@@ -64,119 +346,1038 @@ func (t *Tree) LoadVersion(version uint64) error {
 	if err := isHighBitSet(version); err != nil {
 		return err
 	}
+	if err := t.checkOpen("load version"); err != nil {
+		return err
+	}
+	if h := t.tree.Version(); t.pinned && int64(version) != h {
+		return fmt.Errorf("load version: cannot load version %d, the tree is pinned at version %d; path=%s", version, h, t.path)
+	}
+	t.proofs.purge()
+	if err := t.loadVersion(t.tree, int64(version)); err != nil {
+		return err
+	}
+	if _, err := t.EarliestVersion(); err != nil {
+		return err
+	}
+	return t.preload()
+}
 
-	return t.tree.LoadVersion(int64(version))
+// preload loads the nodes of the top cfg.PreloadDepth levels of the loaded version
+// of the tree, so that the first reads after loading it do not query SQLite for
+// them. IAVL v2 does not expose the nodes, they are loaded by reading a leaf per
+// node of the deepest preloaded level, the leaves being evenly spread by index.
+func (t *Tree) preload() error {
+	if t.cfg.PreloadDepth == 0 || isEmpty(t.tree) {
+		return nil
+	}
+	start := time.Now()
+	size := t.tree.Size()
+	leaves := min(int64(1)<<t.cfg.PreloadDepth, size)
+	for i := range leaves {
+		if _, _, err := t.tree.GetByIndex(i * size / leaves); err != nil {
+			return fmt.Errorf("failed to preload the tree; path=%s: %w", t.path, err)
+		}
+	}
+	t.log.Info("preloaded tree",
+		"version", t.tree.Version(),
+		"depth", t.cfg.PreloadDepth,
+		"leaves", leaves,
+		"height", t.tree.Height(),
+		"duration", time.Since(start),
+	)
+	return nil
 }
 
+// LoadVersionForOverwriting loads the state at the given version.
+// Any versions greater than the given version are deleted from the database.
 func (t *Tree) LoadVersionForOverwriting(version uint64) error {
-	return t.LoadVersion(version)
+	if err := isHighBitSet(version); err != nil {
+		return err
+	}
+	if err := t.checkWritable("load version for overwriting"); err != nil {
+		return err
+	}
+	return t.reopen(int64(version), func() error {
+		if err := truncateVersions(t.dbOptions.Path, int64(version)); err != nil {
+			return fmt.Errorf("failed to truncate versions after %d; path=%s: %w", version, t.path, err)
+		}
+		return nil
+	})
+}
+
+// reopen closes the underlying tree, runs fn while the SQLite databases are not
+// in use and reopens the tree at the given version. Uncommitted changes are lost.
+func (t *Tree) reopen(version int64, fn func() error) error {
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	t.proofs.purge()
+	if err := t.clones.purge(); err != nil {
+		return err
+	}
+	if err := t.tree.Close(); err != nil {
+		return err
+	}
+	fnErr := fn()
+	tree, err := openTree(t.cfg, t.dbOptions, t.log)
+	if err != nil {
+		return errors.Join(fnErr, err)
+	}
+	t.tree = tree
+	t.pendingSets, t.pendingRemoves, t.pending = 0, 0, nil
+	if fnErr != nil {
+		return errors.Join(fnErr, t.loadVersion(t.tree, version))
+	}
+	if err := t.loadVersion(t.tree, version); err != nil {
+		return err
+	}
+	if _, err := t.EarliestVersion(); err != nil {
+		return err
+	}
+	return t.preload()
+}
+
+// SetTreeOptions applies the given IAVL v2 tree options to the open tree, e.g. to
+// tune its checkpoints without a restart. It must not be called while the tree has
+// uncommitted changes.
+//
+// CheckpointInterval, CheckpointMemory, HeightFilter, EvictionDepth, PruneRatio,
+// MinimumKeepVersions and MetricsProxy can be changed. As IAVL v2 captures them
+// when the tree is opened, the tree is closed and reopened at its version to apply
+// them, replaying the changes since the last checkpoint. StateStorage cannot be
+// changed as the leaves already saved depend on it.
+func (t *Tree) SetTreeOptions(opts iavl.TreeOptions) error {
+	if err := t.checkOpen("set tree options"); err != nil {
+		return err
+	}
+	if t.pendingSets+t.pendingRemoves > 0 {
+		return fmt.Errorf("set tree options: tree has uncommitted changes; path=%s", t.path)
+	}
+	if opts.StateStorage != t.cfg.StateStorage {
+		return fmt.Errorf("set tree options: state storage cannot be changed from %t to %t; path=%s",
+			t.cfg.StateStorage, opts.StateStorage, t.path)
+	}
+	cfg := t.cfg
+	cfg.CheckpointInterval = opts.CheckpointInterval
+	cfg.CheckpointMemory = opts.CheckpointMemory
+	cfg.HeightFilter = opts.HeightFilter
+	cfg.EvictionDepth = opts.EvictionDepth
+	cfg.PruneRatio = opts.PruneRatio
+	cfg.MinimumKeepVersions = opts.MinimumKeepVersions
+	cfg.MetricsProxy = opts.MetricsProxy
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("set tree options: %w; path=%s", err, t.path)
+	}
+	t.cfg = cfg
+	if err := t.reopen(t.tree.Version(), func() error { return nil }); err != nil {
+		return fmt.Errorf("set tree options: %w; path=%s", err, t.path)
+	}
+	t.log.Info("set tree options",
+		"checkpoint_interval", cfg.CheckpointInterval,
+		"checkpoint_memory", cfg.CheckpointMemory,
+		"height_filter", cfg.HeightFilter,
+		"eviction_depth", cfg.EvictionDepth,
+		"prune_ratio", cfg.PruneRatio,
+		"minimum_keep_versions", cfg.MinimumKeepVersions,
+	)
+	return nil
+}
+
+// Compact runs a VACUUM on the SQLite databases of the tree to reclaim the pages
+// freed by pruning. It must not be called while the tree has uncommitted changes
+// and is meant to be run during a maintenance window, as the tree is closed and
+// reopened around the VACUUM.
+func (t *Tree) Compact() error {
+	if err := t.checkWritable("compact"); err != nil {
+		return err
+	}
+	if t.pendingSets+t.pendingRemoves > 0 {
+		return fmt.Errorf("compact: tree has uncommitted changes; path=%s", t.path)
+	}
+	before, after, err := t.compact()
+	if err != nil {
+		return err
+	}
+	t.log.Info("compacted tree", "size_before", before, "size_after", after)
+
+	return nil
+}
+
+// Checkpoint makes the latest committed version durable in the SQLite database
+// files alone, so that a filesystem snapshot of the tree taken before the next
+// commit holds a consistent state. It must not be called while the tree has
+// uncommitted changes.
+//
+// IAVL v2 writes the nodes of a version to SQLite only while saving it: every
+// committed version is durable, the versions in between two checkpoints being
+// loaded by replaying their changes over the previous checkpoint. Checkpoint
+// flushes the SQLite WAL to the database files and, if the latest version is not
+// a checkpoint, flags the next commit to checkpoint the tree.
+func (t *Tree) Checkpoint() error {
+	if err := t.checkWritable("checkpoint"); err != nil {
+		return err
+	}
+	if t.pendingSets+t.pendingRemoves > 0 {
+		return fmt.Errorf("checkpoint: tree has uncommitted changes; path=%s", t.path)
+	}
+	h := t.tree.Version()
+	if err := syncDatabases(t.dbOptions.Path); err != nil {
+		return fmt.Errorf("checkpoint: %w; path=%s", err, t.path)
+	}
+	checkpointed, err := isCheckpoint(t.dbOptions.Path, h)
+	if err != nil {
+		return err
+	}
+	if !checkpointed && h != 0 {
+		t.setShouldCheckpoint()
+	}
+	t.log.Info("checkpointed tree", "version", h, "tree_checkpoint", checkpointed)
+	return nil
+}
+
+// compact runs a VACUUM on the SQLite databases of the tree and returns their
+// size before and after it.
+func (t *Tree) compact() (before, after int64, err error) {
+	before, err = dirSize(t.dbOptions.Path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := t.reopen(t.tree.Version(), func() error {
+		return vacuum(t.dbOptions.Path)
+	}); err != nil {
+		return 0, 0, fmt.Errorf("compact: %w; path=%s", err, t.path)
+	}
+	after, err = dirSize(t.dbOptions.Path)
+	return before, after, err
+}
+
+// autoCompact compacts the tree if the ratio of free pages of its SQLite databases
+// exceeds cfg.AutoCompactThreshold, once versions have been deleted by a pruning.
+// Unlike Compact the tree is not reopened, its reads going on: the databases are
+// vacuumed in place while holding writeMtx, see vacuumOpen.
+func (t *Tree) autoCompact() error {
+	if t.cfg.AutoCompactThreshold == 0 {
+		return nil
+	}
+	ratio, err := freePageRatio(t.dbOptions.Path)
+	if err != nil {
+		return fmt.Errorf("auto compact: failed to read the free pages; path=%s: %w", t.path, err)
+	}
+	if ratio <= t.cfg.AutoCompactThreshold {
+		return nil
+	}
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	before, err := dirSize(t.dbOptions.Path)
+	if err != nil {
+		return err
+	}
+	if err := vacuumOpen(t.dbOptions.Path); err != nil {
+		return fmt.Errorf("auto compact: %w; path=%s", err, t.path)
+	}
+	after, err := dirSize(t.dbOptions.Path)
+	if err != nil {
+		return err
+	}
+	t.log.Info("auto-compacted tree", "free_page_ratio", ratio, "threshold", t.cfg.AutoCompactThreshold,
+		"size_before", before, "size_after", after, "reclaimed", before-after)
+	return nil
+}
+
+// OrphanStats returns the number of the nodes still stored in the SQLite databases
+// of the tree which are not reachable from any retained version, i.e. which were
+// orphaned at or before the earliest version, and the total size of their bytes.
+// They are normally deleted when pruning, a pruning interrupted by a crash may
+// leave them behind, see ReclaimOrphans. It scans the tree shards and is meant to
+// diagnose an unexplained growth of the storage.
+func (t *Tree) OrphanStats() (count, size uint64, err error) {
+	return t.orphanStats("orphan stats", false)
+}
+
+// ReclaimOrphans deletes the nodes counted by OrphanStats and returns their number
+// and the total size of their bytes. The pages freed are reclaimed by Compact. It
+// must not be called while the tree has uncommitted changes.
+func (t *Tree) ReclaimOrphans() (count, size uint64, err error) {
+	if err := t.checkWritable("reclaim orphans"); err != nil {
+		return 0, 0, err
+	}
+	if t.pendingSets+t.pendingRemoves > 0 {
+		return 0, 0, fmt.Errorf("reclaim orphans: tree has uncommitted changes; path=%s", t.path)
+	}
+	t.pruneMtx.Lock()
+	defer t.pruneMtx.Unlock()
+	count, size, err = t.orphanStats("reclaim orphans", true)
+	if err != nil {
+		return 0, 0, err
+	}
+	t.log.Info("reclaimed orphans", "count", count, "size", size)
+	return count, size, nil
+}
+
+func (t *Tree) orphanStats(op string, reclaim bool) (count, size uint64, err error) {
+	if err := t.checkOpen(op); err != nil {
+		return 0, 0, err
+	}
+	earliest, err := earliestVersion(t.dbOptions.Path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: failed to query the earliest version; path=%s: %w", op, t.path, err)
+	}
+	count, size, err = orphanStats(t.dbOptions.Path, earliest, reclaim)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w; path=%s", op, err, t.path)
+	}
+	return count, size, nil
+}
+
+// Rollback discards any uncommitted changes and deletes all the versions greater
+// than targetVersion, leaving the tree at targetVersion. The target version must
+// not be older than the earliest version retained by pruning.
+func (t *Tree) Rollback(targetVersion uint64) error {
+	if err := isHighBitSet(targetVersion); err != nil {
+		return err
+	}
+	v := int64(targetVersion)
+	h := t.tree.Version()
+	if v > h {
+		return fmt.Errorf("rollback: cannot roll back to future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
+	}
+	earliest, err := earliestVersion(t.dbOptions.Path)
+	if err != nil {
+		return err
+	}
+	if v < earliest {
+		return fmt.Errorf("rollback: cannot roll back to pruned version %d; earliest: %d path=%s", v, earliest, t.path)
+	}
+	return t.LoadVersionForOverwriting(targetVersion)
 }
 
 func (t *Tree) Commit() ([]byte, uint64, error) {
-	h, v, err := t.tree.SaveVersion()
-	return h, uint64(v), err
+	if err := t.checkWritable("commit"); err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
+	defer t.measureSince(start, "commit")
+	t.setGauge("commit_sets", float32(t.pendingSets))
+	t.setGauge("commit_removes", float32(t.pendingRemoves))
+	if interval := t.cfg.CheckpointInterval; interval > 0 && (t.tree.Version()+1)%interval == 0 {
+		t.setShouldCheckpoint()
+	}
+	h, v, err := t.saveVersion()
+	if err != nil {
+		return h, uint64(v), err
+	}
+	duration, writes := time.Since(start), t.pendingSets+t.pendingRemoves
+	t.commitLatency.observe(float64(duration.Microseconds()) / 1000)
+	t.commitChanges.observe(float64(writes))
+	if threshold := t.cfg.SlowCommitThreshold; threshold > 0 && duration > threshold {
+		t.log.Warn("slow commit", "version", v, "duration", duration, "writes", writes, "threshold", threshold)
+	}
+	changes := t.pending
+	t.pendingSets, t.pendingRemoves, t.pending = 0, 0, nil
+	t.proofs.purge()
+	t.notifyCommit(uint64(v), changes)
+	if pruneTo, ok := t.cfg.Pruning.PruneTo(uint64(v)); ok {
+		if err := t.Prune(pruneTo); err != nil {
+			t.log.Error("failed to prune on commit", "version", v, "prune_to", pruneTo, "err", err)
+		}
+	}
+	if earliest, err := t.EarliestVersion(); err != nil {
+		t.log.Error("failed to refresh the earliest version", "err", err)
+	} else if err := t.clones.evictUpTo(int64(earliest) - 1); err != nil {
+		// the clones of the pruned versions are no longer served
+		t.log.Error("failed to evict the clones of the pruned versions", "earliest", earliest, "err", err)
+	}
+	return h, uint64(v), nil
+}
+
+// saveVersion saves the staged version of the tree. IAVL v2 panics on some
+// storage failures, e.g. when a node cannot be read back to hash the staged root,
+// the panic is then logged and returned as an error so that the caller decides
+// whether to retry or halt. The tree is left in an undefined state.
+func (t *Tree) saveVersion() (hash []byte, version int64, err error) {
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			version = t.tree.Version()
+			t.log.Error("commit panicked", "version", version+1, "err", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("commit: failed to save version %d; path=%s: panic: %v", version+1, t.path, r)
+		}
+	}()
+	return t.tree.SaveVersion()
+}
+
+// SetCommitListener sets the function called by Commit with the committed version
+// and its changes, the sets and removes since the previous commit in order, e.g.
+// to stream the state to an indexer. It is called synchronously once the version
+// is saved, so that it never sees uncommitted changes, and the changes are not
+// used by the tree afterwards. A panic of the listener is logged and does not
+// fail the commit. A nil fn removes the listener. It must not be called
+// concurrently with Commit, and should be set before the changes of the next
+// version are made: the changes are only recorded while a listener is set.
+func (t *Tree) SetCommitListener(fn func(version uint64, changes []corestore.KVPair)) {
+	t.commitListener = fn
+}
+
+// recordChanges appends the given changes to pending if they are needed by the
+// commit listener, so that they are not held in memory until the commit otherwise.
+func (t *Tree) recordChanges(pairs ...corestore.KVPair) {
+	if t.commitListener != nil {
+		t.pending = append(t.pending, pairs...)
+	}
 }
 
+// notifyCommit calls the commit listener, if any, recovering from its panic.
+func (t *Tree) notifyCommit(version uint64, changes []corestore.KVPair) {
+	if t.commitListener == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			t.log.Error("commit listener panicked", "version", version, "err", r)
+		}
+	}()
+	t.commitListener(version, changes)
+}
+
+// setShouldCheckpoint flags the next commit to checkpoint the tree to SQLite.
+func (t *Tree) setShouldCheckpoint() {
+	t.tree.SetShouldCheckpoint()
+	t.incrCounter("checkpoint")
+}
+
+// SetInitialVersion sets the version of the first commit of the tree. It fails if
+// the tree already has committed versions.
 func (t *Tree) SetInitialVersion(version uint64) error {
 	if err := isHighBitSet(version); err != nil {
 		return err
 	}
-	t.tree.SetShouldCheckpoint()
-	return t.tree.SetInitialVersion(int64(version))
+	if err := t.checkWritable("set initial version"); err != nil {
+		return err
+	}
+	if h := t.tree.Version(); h != 0 {
+		return fmt.Errorf("set initial version: cannot set initial version %d of a tree with committed versions; h: %d path=%s", version, h, t.path)
+	}
+	t.setShouldCheckpoint()
+	if err := t.tree.SetInitialVersion(int64(version)); err != nil {
+		return err
+	}
+	t.initialVersion = version
+	return nil
 }
 
+// GetProof returns an ics23 existence proof for the given key at the given
+// version, or a non-existence proof if the key is absent at that version.
+//
+// If cfg.ProofCacheSize is set, the proofs are cached by version and key until
+// the next commit or the pruning of their version, and the same proof is returned
+// by the repeated requests: the caller must not modify it.
 func (t *Tree) GetProof(version uint64, key []byte) (*ics23.CommitmentProof, error) {
 	if err := isHighBitSet(version); err != nil {
 		return nil, err
 	}
-	return t.tree.GetProof(int64(version), key)
+	if err := t.checkOpen("get proof"); err != nil {
+		return nil, err
+	}
+	v := int64(version)
+	if err := t.checkPruned("get proof", v); err != nil {
+		return nil, err
+	}
+	if proof := t.proofs.get(v, key); proof != nil {
+		return proof, nil
+	}
+	proof, err := t.tree.GetProof(v, key)
+	if err != nil {
+		return nil, err
+	}
+	t.proofs.add(v, key, proof)
+	return proof, nil
 }
 
+// GetRangeProof returns an ics23 batch proof of the existence of all the keys in
+// [start, end) at the given version, a nil end standing for the end of the tree.
+// The proof is verified with ics23.BatchVerifyMembership against the keys and
+// values of the range; it proves that these keys are in the tree, not that the
+// range holds no other key. It returns ErrEmptyRange if the range holds no key.
+//
+// The keys are read from a readonly clone of the tree so that the live tree is
+// not blocked.
+func (t *Tree) GetRangeProof(version uint64, start, end []byte) (proof *ics23.CommitmentProof, err error) {
+	if err := isHighBitSet(version); err != nil {
+		return nil, err
+	}
+	if err := t.checkOpen("get range proof"); err != nil {
+		return nil, err
+	}
+	v := int64(version)
+	h := t.tree.Version()
+	if v > h {
+		return nil, fmt.Errorf("get range proof: cannot prove future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
+	}
+	if err := t.checkPruned("get range proof", v); err != nil {
+		return nil, err
+	}
+	cloned, err := t.loadClone(v)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Join(err, cloned.Close())
+	}()
+	if isEmpty(cloned) {
+		return nil, fmt.Errorf("get range proof: version %d; path=%s: %w", v, t.path, ErrEmptyRange)
+	}
+	itr, err := cloned.Iterator(start, end, false)
+	if err != nil {
+		return nil, err
+	}
+	var proofs []*ics23.CommitmentProof
+	for ; itr.Valid(); itr.Next() {
+		p, err := cloned.GetProof(v, itr.Key())
+		if err != nil {
+			return nil, errors.Join(err, itr.Close())
+		}
+		proofs = append(proofs, p)
+	}
+	if err := errors.Join(itr.Error(), itr.Close()); err != nil {
+		return nil, err
+	}
+	if len(proofs) == 0 {
+		return nil, fmt.Errorf("get range proof: no key in [%X, %X) at version %d; path=%s: %w", start, end, v, t.path, ErrEmptyRange)
+	}
+	return ics23.CombineProofs(proofs)
+}
+
+// Get returns the value of the given key at the given version, or nil if the key
+// is absent. A version of 0 stands for the latest committed version, which is read
+// from the live tree without cloning it.
 func (t *Tree) Get(version uint64, key []byte) ([]byte, error) {
 	if err := isHighBitSet(version); err != nil {
 		return nil, err
 	}
+	if err := t.checkOpen("get"); err != nil {
+		return nil, err
+	}
 	v := int64(version)
 	h := t.tree.Version()
+	if v == 0 {
+		v = h
+	}
 	if v > h {
-		return nil, fmt.Errorf("get: cannot read future version %d; h: %d path=%s", v, h, t.path)
+		return nil, fmt.Errorf("get: cannot read future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
 	}
 	versionFound, val, err := t.tree.GetRecent(v, key)
 	if versionFound {
 		return val, err
 	}
-	cloned, err := t.tree.ReadonlyClone()
-	if err != nil {
+	if err := t.checkPruned("get", v); err != nil {
 		return nil, err
 	}
-	if err = cloned.LoadVersion(int64(version)); err != nil {
+	err = t.clones.withClone(v, func(cloned *iavl.Tree) error {
+		val, err = cloned.Get(key)
+		return err
+	})
+	return val, err
+}
+
+// GetMany returns the values of the given keys at the given version, in order, a
+// value being nil if its key is absent, with the same semantics as calling Get for
+// each key. The keys of a historical version are all read from a single readonly
+// clone of the tree, so that the version is loaded once for the batch.
+func (t *Tree) GetMany(version uint64, keys [][]byte) ([][]byte, error) {
+	if err := isHighBitSet(version); err != nil {
+		return nil, err
+	}
+	if err := t.checkOpen("get many"); err != nil {
 		return nil, err
 	}
-	return cloned.Get(key)
+	v := int64(version)
+	h := t.tree.Version()
+	if v == 0 {
+		v = h
+	}
+	if v > h {
+		return nil, fmt.Errorf("get many: cannot read future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
+	}
+	vals := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return vals, nil
+	}
+	// the recent versions are served by the live tree whatever the key
+	if versionFound, _, _ := t.tree.GetRecent(v, keys[0]); versionFound {
+		for i, key := range keys {
+			_, val, err := t.tree.GetRecent(v, key)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = val
+		}
+		return vals, nil
+	}
+	if err := t.checkPruned("get many", v); err != nil {
+		return nil, err
+	}
+	err := t.clones.withClone(v, func(cloned *iavl.Tree) error {
+		for i, key := range keys {
+			val, err := cloned.Get(key)
+			if err != nil {
+				return err
+			}
+			vals[i] = val
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vals, nil
 }
 
+// Has returns true if the given key is present at the given version, a version of
+// 0 standing for the latest committed version as in Get.
 func (t *Tree) Has(version uint64, key []byte) (bool, error) {
 	res, err := t.Get(version, key)
 	return res != nil, err
 }
 
 func (t *Tree) Iterator(version uint64, start, end []byte, ascending bool) (corestore.Iterator, error) {
+	// inclusive = false is IAVL v1's default behavior.
+	// the read expectations of certain modules (like x/staking) will cause a panic if this is changed.
+	return t.IteratorWithOptions(version, start, end, ascending, false)
+}
+
+// IteratorWithOptions is like Iterator but lets the caller choose whether the end
+// key is included in the iteration, in both ascending and descending order.
+func (t *Tree) IteratorWithOptions(version uint64, start, end []byte, ascending, inclusive bool) (corestore.Iterator, error) {
 	if err := isHighBitSet(version); err != nil {
 		return nil, err
 	}
+	if err := t.checkOpen("iterator"); err != nil {
+		return nil, err
+	}
+	if inclusive && end != nil {
+		// no key sorts between end and end||0x00, so iterating up to the latter
+		// exclusively is the same as iterating up to end inclusively.
+		end = append(bytes.Clone(end), 0)
+	}
 	h := t.tree.Version()
 	v := int64(version)
 	if v > h {
-		return nil, fmt.Errorf("iterator: cannot read future version %d; h: %d", v, h)
+		return nil, fmt.Errorf("iterator: cannot read future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
 	}
 	ok, itr := t.tree.IterateRecent(v, start, end, ascending)
 	if ok {
 		return itr, nil
 	}
-	cloned, err := t.tree.ReadonlyClone()
-	if err != nil {
+	if err := t.checkPruned("iterator", v); err != nil {
 		return nil, err
 	}
-	if err = cloned.LoadVersion(int64(version)); err != nil {
+	return t.cloneIterator(v, start, end, ascending)
+}
+
+// cloneIterator returns an iterator over a readonly clone of the tree loaded at
+// the given version.
+func (t *Tree) cloneIterator(v int64, start, end []byte, ascending bool) (*clonedIterator, error) {
+	cloned, err := t.loadClone(v)
+	if err != nil {
 		return nil, err
 	}
+	var clonedItr iavl.Iterator
 	if ascending {
-		// inclusive = false is IAVL v1's default behavior.
-		// the read expectations of certain modules (like x/staking) will cause a panic if this is changed.
-		return t.tree.Iterator(start, end, false)
+		clonedItr, err = cloned.Iterator(start, end, false)
 	} else {
-		return t.tree.ReverseIterator(start, end)
+		clonedItr, err = cloned.ReverseIterator(start, end)
 	}
+	if err != nil {
+		return nil, errors.Join(err, cloned.Close())
+	}
+	return &clonedIterator{Iterator: clonedItr, tree: cloned}, nil
+}
+
+// clonedIterator is an iterator over a readonly clone of the tree. The clone is
+// closed along with the iterator.
+type clonedIterator struct {
+	iavl.Iterator
+	tree *iavl.Tree
+}
+
+// Close closes the iterator and the cloned tree.
+func (i *clonedIterator) Close() error {
+	return errors.Join(i.Iterator.Close(), i.tree.Close())
 }
 
+// Export returns an exporter streaming the nodes of the tree at the given version
+// in depth-first post-order. The nodes are read from a readonly clone of the tree
+// so that the live tree is not blocked; the clone is released when the exporter is
+// closed.
 func (t *Tree) Export(version uint64) (commitment.Exporter, error) {
+	return t.ExportCtx(context.Background(), version)
+}
+
+// ExportCtx is like Export, the returned exporter failing with ctx.Err() once ctx
+// is cancelled.
+func (t *Tree) ExportCtx(ctx context.Context, version uint64) (commitment.Exporter, error) {
 	if err := isHighBitSet(version); err != nil {
 		return nil, err
 	}
-	e, err := t.tree.Export(int64(version), iavl.PostOrder)
+	if err := t.checkOpen("export"); err != nil {
+		return nil, err
+	}
+	v := int64(version)
+	h := t.tree.Version()
+	if v > h {
+		return nil, fmt.Errorf("export: cannot export future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
+	}
+	cloned, err := t.loadClone(v)
 	if err != nil {
 		return nil, err
 	}
-	return &Exporter{e}, nil
+	if isEmpty(cloned) {
+		return &EmptyExporter{}, cloned.Close()
+	}
+	// the clone has v loaded as its latest version, so the export is served from
+	// its root and iavl.Exporter.Close closes the clone, not the live tree.
+	e, err := cloned.Export(v, iavl.PostOrder)
+	if err != nil {
+		return nil, errors.Join(err, cloned.Close())
+	}
+	return &Exporter{exporter: e, ctx: ctx}, nil
 }
 
+// Import returns an importer which restores the tree at the given version from
+// nodes in the order produced by Export. The tree must be empty.
 func (t *Tree) Import(version uint64) (commitment.Importer, error) {
+	return t.ImportWithRoot(version, nil)
+}
+
+// ImportWithRoot is like Import, the importer failing to commit with
+// ErrRootMismatch unless the root hash of the imported tree is expectedRoot, e.g.
+// the root of the store in the app hash a state sync snapshot is trusted for. The
+// imported version is then deleted, leaving the tree empty. A nil expectedRoot
+// skips the check.
+func (t *Tree) ImportWithRoot(version uint64, expectedRoot []byte) (commitment.Importer, error) {
 	if err := isHighBitSet(version); err != nil {
 		return nil, err
 	}
-	importer, err := t.tree.Import(int64(version))
+	if err := t.checkWritable("import"); err != nil {
+		return nil, err
+	}
+	latest, err := latestVersion(t.dbOptions.Path)
 	if err != nil {
 		return nil, err
 	}
-	return &Importer{importer}, nil
+	if latest != 0 {
+		return nil, fmt.Errorf("import: tree must be empty, found version %d; path=%s", latest, t.path)
+	}
+	// the shards of an empty tree are left by an interrupted import
+	shards, err := shardVersions(t.dbOptions.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) > 0 {
+		if err := t.reopen(0, func() error {
+			for _, shard := range shards {
+				if err := removeShard(t.dbOptions.Path, shard); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("import: failed to delete the shards of an interrupted import; path=%s: %w", t.path, err)
+		}
+	}
+	importer, err := newImporter(t, int64(version), expectedRoot)
+	if err != nil {
+		return nil, fmt.Errorf("import: failed to create the shard of version %d; path=%s: %w", version, t.path, err)
+	}
+	return importer, nil
 }
 
+// Close closes the tree and its clones. Closing a closed tree is a no-op.
 func (t *Tree) Close() error {
-	return t.tree.Close()
+	if !t.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	return errors.Join(t.clones.purge(), t.tree.Close())
+}
+
+// EarliestVersion returns the earliest version which can still be read from the
+// tree, versions older than it have been pruned. It returns 0 if no version has
+// been committed yet.
+func (t *Tree) EarliestVersion() (uint64, error) {
+	earliest, err := earliestVersion(t.dbOptions.Path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the earliest version; path=%s: %w", t.path, err)
+	}
+	t.earliest.Store(earliest)
+	return uint64(earliest), nil
 }
 
+// healthCheckKey is the key read by HealthCheck, its presence in the tree does not
+// matter.
+var healthCheckKey = []byte("iavlv2/health-check")
+
+// HealthCheck returns an error describing why the tree is not ready to serve reads:
+// the tree is closed, its SQLite root database cannot be queried, the latest
+// version saved to it does not match the latest version loaded in the tree, or the
+// latest version cannot be read. It only runs a query and a read of the latest
+// version, so that it can be called every few seconds, e.g. by a readiness probe.
+func (t *Tree) HealthCheck() error {
+	if err := t.checkOpen("health check"); err != nil {
+		return err
+	}
+	latest, err := latestVersion(t.dbOptions.Path)
+	if err != nil {
+		return fmt.Errorf("health check: failed to query the latest version; path=%s: %w", t.path, err)
+	}
+	h := t.tree.Version()
+	// a read-only tree lags behind the process writing to it until reloaded
+	if latest < h || (latest != h && !t.readOnly) {
+		return fmt.Errorf("health check: tree is at version %d but the latest saved version is %d; path=%s", h, latest, t.path)
+	}
+	if h == 0 {
+		return nil
+	}
+	if _, err := t.Has(uint64(h), healthCheckKey); err != nil {
+		return fmt.Errorf("health check: failed to read version %d; path=%s: %w", h, t.path, err)
+	}
+	return nil
+}
+
+// checkPruned returns ErrVersionPruned if the given version is older than the
+// earliest version of the tree as of the last commit.
+func (t *Tree) checkPruned(op string, version int64) error {
+	if earliest := t.earliest.Load(); version < earliest {
+		return fmt.Errorf("%s: cannot read version %d; earliest: %d path=%s: %w", op, version, earliest, t.path, ErrVersionPruned)
+	}
+	return nil
+}
+
+// Prune deletes the versions up to and including the given version.
+//
+// IAVL v2 loads a version from the checkpoint preceding it, so the versions are
+// deleted up to the latest checkpoint not newer than the version following the
+// given one: the versions in between stay readable, and are deleted by a later
+// pruning. The deleted versions then fail with ErrVersionPruned. Once versions
+// are deleted, the tree is compacted if cfg.AutoCompactThreshold is exceeded.
 func (t *Tree) Prune(version uint64) error {
-	// do nothing, IAVL v2 has its own advanced pruning mechanism
+	return t.PruneWithProgress(version, func(done, total uint64) {})
+}
+
+// PruneCtx is like Prune but stops with ctx.Err() once ctx is cancelled, in
+// between two checkpoints, leaving the tree usable and the remaining versions to
+// a later pruning.
+func (t *Tree) PruneCtx(ctx context.Context, version uint64) error {
+	return t.prune(ctx, version, func(done, total uint64) {})
+}
+
+// PruneWithProgress is like Prune but calls cb with the number of versions
+// deleted so far and the total number of versions to delete every
+// pruneProgressInterval versions, at a checkpoint, and once done. cb is not
+// called if there is nothing to delete.
+//
+// IAVL v2 also prunes on its own when checkpointing, see PruneRatio and
+// MinimumKeepVersions. The versions are deleted as it does, by flagging their
+// roots as pruned, then the nodes orphaned by them are deleted from the tree
+// shards.
+func (t *Tree) PruneWithProgress(version uint64, cb func(done, total uint64)) error {
+	return t.prune(context.Background(), version, cb)
+}
+
+// PruneAsync deletes the versions up to and including the given version, as
+// Prune, in a background goroutine. It returns a function cancelling the pruning
+// and a channel receiving its result once it returns. A cancelled pruning stops
+// in between two checkpoints, leaving the tree usable and the remaining versions
+// to a later pruning.
+func (t *Tree) PruneAsync(version uint64) (context.CancelFunc, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		errCh <- t.PruneCtx(ctx, version)
+	}()
+	return cancel, errCh
+}
+
+// prune deletes the versions up to and including the given version, one step of
+// at least pruneProgressInterval versions at a time, checking ctx for
+// cancellation in between two steps. A step holds writeMtx, delaying the commits
+// until its nodes are deleted.
+func (t *Tree) prune(ctx context.Context, version uint64, cb func(done, total uint64)) error {
+	if err := isHighBitSet(version); err != nil {
+		return err
+	}
+	if err := t.checkWritable("prune"); err != nil {
+		return err
+	}
+	t.pruneMtx.Lock()
+	defer t.pruneMtx.Unlock()
+
+	earliest, err := earliestVersion(t.dbOptions.Path)
+	if err != nil {
+		return err
+	}
+	// the latest version cannot be deleted
+	to := min(int64(version)+1, t.tree.Version())
+	checkpoints, err := checkpointsBetween(t.dbOptions.Path, earliest, to)
+	if err != nil {
+		return fmt.Errorf("prune: failed to query the checkpoints; path=%s: %w", t.path, err)
+	}
+	if len(checkpoints) == 0 {
+		return nil
+	}
+	last := checkpoints[len(checkpoints)-1]
+	total := uint64(last - earliest)
+	for pruned, i := earliest, 0; pruned < last; i++ {
+		checkpoint := checkpoints[i]
+		if checkpoint != last && checkpoint-pruned < pruneProgressInterval {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("prune: stopped after deleting %d of %d versions; path=%s: %w", pruned-earliest, total, t.path, err)
+		}
+		if err := t.pruneBefore(checkpoint); err != nil {
+			return fmt.Errorf("prune: failed to delete the versions before %d; path=%s: %w", checkpoint, t.path, err)
+		}
+		pruned = checkpoint
+		cb(uint64(pruned-earliest), total)
+	}
+	return t.autoCompact()
+}
+
+// pruneBefore deletes the versions older than the given checkpoint, which becomes
+// the earliest version, along with their cached proofs and clones.
+func (t *Tree) pruneBefore(checkpoint int64) error {
+	t.writeMtx.Lock()
+	err := pruneVersions(t.dbOptions.Path, checkpoint)
+	t.writeMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	t.earliest.Store(checkpoint)
+	t.proofs.removeUpTo(checkpoint - 1)
+	return t.clones.evictUpTo(checkpoint - 1)
+}
+
+// HasVersions returns which of the given versions can be read from the tree, each
+// of them mapped to true if it can. Unlike loading each version, it runs a single
+// query against the SQLite root database, so that e.g. the versions of the
+// snapshots which can still be served are found cheaply.
+func (t *Tree) HasVersions(versions []uint64) (map[uint64]bool, error) {
+	if err := t.checkOpen("has versions"); err != nil {
+		return nil, err
+	}
+	h := t.tree.Version()
+	query := make([]int64, 0, len(versions))
+	for _, version := range versions {
+		if err := isHighBitSet(version); err != nil {
+			return nil, err
+		}
+		// a read-only tree cannot read the versions saved after its own until reloaded
+		if v := int64(version); v != 0 && v <= h {
+			query = append(query, v)
+		}
+	}
+	loadable, err := loadableVersions(t.dbOptions.Path, query)
+	if err != nil {
+		return nil, fmt.Errorf("has versions: failed to query the versions; path=%s: %w", t.path, err)
+	}
+	res := make(map[uint64]bool, len(versions))
+	for _, version := range versions {
+		res[version] = loadable[int64(version)]
+	}
+	return res, nil
+}
+
+// SnapshotVersions returns in ascending order the versions multiple of the given
+// snapshot interval which can be read, and so exported, from the tree, so that a
+// snapshot manager advertises the heights it can serve without trying to export
+// them. As HasVersions, it runs a single query against the SQLite root database.
+func (t *Tree) SnapshotVersions(interval uint64) ([]uint64, error) {
+	if err := isHighBitSet(interval); err != nil {
+		return nil, err
+	}
+	if err := t.checkOpen("snapshot versions"); err != nil {
+		return nil, err
+	}
+	if interval == 0 {
+		return nil, fmt.Errorf("snapshot versions: snapshot interval must be positive; path=%s", t.path)
+	}
+	// a read-only tree cannot read the versions saved after its own until reloaded
+	versions, err := snapshotVersions(t.dbOptions.Path, int64(interval), t.tree.Version())
+	if err != nil {
+		return nil, fmt.Errorf("snapshot versions: failed to query the versions; path=%s: %w", t.path, err)
+	}
+	res := make([]uint64, len(versions))
+	for i, v := range versions {
+		res[i] = uint64(v)
+	}
+	return res, nil
+}
+
+// DeleteVersion deletes the given version alone, so that it can no longer be read
+// while the versions before and after it stay readable, e.g. to keep every 1000th
+// version only. The version must be saved, older than the latest version and not
+// be a checkpoint.
+//
+// IAVL v2 saves the nodes of a version at checkpoints only, the versions in
+// between being loaded by replaying the changes of the versions before them since
+// the previous checkpoint. The changes of the deleted version are thus kept as
+// long as a later version replays them, and deleted once the versions up to the
+// next checkpoint are deleted too, along with theirs: the leaves no longer in the
+// tree at the next checkpoint are deleted, the others are nodes of it. A
+// checkpoint cannot be deleted as the versions after it are loaded from it. The
+// version preceding the latest one is still read from memory until the next
+// commit.
+func (t *Tree) DeleteVersion(version uint64) error {
+	if err := isHighBitSet(version); err != nil {
+		return err
+	}
+	if err := t.checkWritable("delete version"); err != nil {
+		return err
+	}
+	t.pruneMtx.Lock()
+	defer t.pruneMtx.Unlock()
+
+	v := int64(version)
+	h := t.tree.Version()
+	if v >= h {
+		return fmt.Errorf("delete version: cannot delete version %d, not older than the latest version; h: %d path=%s", v, h, t.path)
+	}
+	if err := t.checkPruned("delete version", v); err != nil {
+		return err
+	}
+	ok, err := hasVersion(t.dbOptions.Path, v)
+	if err != nil {
+		return fmt.Errorf("delete version: failed to query version %d; path=%s: %w", v, t.path, err)
+	}
+	if !ok {
+		return fmt.Errorf("delete version: cannot delete version %d; path=%s: %w", v, t.path, ErrVersionNotFound)
+	}
+	checkpoint, err := isCheckpoint(t.dbOptions.Path, v)
+	if err != nil {
+		return fmt.Errorf("delete version: failed to query version %d; path=%s: %w", v, t.path, err)
+	}
+	if checkpoint {
+		return fmt.Errorf("delete version: cannot delete checkpoint %d, the versions after it are loaded from it; path=%s", v, t.path)
+	}
+	if err := t.clones.evictVersion(v); err != nil {
+		return err
+	}
+	t.proofs.removeVersion(v)
+	t.writeMtx.Lock()
+	defer t.writeMtx.Unlock()
+	if err := execSqlite(filepath.Join(t.dbOptions.Path, rootDbName), []string{
+		"DELETE FROM root WHERE version = ?",
+	}, v); err != nil {
+		return fmt.Errorf("delete version: failed to delete version %d; path=%s: %w", v, t.path, err)
+	}
+	count, err := deleteReplayedChanges(t.dbOptions.Path, v)
+	if err != nil {
+		return fmt.Errorf("delete version: failed to delete the changes of version %d; path=%s: %w", v, t.path, err)
+	}
+	if count > 0 {
+		t.log.Info("deleted the changes of the deleted versions", "version", v, "leaves", count)
+	}
 	return nil
 }
 
@@ -187,8 +1388,41 @@ func (t *Tree) IsConcurrentSafe() bool {
 	return true
 }
 
+// WorkingHash returns the root hash of the tree including the uncommitted
+// changes, i.e. the hash the next Commit returns, without saving them. It returns
+// nil if the staged root cannot be hashed.
 func (t *Tree) WorkingHash() []byte {
-	return t.tree.Hash()
+	hash, err := t.stagedHash()
+	if err != nil {
+		t.log.Error("failed to hash the staged root", "err", err)
+		return nil
+	}
+	return hash
+}
+
+// checkOpen returns ErrClosed if the tree has been closed.
+func (t *Tree) checkOpen(op string) error {
+	if t.closed.Load() {
+		return fmt.Errorf("%s: cannot use the tree; path=%s: %w", op, t.path, ErrClosed)
+	}
+	return nil
+}
+
+// checkWritable returns ErrClosed if the tree has been closed and ErrReadOnly if
+// it was opened in read-only mode.
+func (t *Tree) checkWritable(op string) error {
+	if err := t.checkOpen(op); err != nil {
+		return err
+	}
+	if t.readOnly {
+		return fmt.Errorf("%s: cannot write; path=%s: %w", op, t.path, ErrReadOnly)
+	}
+	return nil
+}
+
+// isEmpty returns true if the loaded version of the given tree has no nodes.
+func isEmpty(tree *iavl.Tree) bool {
+	return bytes.Equal(tree.Hash(), emptyHash)
 }
 
 func isHighBitSet(version uint64) error {
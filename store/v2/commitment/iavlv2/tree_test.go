@@ -1,16 +1,34 @@
 package iavlv2
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	protoio "github.com/cosmos/gogoproto/io"
 	"github.com/cosmos/iavl/v2"
+	ics23 "github.com/cosmos/ics23/go"
+	gometrics "github.com/hashicorp/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
 	corelog "cosmossdk.io/core/log"
 	corestore "cosmossdk.io/core/store"
+	coretesting "cosmossdk.io/core/testing"
 	"cosmossdk.io/store/v2/commitment"
+	iavltree "cosmossdk.io/store/v2/commitment/iavl"
+	dbm "cosmossdk.io/store/v2/db"
+	"cosmossdk.io/store/v2/metrics"
+	snapshotstypes "cosmossdk.io/store/v2/snapshots/types"
 )
 
 func TestCommitterSuite(t *testing.T) {
@@ -43,3 +61,2960 @@ func TestCommitterSuite(t *testing.T) {
 
 	suite.Run(t, s)
 }
+
+func TestExportHistoricalVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 5; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d-%d", v, i)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	// version 3 is not a checkpoint and must be replayed from the checkpoint at version 2
+	for _, version := range []uint64{2, 3, 5} {
+		exporter, err := tree.Export(version)
+		require.NoError(t, err)
+
+		leaves := 0
+		for {
+			item, err := exporter.Next()
+			if errors.Is(err, commitment.ErrorExportDone) {
+				break
+			}
+			require.NoError(t, err)
+			require.LessOrEqual(t, item.Version, int64(version))
+			if item.Height == 0 {
+				leaves++
+			}
+		}
+		require.Equal(t, int(version)*10, leaves)
+		require.NoError(t, exporter.Close())
+	}
+
+	// closing the exporter must not close the live tree
+	val, err := tree.Get(5, []byte("key-5-0"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-5-0"), val)
+
+	_, err = tree.Export(6)
+	require.ErrorIs(t, err, ErrFutureVersion)
+}
+
+func TestExportEmptyTree(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	exporter, err := tree.Export(0)
+	require.NoError(t, err)
+	_, err = exporter.Next()
+	require.ErrorIs(t, err, commitment.ErrorExportDone)
+	require.NoError(t, exporter.Close())
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	source, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer source.Close()
+
+	hashes := make(map[uint64][]byte)
+	for v := 1; v <= 5; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, source.Set([]byte(fmt.Sprintf("key-%d-%d", v, i)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		if v > 1 {
+			require.NoError(t, source.Remove([]byte(fmt.Sprintf("key-%d-0", v-1))))
+		}
+		hash, version, err := source.Commit()
+		require.NoError(t, err)
+		hashes[version] = hash
+	}
+
+	// the latest version first, as the source is overwritten from each version
+	for _, version := range []uint64{5, 3} {
+		exporter, err := source.Export(version)
+		require.NoError(t, err)
+
+		target, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		importer, err := target.Import(version)
+		require.NoError(t, err)
+
+		for {
+			item, err := exporter.Next()
+			if errors.Is(err, commitment.ErrorExportDone) {
+				break
+			}
+			require.NoError(t, err)
+			require.NoError(t, importer.Add(item))
+		}
+		require.NoError(t, exporter.Close())
+		require.NoError(t, importer.Commit())
+		require.NoError(t, importer.Close())
+
+		require.Equal(t, version, target.Version())
+		require.Equal(t, hashes[version], target.Hash())
+
+		// every exported key is read back from the imported tree
+		exporter, err = source.Export(version)
+		require.NoError(t, err)
+		leaves := 0
+		for {
+			item, err := exporter.Next()
+			if errors.Is(err, commitment.ErrorExportDone) {
+				break
+			}
+			require.NoError(t, err)
+			if item.Height != 0 {
+				continue
+			}
+			val, err := target.Get(version, item.Key)
+			require.NoError(t, err)
+			require.Equal(t, item.Value, val)
+			leaves++
+		}
+		require.NoError(t, exporter.Close())
+		require.Equal(t, int(version)*9+1, leaves)
+
+		// the imported tree is written on like the source
+		require.NoError(t, source.LoadVersionForOverwriting(version))
+		for _, tree := range []*Tree{source, target} {
+			require.NoError(t, tree.Set([]byte("key-1-1"), []byte("updated")))
+			require.NoError(t, tree.Set([]byte("key-new"), []byte("added")))
+			require.NoError(t, tree.Remove([]byte("key-2-1")))
+		}
+		hash, v, err := source.Commit()
+		require.NoError(t, err)
+		targetHash, targetVersion, err := target.Commit()
+		require.NoError(t, err)
+		require.Equal(t, v, targetVersion)
+		require.Equal(t, hash, targetHash)
+		val, err := target.Get(v, []byte("key-1-1"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("updated"), val)
+		require.NoError(t, target.Close())
+	}
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	source, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer source.Close()
+
+	hashes := make(map[uint64][]byte)
+	for v := 1; v <= 3; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, source.Set([]byte(fmt.Sprintf("key-%d-%d", v, i)), []byte(fmt.Sprintf("value-%d", v))))
+		}
+		hash, version, err := source.Commit()
+		require.NoError(t, err)
+		hashes[version] = hash
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, source.WriteSnapshot(2, &buf))
+
+	target, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer target.Close()
+	importer, err := target.Import(2)
+	require.NoError(t, err)
+	reader := protoio.NewDelimitedReader(&buf, 1<<20)
+	for {
+		var item snapshotstypes.SnapshotItem
+		err := reader.ReadMsg(&item)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		require.NotNil(t, item.GetIAVL())
+		require.NoError(t, importer.Add(item.GetIAVL()))
+	}
+	require.NoError(t, importer.Commit())
+	require.NoError(t, importer.Close())
+
+	require.Equal(t, uint64(2), target.Version())
+	require.Equal(t, hashes[2], target.Hash())
+
+	require.ErrorIs(t, source.WriteSnapshot(4, &buf), ErrFutureVersion)
+}
+
+func TestImportWithRoot(t *testing.T) {
+	source, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer source.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, source.Set([]byte(fmt.Sprintf("key-%d", i)), []byte("value")))
+	}
+	hash, _, err := source.Commit()
+	require.NoError(t, err)
+
+	importInto := func(target *Tree, expectedRoot []byte) error {
+		exporter, err := source.Export(1)
+		require.NoError(t, err)
+		defer exporter.Close()
+		importer, err := target.ImportWithRoot(1, expectedRoot)
+		require.NoError(t, err)
+		defer importer.Close()
+		for {
+			item, err := exporter.Next()
+			if errors.Is(err, commitment.ErrorExportDone) {
+				break
+			}
+			require.NoError(t, err)
+			require.NoError(t, importer.Add(item))
+		}
+		return importer.Commit()
+	}
+
+	path := t.TempDir()
+	target, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer target.Close()
+
+	// the version imported with another root is deleted
+	wrongRoot := bytes.Repeat([]byte{0xab}, len(hash))
+	require.ErrorIs(t, importInto(target, wrongRoot), ErrRootMismatch)
+	require.Equal(t, uint64(0), target.Version())
+	latest, err := latestVersion(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), latest)
+
+	// the tree is left empty, so that the snapshot can be imported again
+	require.NoError(t, importInto(target, hash))
+	require.Equal(t, uint64(1), target.Version())
+	require.Equal(t, hash, target.Hash())
+	for i := 0; i < 10; i++ {
+		val, err := target.Get(1, []byte(fmt.Sprintf("key-%d", i)))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), val)
+	}
+	val, err := target.Get(1, []byte("key-10"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	// the imported version is extended as the source
+	for _, tree := range []*Tree{source, target} {
+		require.NoError(t, tree.Set([]byte("key-10"), []byte("value")))
+		require.NoError(t, tree.Remove([]byte("key-0")))
+	}
+	sourceHash, _, err := source.Commit()
+	require.NoError(t, err)
+	targetHash, version, err := target.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), version)
+	require.Equal(t, sourceHash, targetHash)
+}
+
+func TestExportDiff(t *testing.T) {
+	newTree := func() *Tree {
+		tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		t.Cleanup(func() { tree.Close() })
+		return tree
+	}
+	source := newTree()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, source.Set([]byte(fmt.Sprintf("key-%02d", i)), []byte("value")))
+	}
+	_, v1, err := source.Commit()
+	require.NoError(t, err)
+	require.NoError(t, source.Set([]byte("key-10"), []byte("updated")))
+	require.NoError(t, source.Set([]byte("key-50a"), []byte("added")))
+	require.NoError(t, source.Remove([]byte("key-90")))
+	hash, v2, err := source.Commit()
+	require.NoError(t, err)
+
+	transfer := func(exporter commitment.Exporter, importer commitment.Importer) (items int, err error) {
+		defer exporter.Close()
+		defer importer.Close()
+		for {
+			item, err := exporter.Next()
+			if errors.Is(err, commitment.ErrorExportDone) {
+				return items, importer.Commit()
+			}
+			require.NoError(t, err)
+			if err := importer.Add(item); err != nil {
+				return items, err
+			}
+			items++
+		}
+	}
+	applyDiff := func(target *Tree, from, to uint64) (int, error) {
+		exporter, err := source.ExportDiff(from, to)
+		require.NoError(t, err)
+		importer, err := target.ImportDiff(from, to)
+		if err != nil {
+			exporter.Close()
+			return 0, err
+		}
+		return transfer(exporter, importer)
+	}
+	importFull := func(version uint64) (*Tree, int) {
+		target := newTree()
+		exporter, err := source.Export(version)
+		require.NoError(t, err)
+		importer, err := target.Import(version)
+		require.NoError(t, err)
+		items, err := transfer(exporter, importer)
+		require.NoError(t, err)
+		return target, items
+	}
+
+	// the full snapshot at v2, and the target holding v1 restored from the
+	// snapshot at v1
+	full, fullItems := importFull(v2)
+	require.Equal(t, hash, full.Hash())
+	target, _ := importFull(v1)
+
+	// an aborted diff leaves the tree at the from version
+	exporter, err := source.ExportDiff(v1, v2)
+	require.NoError(t, err)
+	importer, err := target.ImportDiff(v1, v2)
+	require.NoError(t, err)
+	for j := 0; j < 5; j++ {
+		item, err := exporter.Next()
+		require.NoError(t, err)
+		require.NoError(t, importer.Add(item))
+	}
+	require.NoError(t, importer.Close())
+	require.NoError(t, exporter.Close())
+	require.Equal(t, v1, target.Version())
+
+	// the diff applied onto v1 moves the tree to v2
+	items, err := applyDiff(target, v1, v2)
+	require.NoError(t, err)
+	require.Less(t, items, fullItems/2)
+	require.Equal(t, v2, target.Version())
+	require.Equal(t, hash, target.Hash())
+	for key, expected := range map[string][]byte{"key-10": []byte("updated"), "key-50a": []byte("added"), "key-90": nil, "key-99": []byte("value")} {
+		val, err := target.Get(v2, []byte(key))
+		require.NoError(t, err)
+		require.Equal(t, expected, val, key)
+	}
+
+	// a tree moved by a diff is the base of the next one
+	require.NoError(t, source.Set([]byte("key-20"), []byte("updated")))
+	hash, v3, err := source.Commit()
+	require.NoError(t, err)
+	_, err = applyDiff(target, v2, v3)
+	require.NoError(t, err)
+	require.Equal(t, hash, target.Hash())
+
+	// and is extended as the source
+	for _, tree := range []*Tree{source, target} {
+		require.NoError(t, tree.Set([]byte("key-30"), []byte("updated")))
+		require.NoError(t, tree.Remove([]byte("key-40")))
+	}
+	hash, _, err = source.Commit()
+	require.NoError(t, err)
+	targetHash, v4, err := target.Commit()
+	require.NoError(t, err)
+	require.Equal(t, hash, targetHash)
+
+	// the diff of an unchanged version refers to its leftmost leaf and its root
+	exporter, err = source.ExportDiff(v1, v1)
+	require.NoError(t, err)
+	defer exporter.Close()
+	items = 0
+	for ; ; items++ {
+		if _, err := exporter.Next(); errors.Is(err, commitment.ErrorExportDone) {
+			break
+		}
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, items)
+
+	// the diff must be applied onto the tree at the from version, saved as a
+	// checkpoint
+	_, err = target.ImportDiff(v1, v2)
+	require.Error(t, err)
+	_, err = target.ImportDiff(v4, v4+1)
+	require.Error(t, err)
+
+	_, err = source.ExportDiff(v2, v1)
+	require.Error(t, err)
+}
+
+func TestImportMalformed(t *testing.T) {
+	leaf := func(key string) *snapshotstypes.SnapshotIAVLItem {
+		return &snapshotstypes.SnapshotIAVLItem{Key: []byte(key), Value: []byte(key), Version: 1}
+	}
+	inner := func(key string, height int32) *snapshotstypes.SnapshotIAVLItem {
+		return &snapshotstypes.SnapshotIAVLItem{Key: []byte(key), Version: 1, Height: height}
+	}
+
+	testCases := []struct {
+		name      string
+		items     []*snapshotstypes.SnapshotIAVLItem
+		addErr    bool
+		commitErr bool
+	}{
+		{"nil node", []*snapshotstypes.SnapshotIAVLItem{nil}, true, false},
+		{"future version", []*snapshotstypes.SnapshotIAVLItem{{Key: []byte("a"), Value: []byte("a"), Version: 2}}, true, false},
+		{"negative version", []*snapshotstypes.SnapshotIAVLItem{{Key: []byte("a"), Value: []byte("a"), Version: -1}}, true, false},
+		{"negative height", []*snapshotstypes.SnapshotIAVLItem{inner("a", -1)}, true, false},
+		{"inner node first", []*snapshotstypes.SnapshotIAVLItem{inner("a", 1)}, true, false},
+		{"unordered leaves", []*snapshotstypes.SnapshotIAVLItem{leaf("b"), leaf("a")}, true, false},
+		{"wrong inner height", []*snapshotstypes.SnapshotIAVLItem{leaf("a"), leaf("b"), inner("b", 2)}, true, false},
+		{"wrong inner key", []*snapshotstypes.SnapshotIAVLItem{leaf("a"), leaf("b"), inner("a", 1)}, true, false},
+		{"wrong root key", []*snapshotstypes.SnapshotIAVLItem{
+			leaf("a"), leaf("b"), inner("b", 1), leaf("c"), leaf("d"), inner("d", 1), inner("b", 2),
+		}, true, false},
+		{"unattached subtrees", []*snapshotstypes.SnapshotIAVLItem{leaf("a"), leaf("b")}, false, true},
+		{"well-formed", []*snapshotstypes.SnapshotIAVLItem{
+			leaf("a"), leaf("b"), inner("b", 1), leaf("c"), leaf("d"), inner("d", 1), inner("c", 2),
+		}, false, false},
+		{"empty", nil, false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+			require.NoError(t, err)
+			defer tree.Close()
+
+			importer, err := tree.Import(1)
+			require.NoError(t, err)
+			defer importer.Close()
+
+			var addErr error
+			for _, item := range tc.items {
+				if addErr = importer.Add(item); addErr != nil {
+					break
+				}
+			}
+			if tc.addErr {
+				require.Error(t, addErr)
+				return
+			}
+			require.NoError(t, addErr)
+			if tc.commitErr {
+				require.Error(t, importer.Commit())
+			} else {
+				require.NoError(t, importer.Commit())
+			}
+		})
+	}
+}
+
+func TestIteratorHistoricalVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 5; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	collect := func(itr corestore.Iterator) []string {
+		var keys []string
+		for ; itr.Valid(); itr.Next() {
+			keys = append(keys, string(itr.Key()))
+		}
+		require.NoError(t, itr.Error())
+		require.NoError(t, itr.Close())
+		return keys
+	}
+
+	// version 2 is a checkpoint, version 3 is replayed from it
+	itr, err := tree.Iterator(2, nil, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"key-1", "key-2"}, collect(itr))
+
+	itr, err = tree.Iterator(3, nil, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"key-3", "key-2", "key-1"}, collect(itr))
+
+	// the end key is exclusive
+	itr, err = tree.Iterator(3, []byte("key-1"), []byte("key-3"), true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"key-1", "key-2"}, collect(itr))
+
+	_, err = tree.Iterator(6, nil, nil, true)
+	require.ErrorIs(t, err, ErrFutureVersion)
+}
+
+func TestLoadVersionForOverwriting(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 3
+	cfg.StateStorage = true
+	dbOptions := iavl.SqliteDbOptions{Path: t.TempDir()}
+	tree, err := NewTree(cfg, dbOptions, coretesting.NewNopLogger())
+	require.NoError(t, err)
+
+	commitVersions := func(from, to int, prefix string) map[uint64][]byte {
+		hashes := make(map[uint64][]byte)
+		for v := from; v <= to; v++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("%s-%d", prefix, v))))
+			hash, version, err := tree.Commit()
+			require.NoError(t, err)
+			require.Equal(t, uint64(v), version)
+			hashes[version] = hash
+		}
+		return hashes
+	}
+
+	hashes := commitVersions(1, 10, "value")
+	require.NoError(t, tree.LoadVersionForOverwriting(5))
+
+	latest, err := tree.GetLatestVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), latest)
+	require.Equal(t, hashes[5], tree.Hash())
+	for v := uint64(6); v <= 10; v++ {
+		_, err = tree.Get(v, []byte("key-1"))
+		require.Error(t, err)
+	}
+	val, err := tree.Get(5, []byte("key-6"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	has, err := tree.Has(5, []byte("key-6"))
+	require.NoError(t, err)
+	require.False(t, has)
+	itr, err := tree.Iterator(5, nil, nil, true)
+	require.NoError(t, err)
+	var keys []string
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Key()))
+	}
+	require.NoError(t, itr.Close())
+	require.Equal(t, []string{"key-1", "key-2", "key-3", "key-4", "key-5"}, keys)
+	// no leaf of the deleted versions is left in the root database
+	count, err := queryInt64(filepath.Join(dbOptions.Path, rootDbName), "SELECT COUNT(*) FROM latest")
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// versions 6..10 can be committed again with different data
+	newHashes := commitVersions(6, 10, "new")
+	require.NotEqual(t, hashes[10], newHashes[10])
+	require.NoError(t, tree.Close())
+
+	// the overwritten versions are persisted
+	tree, err = NewTree(cfg, dbOptions, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	require.NoError(t, tree.LoadVersion(10))
+	require.Equal(t, newHashes[10], tree.Hash())
+	for _, v := range []uint64{7, 10} {
+		val, err = tree.Get(v, []byte("key-6"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("new-6"), val)
+	}
+	val, err = tree.Get(3, []byte("key-3"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-3"), val)
+}
+
+func TestRollback(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	cfg.StateStorage = true
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	hashes := make(map[uint64][]byte)
+	for v := 1; v <= 6; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("value-%d", v))))
+		if v > 3 {
+			// the keys of the rolled back versions are updated and removed
+			require.NoError(t, tree.Set([]byte("key-1"), []byte(fmt.Sprintf("updated-%d", v))))
+			require.NoError(t, tree.Remove([]byte("key-2")))
+		}
+		hash, version, err := tree.Commit()
+		require.NoError(t, err)
+		hashes[version] = hash
+	}
+
+	require.ErrorIs(t, tree.Rollback(7), ErrFutureVersion)
+	require.Error(t, tree.Rollback(1<<63))
+
+	// uncommitted changes are discarded
+	require.NoError(t, tree.Set([]byte("dirty"), []byte("dirty")))
+	require.NoError(t, tree.Rollback(3))
+	latest, err := tree.GetLatestVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), latest)
+	require.Equal(t, hashes[3], tree.Hash())
+	require.Equal(t, hashes[3], tree.WorkingHash())
+
+	val, err := tree.Get(3, []byte("key-3"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-3"), val)
+	val, err = tree.Get(3, []byte("key-4"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	val, err = tree.Get(3, []byte("key-1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-1"), val)
+	has, err := tree.Has(3, []byte("key-2"))
+	require.NoError(t, err)
+	require.True(t, has)
+	itr, err := tree.Iterator(3, nil, nil, true)
+	require.NoError(t, err)
+	var pairs []string
+	for ; itr.Valid(); itr.Next() {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", itr.Key(), itr.Value()))
+	}
+	require.NoError(t, itr.Close())
+	require.Equal(t, []string{"key-1=value-1", "key-2=value-2", "key-3=value-3"}, pairs)
+
+	_, version, err := tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), version)
+}
+
+// newTelemetrySink enables the telemetry of the stores until the end of the test,
+// the metrics being emitted to the returned in-memory sink.
+func newTelemetrySink(t *testing.T) *gometrics.InmemSink {
+	t.Helper()
+	sink := gometrics.NewInmemSink(time.Hour, time.Hour)
+	cfg := gometrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	_, err := gometrics.NewGlobal(cfg, sink)
+	require.NoError(t, err)
+	metrics.SetTelemetryEnabled(true)
+	t.Cleanup(func() {
+		metrics.SetTelemetryEnabled(false)
+		_, _ = gometrics.NewGlobal(cfg, &gometrics.BlackholeSink{})
+	})
+	return sink
+}
+
+// sinkData returns the metrics emitted to sink so far.
+func sinkData(sink *gometrics.InmemSink) *gometrics.IntervalMetrics {
+	data := sink.Data()
+	return data[len(data)-1]
+}
+
+// sampleCount returns the number of values of the counter or timer with the given
+// key, 0 if none was emitted.
+func sampleCount(samples map[string]gometrics.SampledValue, key string) int {
+	if sample, ok := samples[key]; ok {
+		return sample.Count
+	}
+	return 0
+}
+
+func TestCommitMetrics(t *testing.T) {
+	sink := newTelemetrySink(t)
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: filepath.Join(t.TempDir(), "bank")}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.SetInitialVersion(1))
+	require.Equal(t, 1, sampleCount(sinkData(sink).Counters, "iavl_v2.checkpoint;store=bank"))
+
+	require.NoError(t, tree.Set([]byte("key1"), []byte("value1")))
+	require.NoError(t, tree.Set([]byte("key2"), []byte("value2")))
+	require.NoError(t, tree.Remove([]byte("key1")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, 1, sampleCount(sinkData(sink).Samples, "iavl_v2.commit;store=bank"))
+	require.Equal(t, float32(2), sinkData(sink).Gauges["iavl_v2.commit_sets;store=bank"].Value)
+	require.Equal(t, float32(1), sinkData(sink).Gauges["iavl_v2.commit_removes;store=bank"].Value)
+
+	// the operation counts are reset after each commit
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, 2, sampleCount(sinkData(sink).Samples, "iavl_v2.commit;store=bank"))
+	require.Equal(t, float32(0), sinkData(sink).Gauges["iavl_v2.commit_sets;store=bank"].Value)
+	require.Equal(t, float32(0), sinkData(sink).Gauges["iavl_v2.commit_removes;store=bank"].Value)
+
+	// nothing is emitted with the telemetry disabled
+	metrics.SetTelemetryEnabled(false)
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, 2, sampleCount(sinkData(sink).Samples, "iavl_v2.commit;store=bank"))
+}
+
+func TestGetMany(t *testing.T) {
+	cfg := DefaultConfig()
+	// a clone per read
+	cfg.ClonePoolSize = 0
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 5; v++ {
+		require.NoError(t, tree.Set([]byte("a"), []byte(fmt.Sprintf("a-%d", v))))
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	keys := [][]byte{[]byte("a"), []byte("key-2"), []byte("key-4"), []byte("missing"), []byte("a")}
+	for _, version := range []uint64{0, 1, 3, 4, 5} {
+		vals, err := tree.GetMany(version, keys)
+		require.NoError(t, err)
+		require.Len(t, vals, len(keys))
+		for i, key := range keys {
+			val, err := tree.Get(version, key)
+			require.NoError(t, err)
+			require.Equal(t, val, vals[i], "version %d key %s", version, key)
+		}
+	}
+	vals, err := tree.GetMany(3, keys)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("a-3"), []byte("value"), nil, nil, []byte("a-3")}, vals)
+
+	vals, err = tree.GetMany(3, nil)
+	require.NoError(t, err)
+	require.Empty(t, vals)
+	_, err = tree.GetMany(6, keys)
+	require.ErrorIs(t, err, ErrFutureVersion)
+}
+
+func TestCommitPanic(t *testing.T) {
+	cfg := DefaultConfig()
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+
+	// a tree without database panics when saving a version
+	require.NoError(t, tree.Set([]byte("key"), []byte("updated")))
+	live := tree.tree
+	tree.tree = iavl.NewTree(nil, iavl.NewNodePool(), cfg.ToTreeOptions())
+	require.NotPanics(t, func() {
+		_, _, err = tree.Commit()
+	})
+	require.ErrorContains(t, err, "panic")
+	tree.tree = live
+}
+
+func TestEmptyValue(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 1
+	path := t.TempDir()
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.Set([]byte("empty"), []byte{}))
+	require.ErrorIs(t, tree.Set([]byte("nil"), nil), ErrNilValue)
+	require.ErrorIs(t, tree.SetBatch([]corestore.KVPair{
+		{Key: []byte("batch"), Value: []byte{}},
+		{Key: []byte("nil"), Value: nil},
+	}), ErrNilValue)
+	// the live tree, then a clone reading it from SQLite
+	for range 3 {
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	for _, version := range []uint64{0, 1} {
+		for _, key := range []string{"empty", "batch"} {
+			val, err := tree.Get(version, []byte(key))
+			require.NoError(t, err)
+			require.NotNil(t, val, key)
+			require.Empty(t, val, key)
+			has, err := tree.Has(version, []byte(key))
+			require.NoError(t, err)
+			require.True(t, has, key)
+		}
+		val, err := tree.Get(version, []byte("nil"))
+		require.NoError(t, err)
+		require.Nil(t, val)
+		has, err := tree.Has(version, []byte("nil"))
+		require.NoError(t, err)
+		require.False(t, has)
+	}
+
+	require.NoError(t, tree.Remove([]byte("empty")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	has, err := tree.Has(0, []byte("empty"))
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestRemoveWithResult(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+
+	removed, err := tree.RemoveWithResult([]byte("key"))
+	require.NoError(t, err)
+	require.True(t, removed)
+	removed, err = tree.RemoveWithResult([]byte("key"))
+	require.NoError(t, err)
+	require.False(t, removed)
+	removed, err = tree.RemoveWithResult([]byte("missing"))
+	require.NoError(t, err)
+	require.False(t, removed)
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	has, err := tree.Has(0, []byte("key"))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.NoError(t, tree.Close())
+	_, err = tree.RemoveWithResult([]byte("key"))
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestCommitListener(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	type commit struct {
+		version uint64
+		changes []corestore.KVPair
+	}
+	var commits []commit
+	tree.SetCommitListener(func(version uint64, changes []corestore.KVPair) {
+		// the version is saved when the listener is called
+		val, err := tree.Get(version, []byte("a"))
+		require.NoError(t, err)
+		require.NotNil(t, val)
+		commits = append(commits, commit{version, changes})
+	})
+
+	require.NoError(t, tree.Set([]byte("a"), []byte("1")))
+	require.NoError(t, tree.Set([]byte("b"), []byte("2")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.NoError(t, tree.SetBatch([]corestore.KVPair{{Key: []byte("b"), Remove: true}, {Key: []byte("c"), Value: []byte("3")}}))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, []commit{
+		{1, []corestore.KVPair{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("2")}}},
+		{2, []corestore.KVPair{{Key: []byte("b"), Remove: true}, {Key: []byte("c"), Value: []byte("3")}}},
+	}, commits)
+
+	// a panicking listener does not fail the commit
+	tree.SetCommitListener(func(uint64, []corestore.KVPair) { panic("boom") })
+	require.NoError(t, tree.Set([]byte("a"), []byte("4")))
+	hash, version, err := tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), version)
+	require.Equal(t, hash, tree.Hash())
+	val, err := tree.Get(3, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("4"), val)
+
+	tree.SetCommitListener(nil)
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+}
+
+func TestStats(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	stats := tree.Stats()
+	require.Equal(t, uint64(0), stats.Version)
+	require.Equal(t, int64(0), stats.Size)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	require.Positive(t, tree.Stats().WorkingBytes)
+
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	stats = tree.Stats()
+	require.Equal(t, uint64(1), stats.Version)
+	require.Equal(t, int64(10), stats.Size)
+	require.Equal(t, int8(4), stats.Height)
+	require.Positive(t, stats.DiskSize)
+	// SQLite defaults to a page cache of 2000 KiB per connection
+	require.Equal(t, int64(2000*1024), stats.PageCacheSize)
+	require.Positive(t, stats.PageCount)
+	require.Equal(t, uint64(1), stats.CommitLatency.Count)
+	require.Positive(t, stats.CommitLatency.Max)
+	require.Equal(t, uint64(1), stats.CommitChanges.Count)
+	require.Equal(t, float64(10), stats.CommitChanges.Max)
+}
+
+func TestCollector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bank")
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 3; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(NewCollector(tree)))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	metrics := make(map[string]*dto.Metric, len(families))
+	for _, family := range families {
+		require.Len(t, family.Metric, 1)
+		metrics[family.GetName()] = family.Metric[0]
+		require.Equal(t, "store", family.Metric[0].Label[0].GetName())
+		require.Equal(t, "bank", family.Metric[0].Label[0].GetValue())
+	}
+	require.Equal(t, float64(3), metrics["iavl_v2_version"].GetGauge().GetValue())
+	require.Equal(t, float64(1), metrics["iavl_v2_earliest_version"].GetGauge().GetValue())
+	require.Equal(t, float64(3), metrics["iavl_v2_keys"].GetGauge().GetValue())
+	require.Positive(t, metrics["iavl_v2_disk_bytes"].GetGauge().GetValue())
+	require.Positive(t, metrics["iavl_v2_page_cache_bytes"].GetGauge().GetValue())
+	require.Positive(t, metrics["iavl_v2_pages"].GetGauge().GetValue())
+	require.Equal(t, uint64(3), metrics["iavl_v2_commit_latency_ms"].GetHistogram().GetSampleCount())
+	changes := metrics["iavl_v2_commit_changes"].GetHistogram()
+	require.Equal(t, uint64(3), changes.GetSampleCount())
+	require.Equal(t, float64(3), changes.GetSampleSum())
+	// each commit has a single change, counted in the first bucket
+	require.Equal(t, uint64(3), changes.Bucket[0].GetCumulativeCount())
+
+	// a second tree can be registered with the same registry
+	other, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: filepath.Join(t.TempDir(), "staking")}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer other.Close()
+	require.NoError(t, reg.Register(NewCollector(other)))
+	_, err = reg.Gather()
+	require.NoError(t, err)
+}
+
+func TestKeyCountAndApproxSize(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	count, err := tree.KeyCount(0)
+	require.NoError(t, err)
+	require.Zero(t, count)
+	size, err := tree.ApproxSize(0)
+	require.NoError(t, err)
+	require.Zero(t, size)
+
+	// 10 bytes per key and value
+	for i := 0; i < 10; i++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	for i := 10; i < 1000; i++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("val-%04d", i))))
+	}
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	// uncommitted changes are not counted
+	require.NoError(t, tree.Set([]byte("pending"), []byte("pending")))
+
+	count, err = tree.KeyCount(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), count)
+	size, err = tree.ApproxSize(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), size)
+
+	count, err = tree.KeyCount(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), count)
+	size, err = tree.ApproxSize(2)
+	require.NoError(t, err)
+	require.InDelta(t, 16*1000, size, 16*10)
+
+	_, err = tree.KeyCount(3)
+	require.ErrorIs(t, err, ErrFutureVersion)
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(1, 4)
+	require.Equal(t, float64(0), h.snapshot().Quantile(0.5))
+
+	for _, v := range []float64{0.5, 1, 3, 3, 5, 7, 8, 8, 8, 100} {
+		h.observe(v)
+	}
+	s := h.snapshot()
+	require.Equal(t, []float64{1, 2, 4, 8}, s.Bounds)
+	require.Equal(t, []uint64{2, 0, 2, 5, 1}, s.Counts)
+	require.Equal(t, uint64(10), s.Count)
+	require.Equal(t, float64(143.5), s.Sum)
+	require.Equal(t, float64(100), s.Max)
+	require.Equal(t, float64(1), s.Quantile(0.1))
+	require.Equal(t, float64(8), s.Quantile(0.5))
+	require.Equal(t, float64(100), s.Quantile(0.99))
+
+	// the snapshot is not affected by later observations
+	h.observe(1)
+	require.Equal(t, uint64(2), s.Counts[0])
+}
+
+func TestSetBatch(t *testing.T) {
+	batchTree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer batchTree.Close()
+	seqTree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer seqTree.Close()
+
+	pairs := []corestore.KVPair{
+		{Key: []byte("key1"), Value: []byte("value1")},
+		{Key: []byte("key2"), Value: []byte("value2")},
+		{Key: []byte("key1"), Value: []byte("updated")},
+		{Key: []byte("key3"), Value: []byte("value3")},
+		{Key: []byte("key2"), Remove: true},
+	}
+	require.NoError(t, batchTree.SetBatch(pairs))
+	for _, pair := range pairs {
+		if pair.Remove {
+			require.NoError(t, seqTree.Remove(pair.Key))
+		} else {
+			require.NoError(t, seqTree.Set(pair.Key, pair.Value))
+		}
+	}
+
+	batchHash, _, err := batchTree.Commit()
+	require.NoError(t, err)
+	seqHash, _, err := seqTree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, seqHash, batchHash)
+
+	val, err := batchTree.Get(1, []byte("key1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("updated"), val)
+
+	err = batchTree.SetBatch([]corestore.KVPair{
+		{Key: []byte("key4"), Value: []byte("value4")},
+		{Key: []byte("key5"), Value: nil},
+	})
+	require.ErrorContains(t, err, "pair 1")
+}
+
+func TestSetInitialVersion(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.SetInitialVersion(5))
+	require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+	_, v, err := tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), v)
+
+	require.ErrorContains(t, tree.SetInitialVersion(10), "committed versions")
+	latest, err := tree.GetLatestVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), latest)
+	_, v, err = tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), v)
+}
+
+func TestWorkingHash(t *testing.T) {
+	cfg := DefaultConfig()
+	// the nodes deeper than the root are evicted at every checkpoint, their hash is
+	// read from the databases
+	cfg.CheckpointInterval = 2
+	cfg.EvictionDepth = 1
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.Equal(t, tree.Hash(), tree.WorkingHash())
+	for v := 1; v <= 6; v++ {
+		for i := 0; i < 20; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", (v*7+i)%50)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		require.NoError(t, tree.Remove([]byte(fmt.Sprintf("key-%d", v*3))))
+
+		workingHash := tree.WorkingHash()
+		require.NotNil(t, workingHash)
+		require.NotEqual(t, tree.Hash(), workingHash)
+		// hashing the staged root changes neither the tree nor the next commit
+		require.Equal(t, workingHash, tree.WorkingHash())
+		hash, _, err := tree.Commit()
+		require.NoError(t, err)
+		require.Equal(t, hash, workingHash)
+		require.Equal(t, hash, tree.WorkingHash())
+	}
+
+	// the tree emptied
+	for i := 0; i < 50; i++ {
+		require.NoError(t, tree.Remove([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	workingHash := tree.WorkingHash()
+	hash, _, err := tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, hash, workingHash)
+}
+
+func TestDryRunCommit(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	// the uncommitted changes are not kept in memory without a commit listener
+	require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+	require.Empty(t, tree.pending)
+	require.NoError(t, tree.Close())
+
+	tree, err = NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.SetInitialVersion(3))
+	for v := 0; v < 4; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d-%d", v, i)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		if v > 0 {
+			require.NoError(t, tree.Remove([]byte(fmt.Sprintf("key-%d-0", v-1))))
+		}
+		require.NoError(t, tree.SetBatch([]corestore.KVPair{{Key: []byte("batch"), Value: []byte(fmt.Sprint(v))}}))
+
+		latest := tree.Version()
+		dryRunHash, err := tree.DryRunCommit()
+		require.NoError(t, err)
+		// the dry run neither commits nor discards the changes
+		require.Equal(t, latest, tree.Version())
+		hash, version, err := tree.Commit()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3+v), version)
+		require.Equal(t, hash, dryRunHash)
+	}
+
+	// the tree emptied at the latest version
+	for i := 1; i < 10; i++ {
+		require.NoError(t, tree.Remove([]byte(fmt.Sprintf("key-3-%d", i))))
+	}
+	for v := 0; v < 3; v++ {
+		for i := 1; i < 10; i++ {
+			require.NoError(t, tree.Remove([]byte(fmt.Sprintf("key-%d-%d", v, i))))
+		}
+	}
+	require.NoError(t, tree.Remove([]byte("batch")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+	dryRunHash, err := tree.DryRunCommit()
+	require.NoError(t, err)
+	hash, _, err := tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, hash, dryRunHash)
+}
+
+func TestGetNonExistenceProof(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for _, key := range []string{"b", "d", "f"} {
+		require.NoError(t, tree.Set([]byte(key), []byte("value-"+key)))
+	}
+	root, version, err := tree.Commit()
+	require.NoError(t, err)
+
+	// keys left of, between and right of the existing keys
+	for _, key := range []string{"a", "c", "e", "g"} {
+		proof, err := tree.GetProof(version, []byte(key))
+		require.NoError(t, err)
+		require.NotNil(t, proof.GetNonexist(), key)
+		require.True(t, ics23.VerifyNonMembership(ics23.IavlSpec, root, proof, []byte(key)), key)
+		require.False(t, ics23.VerifyMembership(ics23.IavlSpec, root, proof, []byte(key), []byte("value-"+key)), key)
+	}
+
+	proof, err := tree.GetProof(version, []byte("d"))
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyMembership(ics23.IavlSpec, root, proof, []byte("d"), []byte("value-d")))
+	require.False(t, ics23.VerifyNonMembership(ics23.IavlSpec, root, proof, []byte("d")))
+
+	// a key added in a later version is absent at the earlier version
+	require.NoError(t, tree.Set([]byte("c"), []byte("value-c")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	proof, err = tree.GetProof(version, []byte("c"))
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyNonMembership(ics23.IavlSpec, root, proof, []byte("c")))
+}
+
+func TestProofCache(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ProofCacheSize = 2
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, tree.Set([]byte(key), []byte("value-"+key)))
+	}
+	root, v1, err := tree.Commit()
+	require.NoError(t, err)
+
+	proof, err := tree.GetProof(v1, []byte("a"))
+	require.NoError(t, err)
+	cached, err := tree.GetProof(v1, []byte("a"))
+	require.NoError(t, err)
+	require.Same(t, proof, cached)
+	require.True(t, ics23.VerifyMembership(ics23.IavlSpec, root, cached, []byte("a"), []byte("value-a")))
+
+	// the least recently used proofs are evicted beyond the size of the cache
+	for _, key := range []string{"b", "c"} {
+		_, err = tree.GetProof(v1, []byte(key))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, tree.proofs.len())
+	proof, err = tree.GetProof(v1, []byte("a"))
+	require.NoError(t, err)
+	require.NotSame(t, cached, proof)
+
+	// the cache is purged on commit
+	require.NoError(t, tree.Set([]byte("d"), []byte("value-d")))
+	_, v2, err := tree.Commit()
+	require.NoError(t, err)
+	require.Zero(t, tree.proofs.len())
+
+	// the proofs of the pruned versions are removed
+	for _, v := range []uint64{v1, v2} {
+		_, err = tree.GetProof(v, []byte("a"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tree.Prune(v1))
+	require.Equal(t, 1, tree.proofs.len())
+	require.NotNil(t, tree.proofs.get(int64(v2), []byte("a")))
+
+	// the cache is disabled by default
+	tree, err = NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	require.NoError(t, tree.Set([]byte("a"), []byte("value-a")))
+	_, v1, err = tree.Commit()
+	require.NoError(t, err)
+	proof, err = tree.GetProof(v1, []byte("a"))
+	require.NoError(t, err)
+	cached, err = tree.GetProof(v1, []byte("a"))
+	require.NoError(t, err)
+	require.NotSame(t, proof, cached)
+	require.Zero(t, tree.proofs.len())
+}
+
+func TestGetRangeProof(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	_, err = tree.GetRangeProof(0, nil, nil)
+	require.ErrorIs(t, err, ErrEmptyRange)
+
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1", "c/1"} {
+		require.NoError(t, tree.Set([]byte(key), []byte("value-"+key)))
+	}
+	root, version, err := tree.Commit()
+	require.NoError(t, err)
+	// a key added in a later version is not part of the range at the earlier version
+	require.NoError(t, tree.Set([]byte("a/4"), []byte("value-a/4")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+
+	proof, err := tree.GetRangeProof(version, []byte("a/"), []byte("b/"))
+	require.NoError(t, err)
+	items := map[string][]byte{
+		"a/1": []byte("value-a/1"),
+		"a/2": []byte("value-a/2"),
+		"a/3": []byte("value-a/3"),
+	}
+	require.True(t, ics23.BatchVerifyMembership(ics23.IavlSpec, root, proof, items))
+	require.Len(t, ics23.Decompress(proof).GetBatch().Entries, 3)
+
+	// a tampered value or a key of the range at a later version does not verify
+	items["a/2"] = []byte("tampered")
+	require.False(t, ics23.BatchVerifyMembership(ics23.IavlSpec, root, proof, items))
+	require.False(t, ics23.BatchVerifyMembership(ics23.IavlSpec, root, proof, map[string][]byte{"a/4": []byte("value-a/4")}))
+
+	// the whole tree
+	proof, err = tree.GetRangeProof(version, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, ics23.Decompress(proof).GetBatch().Entries, 5)
+
+	_, err = tree.GetRangeProof(version, []byte("b/2"), []byte("c/"))
+	require.ErrorIs(t, err, ErrEmptyRange)
+	_, err = tree.GetRangeProof(version+2, nil, nil)
+	require.ErrorIs(t, err, ErrFutureVersion)
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 0
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 3; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+		require.Error(t, tree.Checkpoint())
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	hash := tree.Hash()
+
+	require.NoError(t, tree.Checkpoint())
+	// a copy of the database files alone holds the latest version
+	backup := t.TempDir()
+	dbPaths, err := databasePaths(dir)
+	require.NoError(t, err)
+	for _, dbPath := range dbPaths {
+		bz, err := os.ReadFile(dbPath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(backup, filepath.Base(dbPath)), bz, 0o600))
+	}
+	restored, err := NewTree(cfg, iavl.SqliteDbOptions{Path: backup}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, restored.LoadVersion(3))
+	require.Equal(t, hash, restored.Hash())
+	require.NoError(t, restored.Close())
+
+	// the next commit checkpoints the tree
+	ok, err := isCheckpoint(dir, 3)
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, version, err := tree.Commit()
+	require.NoError(t, err)
+	ok, err = isCheckpoint(dir, int64(version))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestCompact(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 3; v++ {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tree.Set([]byte("dirty"), []byte("dirty")))
+	require.Error(t, tree.Compact())
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	hash := tree.Hash()
+
+	require.NoError(t, tree.Compact())
+	require.Equal(t, uint64(4), tree.Version())
+	require.Equal(t, hash, tree.Hash())
+	val, err := tree.Get(4, []byte("key-10"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-3-10"), val)
+
+	_, version, err := tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), version)
+}
+
+func TestAutoCompact(t *testing.T) {
+	for _, tc := range []struct {
+		threshold float64
+		compacted bool
+	}{
+		{threshold: 0, compacted: false},
+		{threshold: 0.1, compacted: true},
+		{threshold: 0.99, compacted: false},
+	} {
+		t.Run(fmt.Sprintf("threshold=%v", tc.threshold), func(t *testing.T) {
+			dir := t.TempDir()
+			logger := &recordLogger{Logger: coretesting.NewNopLogger(), lines: make(map[string][]any)}
+			cfg := DefaultConfig()
+			cfg.CheckpointInterval = 1
+			cfg.AutoCompactThreshold = tc.threshold
+			tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, logger)
+			require.NoError(t, err)
+			defer tree.Close()
+
+			// the values are overwritten at every version, the nodes of the pruned
+			// versions freeing pages
+			for v := 1; v <= 10; v++ {
+				for i := 0; i < 20; i++ {
+					require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", i)), bytes.Repeat([]byte{byte(v)}, 4096)))
+				}
+				_, _, err = tree.Commit()
+				require.NoError(t, err)
+			}
+
+			// the tree is compacted in place, the reads of the retained versions from
+			// SQLite going on
+			done := make(chan struct{})
+			readErr := make(chan error, 1)
+			go func() {
+				defer close(readErr)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if _, err := tree.Get(8, []byte("key-0")); err != nil {
+						readErr <- err
+						return
+					}
+				}
+			}()
+			require.NoError(t, tree.Prune(7))
+			close(done)
+			require.NoError(t, <-readErr)
+			require.Equal(t, tc.compacted, logger.lines["auto-compacted tree"] != nil)
+			ratio, err := freePageRatio(dir)
+			require.NoError(t, err)
+			if tc.compacted {
+				require.Zero(t, ratio)
+			} else {
+				require.Greater(t, ratio, 0.1)
+			}
+
+			// nothing deleted, nothing compacted
+			logger.lines = make(map[string][]any)
+			require.NoError(t, tree.Prune(7))
+			require.Nil(t, logger.lines["auto-compacted tree"])
+
+			for _, v := range []uint64{8, 9, 10} {
+				val, err := tree.Get(v, []byte("key-19"))
+				require.NoError(t, err)
+				require.Equal(t, bytes.Repeat([]byte{byte(v)}, 4096), val)
+			}
+			_, version, err := tree.Commit()
+			require.NoError(t, err)
+			require.Equal(t, uint64(11), version)
+		})
+	}
+
+	cfg := DefaultConfig()
+	cfg.AutoCompactThreshold = 1
+	require.Error(t, cfg.Validate())
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	for _, tc := range []struct {
+		interval    int64
+		checkpoints int64
+	}{
+		// the initial version is always checkpointed
+		{interval: 0, checkpoints: 1},
+		{interval: 3, checkpoints: 4},
+		{interval: 10, checkpoints: 1},
+	} {
+		t.Run(fmt.Sprintf("interval=%d", tc.interval), func(t *testing.T) {
+			sink := newTelemetrySink(t)
+			cfg := DefaultConfig()
+			cfg.CheckpointInterval = tc.interval
+			path := filepath.Join(t.TempDir(), "store")
+			tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+			require.NoError(t, err)
+			defer tree.Close()
+
+			for v := 1; v <= 9; v++ {
+				require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+				_, _, err = tree.Commit()
+				require.NoError(t, err)
+			}
+
+			checkpoints, err := queryInt64(filepath.Join(path, rootDbName), "SELECT COUNT(*) FROM root WHERE checkpoint = true")
+			require.NoError(t, err)
+			require.Equal(t, tc.checkpoints, checkpoints)
+			require.Equal(t, int(tc.checkpoints-1), sampleCount(sinkData(sink).Counters, "iavl_v2.checkpoint;store=store"))
+		})
+	}
+
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = -1
+	_, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.Error(t, err)
+}
+
+func TestSetTreeOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 3; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	opts := tree.cfg.ToTreeOptions()
+	opts.StateStorage = !opts.StateStorage
+	require.ErrorContains(t, tree.SetTreeOptions(opts), "state storage cannot be changed")
+	opts = tree.cfg.ToTreeOptions()
+	opts.CheckpointInterval = -1
+	require.Error(t, tree.SetTreeOptions(opts))
+
+	require.NoError(t, tree.Set([]byte("pending"), []byte("value")))
+	opts.CheckpointInterval = 2
+	require.ErrorContains(t, tree.SetTreeOptions(opts), "uncommitted changes")
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+
+	opts.EvictionDepth = 8
+	opts.MinimumKeepVersions = 2
+	require.NoError(t, tree.SetTreeOptions(opts))
+	require.Equal(t, uint64(4), tree.Version())
+	require.Equal(t, int8(8), tree.cfg.EvictionDepth)
+	val, err := tree.Get(4, []byte("pending"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val)
+
+	// the initial version and the versions 6 and 8 are checkpointed
+	for v := 5; v <= 8; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	checkpoints, err := queryInt64(filepath.Join(path, rootDbName), "SELECT COUNT(*) FROM root WHERE checkpoint = true")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), checkpoints)
+}
+
+func TestOrphans(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	// no background pruning
+	cfg.PruneRatio = 0
+	path := t.TempDir()
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 10; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", v))))
+		}
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	// no version is pruned, so every node is reachable
+	count, size, err := tree.OrphanStats()
+	require.NoError(t, err)
+	require.Zero(t, count)
+	require.Zero(t, size)
+
+	// a pruning interrupted once the versions before 6 are flagged as pruned
+	// leaves their orphans behind
+	require.NoError(t, execSqlite(filepath.Join(path, rootDbName), []string{
+		"UPDATE root SET pruned = true WHERE version < 6",
+	}))
+	count, size, err = tree.OrphanStats()
+	require.NoError(t, err)
+	require.NotZero(t, count)
+	require.NotZero(t, size)
+
+	reclaimed, reclaimedSize, err := tree.ReclaimOrphans()
+	require.NoError(t, err)
+	require.Equal(t, count, reclaimed)
+	require.Equal(t, size, reclaimedSize)
+	count, _, err = tree.OrphanStats()
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// the retained versions are still readable once reloaded; the version before
+	// the loaded one is skipped, as IAVL v2 has no root in memory for it then
+	require.NoError(t, tree.Close())
+	tree, err = NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	require.NoError(t, tree.LoadVersion(10))
+	for _, v := range []uint64{6, 7, 8, 10} {
+		val, err := tree.Get(v, []byte("key-3"))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", v)), val)
+	}
+	require.NoError(t, tree.Set([]byte("key-0"), []byte("value-11")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+}
+
+func TestLoadCorruptVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 5
+	path := t.TempDir()
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	for v := 1; v <= 7; v++ {
+		for i := 0; i < 20; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d-%d", v, i)), []byte("value")))
+		}
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, tree.Close())
+
+	// the nodes are deleted while the versions are still saved in the root database
+	shards, err := shardVersions(path)
+	require.NoError(t, err)
+	for _, stmt := range []string{"DELETE FROM tree", "DELETE FROM leaf"} {
+		for _, shard := range shards {
+			require.NoError(t, execSqlite(shardPath(path, shard)+shardSuffix, []string{stmt}))
+		}
+
+		tree, err = NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		// the checkpoint and the version replayed from it
+		for _, v := range []uint64{4, 7} {
+			require.ErrorIs(t, tree.LoadVersion(v), ErrCorruptVersion, stmt)
+		}
+		require.NoError(t, tree.Close())
+	}
+
+	tree, err = NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	err = tree.LoadVersion(8)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrCorruptVersion)
+}
+
+func TestSchemaMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		db   string
+		stmt string
+	}{
+		{"", ""},
+		{"root", "ALTER TABLE root ADD COLUMN hash blob"},
+		{"shard", "ALTER TABLE leaf ADD COLUMN key blob"},
+		{"shard", "DROP TABLE leaf_orphan"},
+	} {
+		path := t.TempDir()
+		tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+		require.NoError(t, tree.Close())
+
+		switch tc.db {
+		case "root":
+			require.NoError(t, execSqlite(filepath.Join(path, rootDbName), []string{tc.stmt}))
+		case "shard":
+			shards, err := shardVersions(path)
+			require.NoError(t, err)
+			require.NoError(t, execSqlite(shardPath(path, shards[0])+shardSuffix, []string{tc.stmt}))
+		}
+		tree, err = NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+		if tc.stmt == "" {
+			// the schema written by IAVL v2 is the expected one
+			require.NoError(t, err)
+			require.NoError(t, tree.Close())
+			continue
+		}
+		require.ErrorIs(t, err, ErrSchemaMismatch, tc.stmt)
+	}
+}
+func TestMigrate(t *testing.T) {
+	src := iavltree.NewIavlTree(dbm.NewMemDB(), coretesting.NewNopLogger(), iavltree.DefaultConfig())
+	hashes := make(map[uint64][]byte)
+	for v := 1; v <= 5; v++ {
+		// the keys are not all updated at every version, so that the nodes have
+		// different versions
+		for i := v; i < 20; i += v {
+			require.NoError(t, src.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		require.NoError(t, src.Remove([]byte(fmt.Sprintf("key-%d", v))))
+		hash, version, err := src.Commit()
+		require.NoError(t, err)
+		hashes[version] = hash
+	}
+
+	// the shard written by an interrupted migration is left behind
+	path := t.TempDir()
+	conn, err := createShard(path, 3)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	dst, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, Migrate(src, dst, 3))
+	require.Equal(t, uint64(3), dst.Version())
+	// the nodes keep their versions, so that the root hash is the one of src
+	require.Equal(t, hashes[3], dst.Hash())
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		expected, err := src.Get(3, key)
+		require.NoError(t, err)
+		val, err := dst.Get(3, key)
+		require.NoError(t, err)
+		require.Equal(t, expected, val)
+	}
+
+	// migrating again is a no-op
+	hash := dst.Hash()
+	require.NoError(t, Migrate(src, dst, 3))
+	require.Equal(t, hash, dst.Hash())
+
+	require.Error(t, Migrate(src, dst, 4))
+
+	// the migrated tree is written on like src
+	require.NoError(t, src.LoadVersionForOverwriting(3))
+	for _, tree := range []commitment.Tree{src, dst} {
+		require.NoError(t, tree.Set([]byte("key-0"), []byte("updated")))
+		require.NoError(t, tree.Remove([]byte("key-1")))
+	}
+	srcHash, _, err := src.Commit()
+	require.NoError(t, err)
+	dstHash, _, err := dst.Commit()
+	require.NoError(t, err)
+	require.Equal(t, srcHash, dstHash)
+}
+
+func TestVerify(t *testing.T) {
+	path := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.Verify(0))
+	for v := 1; v <= 3; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d-%d", v, i)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	for v := uint64(1); v <= 3; v++ {
+		require.NoError(t, tree.Verify(v))
+	}
+	require.Error(t, tree.Verify(4))
+
+	// corrupt the value of a leaf written at version 1
+	shards, err := shardVersions(path)
+	require.NoError(t, err)
+	require.NoError(t, execSqlite(shardPath(path, shards[0])+shardSuffix, []string{
+		"UPDATE leaf SET bytes = substr(bytes, 1, length(bytes) - 1) || X'00' WHERE version = ? AND sequence = (SELECT MIN(sequence) FROM leaf WHERE version = ?)",
+	}, 1, 1))
+	err = tree.Verify(2)
+	require.ErrorContains(t, err, "hash mismatch")
+	require.ErrorContains(t, err, "version=1 height=0")
+}
+
+func TestIteratorWithOptions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, tree.Set([]byte(key), []byte(key)))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	testCases := []struct {
+		ascending bool
+		inclusive bool
+		expected  []string
+	}{
+		{ascending: true, inclusive: false, expected: []string{"b", "c"}},
+		{ascending: true, inclusive: true, expected: []string{"b", "c", "d"}},
+		{ascending: false, inclusive: false, expected: []string{"c", "b"}},
+		{ascending: false, inclusive: true, expected: []string{"d", "c", "b"}},
+	}
+	// version 5 is the latest version, version 4 is read from a clone
+	for _, version := range []uint64{4, 5} {
+		for _, tc := range testCases {
+			itr, err := tree.IteratorWithOptions(version, []byte("b"), []byte("d"), tc.ascending, tc.inclusive)
+			require.NoError(t, err)
+			var keys []string
+			for ; itr.Valid(); itr.Next() {
+				keys = append(keys, string(itr.Key()))
+			}
+			require.NoError(t, itr.Close())
+			require.Equal(t, tc.expected, keys, "version=%d ascending=%t inclusive=%t", version, tc.ascending, tc.inclusive)
+		}
+	}
+}
+
+func TestBufferedIterator(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 3
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 5; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%02d", (i*7+v)%30)), []byte(fmt.Sprintf("value-%d", v))))
+		}
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	collect := func(itr corestore.Iterator) []corestore.KVPair {
+		var pairs []corestore.KVPair
+		for ; itr.Valid(); itr.Next() {
+			pairs = append(pairs, corestore.KVPair{Key: itr.Key(), Value: itr.Value()})
+		}
+		require.NoError(t, itr.Error())
+		require.NoError(t, itr.Close())
+		return pairs
+	}
+	domains := [][2][]byte{{nil, nil}, {[]byte("key-05"), []byte("key-20")}, {[]byte("key-99"), nil}}
+	for _, version := range []uint64{2, 4, 5} {
+		for _, domain := range domains {
+			for _, ascending := range []bool{true, false} {
+				itr, err := tree.Iterator(version, domain[0], domain[1], ascending)
+				require.NoError(t, err)
+				expected := collect(itr)
+				// the buffer boundaries fall before, on and after the last pair
+				for _, bufSize := range []int{1, 3, len(expected) - 1, len(expected), len(expected) + 1} {
+					if bufSize <= 0 {
+						continue
+					}
+					itr, err := tree.BufferedIterator(version, domain[0], domain[1], ascending, bufSize)
+					require.NoError(t, err)
+					start, end := itr.Domain()
+					require.Equal(t, domain[0], start)
+					require.Equal(t, domain[1], end)
+					require.Equal(t, expected, collect(itr), "version=%d domain=%q ascending=%t size=%d", version, domain, ascending, bufSize)
+				}
+			}
+		}
+	}
+
+	// version 0 is the latest version
+	itr, err := tree.Iterator(5, nil, nil, true)
+	require.NoError(t, err)
+	expected := collect(itr)
+	itr, err = tree.BufferedIterator(0, nil, nil, true, 4)
+	require.NoError(t, err)
+	require.Equal(t, expected, collect(itr))
+
+	// closing the iterator before its end stops the prefetching
+	itr, err = tree.BufferedIterator(0, nil, nil, true, 2)
+	require.NoError(t, err)
+	itr.Next()
+	require.NoError(t, itr.Close())
+	require.False(t, itr.Valid())
+	require.Panics(t, func() { itr.Next() })
+	require.NoError(t, itr.Close())
+
+	_, err = tree.BufferedIterator(0, nil, nil, true, 0)
+	require.Error(t, err)
+	_, err = tree.BufferedIterator(6, nil, nil, true, 1)
+	require.ErrorIs(t, err, ErrFutureVersion)
+}
+
+func BenchmarkBufferedIterator(b *testing.B) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 1
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: b.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(b, err)
+	defer tree.Close()
+	for i := 0; i < 100_000; i++ {
+		require.NoError(b, tree.Set([]byte(fmt.Sprintf("key-%08d", i)), bytes.Repeat([]byte{byte(i)}, 32)))
+	}
+	_, v, err := tree.Commit()
+	require.NoError(b, err)
+	// the scanned checkpoint is read from SQLite by both iterators
+	for i := 0; i < 2; i++ {
+		_, _, err = tree.Commit()
+		require.NoError(b, err)
+	}
+
+	scan := func(b *testing.B, newIterator func() (corestore.Iterator, error)) {
+		b.Helper()
+		for i := 0; i < b.N; i++ {
+			itr, err := newIterator()
+			require.NoError(b, err)
+			for ; itr.Valid(); itr.Next() {
+				// a consumer decoding the values
+				_ = bytes.Count(itr.Value(), []byte{0})
+			}
+			require.NoError(b, itr.Close())
+		}
+	}
+	b.Run("unbuffered", func(b *testing.B) {
+		scan(b, func() (corestore.Iterator, error) { return tree.Iterator(v, nil, nil, true) })
+	})
+	for _, bufSize := range []int{64, 1024} {
+		b.Run(fmt.Sprintf("buffer=%d", bufSize), func(b *testing.B) {
+			scan(b, func() (corestore.Iterator, error) { return tree.BufferedIterator(v, nil, nil, true, bufSize) })
+		})
+	}
+}
+
+func TestReverseIteratorHistoricalVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 3
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 8; v++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%02d", (i*7+v)%50)), []byte(fmt.Sprintf("value-%d", v))))
+		}
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	for version := uint64(1); version < tree.Version()-1; version++ {
+		itr, err := tree.Iterator(version, []byte("key-05"), []byte("key-40"), false)
+		require.NoError(t, err)
+		var prev []byte
+		count := 0
+		for ; itr.Valid(); itr.Next() {
+			if prev != nil {
+				require.Negative(t, bytes.Compare(itr.Key(), prev), "version=%d", version)
+			}
+			require.GreaterOrEqual(t, string(itr.Key()), "key-05")
+			require.Less(t, string(itr.Key()), "key-40")
+			prev = itr.Key()
+			count++
+		}
+		require.NoError(t, itr.Error())
+		require.NoError(t, itr.Close())
+		require.Positive(t, count, "version=%d", version)
+	}
+}
+
+func TestClonePool(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	cfg.ClonePoolSize = 2
+	path := t.TempDir()
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 10; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	// concurrent reads of the same versions share the pooled clones
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 30; i++ {
+		v := uint64(2 + 2*(i%3))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := tree.Get(v, []byte("key"))
+			if err == nil && string(val) != fmt.Sprintf("value-%d", v) {
+				err = fmt.Errorf("version %d: unexpected value %q", v, val)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, tree.clones.len())
+
+	// the least recently used clone is evicted, leaving versions 2 and 6
+	for _, v := range []uint64{6, 2} {
+		_, err = tree.Get(v, []byte("key"))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, tree.clones.len())
+
+	// the clones of the pruned versions are evicted
+	require.NoError(t, tree.Prune(4))
+	require.Equal(t, 1, tree.clones.len())
+	val, err := tree.Get(6, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-6"), val)
+	require.Equal(t, 1, tree.clones.len())
+
+	// the clones of the versions older than the earliest version are evicted on
+	// commit, whatever pruned them
+	_, err = tree.Get(8, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, 2, tree.clones.len())
+	require.NoError(t, execSqlite(filepath.Join(path, rootDbName), []string{"UPDATE root SET pruned = true WHERE version < 8"}))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, 1, tree.clones.len())
+	require.Contains(t, tree.clones.versions, int64(8))
+}
+
+func TestPruneWithProgress(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 10; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	// versions 1 to 5 are deleted up to checkpoint 6, from which the next versions
+	// are loaded
+	var progress [][2]uint64
+	require.NoError(t, tree.PruneWithProgress(5, func(done, total uint64) {
+		progress = append(progress, [2]uint64{done, total})
+	}))
+	require.Equal(t, [][2]uint64{{5, 5}}, progress)
+	earliest, err := tree.EarliestVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), earliest)
+	for v := uint64(1); v <= 5; v++ {
+		_, err = tree.Get(v, []byte("key-1"))
+		require.ErrorIs(t, err, ErrVersionPruned)
+	}
+	for v := uint64(6); v <= 10; v++ {
+		val, err := tree.Get(v, []byte("key-1"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value-1"), val)
+	}
+	count, _, err := tree.OrphanStats()
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// nothing is left to delete below the earliest version
+	progress = nil
+	require.NoError(t, tree.PruneWithProgress(0, func(done, total uint64) {
+		progress = append(progress, [2]uint64{done, total})
+	}))
+	require.Empty(t, progress)
+
+	// version 7 is loaded from checkpoint 6, so nothing is deleted until version 8
+	require.NoError(t, tree.Prune(6))
+	earliest, err = tree.EarliestVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), earliest)
+
+	// the latest version is kept
+	require.NoError(t, tree.Prune(10))
+	earliest, err = tree.EarliestVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), earliest)
+	_, err = tree.Get(8, []byte("key-1"))
+	require.ErrorIs(t, err, ErrVersionPruned)
+	val, err := tree.Get(10, []byte("key-10"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-10"), val)
+
+	// the tree is loaded from the earliest version once reopened
+	require.NoError(t, tree.Set([]byte("key-11"), []byte("value-11")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.NoError(t, tree.LoadVersion(11))
+	val, err = tree.Get(11, []byte("key-1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-1"), val)
+}
+
+func TestPruneAsync(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 10; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	cancel, errCh := tree.PruneAsync(5)
+	require.NoError(t, <-errCh)
+	cancel()
+	_, err = tree.Get(5, []byte("key-1"))
+	require.ErrorIs(t, err, ErrVersionPruned)
+	val, err := tree.Get(6, []byte("key-1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-1"), val)
+
+	// a cancelled pruning returns the cancellation and leaves the tree usable,
+	// with the versions deleted up to checkpoint 6 or 8
+	cancel, errCh = tree.PruneAsync(8)
+	cancel()
+	earliest := uint64(8)
+	if err = <-errCh; err != nil {
+		require.ErrorIs(t, err, context.Canceled)
+		earliest = 6
+	}
+	_, ok := <-errCh
+	require.False(t, ok)
+	v, err := tree.EarliestVersion()
+	require.NoError(t, err)
+	require.Equal(t, earliest, v)
+	_, err = tree.Get(earliest-1, []byte("key-1"))
+	require.ErrorIs(t, err, ErrVersionPruned)
+	val, err = tree.Get(earliest, []byte("key-1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-1"), val)
+
+	require.NoError(t, tree.Set([]byte("key-11"), []byte("value-11")))
+	_, v, err = tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(11), v)
+	val, err = tree.Get(10, []byte("key-10"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-10"), val)
+}
+
+func TestCancelledContext(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 10; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, tree.PruneCtx(ctx, 5), context.Canceled)
+	_, err = tree.Get(1, []byte("key-1"))
+	require.NoError(t, err)
+	require.ErrorIs(t, tree.VerifyCtx(ctx, 10), context.Canceled)
+	exporter, err := tree.ExportCtx(ctx, 10)
+	require.NoError(t, err)
+	_, err = exporter.Next()
+	require.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, exporter.Close())
+
+	// an export cancelled midway is closed once the nodes left are drained
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	exporter, err = tree.ExportCtx(ctx2, 9)
+	require.NoError(t, err)
+	_, err = exporter.Next()
+	require.NoError(t, err)
+	cancel2()
+	_, err = exporter.Next()
+	require.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, exporter.Close())
+
+	// the tree is left usable
+	require.NoError(t, tree.Verify(10))
+	require.NoError(t, tree.PruneCtx(context.Background(), 5))
+	_, err = tree.Get(5, []byte("key-1"))
+	require.ErrorIs(t, err, ErrVersionPruned)
+	val, err := tree.Get(10, []byte("key-10"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-10"), val)
+}
+
+func TestPruningOptions(t *testing.T) {
+	for _, tc := range []struct {
+		opts    PruningOptions
+		version uint64
+		pruneTo uint64
+		ok      bool
+	}{
+		{PruningOptions{}, 10, 0, false},
+		{PruningOptions{KeepRecent: 2}, 3, 0, false},
+		{PruningOptions{KeepRecent: 2}, 4, 1, true},
+		{PruningOptions{KeepRecent: 2}, 10, 7, true},
+		{PruningOptions{KeepRecent: 2, KeepEvery: 5}, 7, 0, false},
+		{PruningOptions{KeepRecent: 2, KeepEvery: 5}, 8, 4, true},
+		{PruningOptions{KeepRecent: 2, KeepEvery: 5}, 12, 4, true},
+		{PruningOptions{KeepRecent: 2, KeepEvery: 5}, 13, 9, true},
+	} {
+		pruneTo, ok := tc.opts.PruneTo(tc.version)
+		require.Equal(t, tc.ok, ok, "%+v at %d", tc.opts, tc.version)
+		require.Equal(t, tc.pruneTo, pruneTo, "%+v at %d", tc.opts, tc.version)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Pruning = PruningOptions{KeepEvery: 5}
+	_, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.ErrorContains(t, err, "requires keep recent")
+
+	// the versions are deleted on commit, the retained ones staying readable. The
+	// tree is checkpointed at every version, as the versions are deleted up to a
+	// checkpoint only.
+	cfg.CheckpointInterval = 1
+	for _, tc := range []struct {
+		opts     PruningOptions
+		earliest uint64
+	}{
+		{PruningOptions{KeepRecent: 2}, 10},
+		{PruningOptions{KeepRecent: 2, KeepEvery: 5}, 5},
+	} {
+		cfg.Pruning = tc.opts
+		tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		defer tree.Close()
+		for v := 1; v <= 12; v++ {
+			require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+			_, _, err = tree.Commit()
+			require.NoError(t, err)
+		}
+		earliest, err := tree.EarliestVersion()
+		require.NoError(t, err)
+		require.Equal(t, tc.earliest, earliest, "%+v", tc.opts)
+		for v := uint64(1); v <= 12; v++ {
+			val, err := tree.Get(v, []byte("key"))
+			if v < tc.earliest {
+				require.ErrorIs(t, err, ErrVersionPruned, "%+v at %d", tc.opts, v)
+				continue
+			}
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("value-%d", v)), val)
+		}
+	}
+}
+
+func TestDeleteVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 10
+	path := t.TempDir()
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 6; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	// the pooled clone of the deleted version is evicted
+	val, err := tree.Get(3, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-3"), val)
+	require.NoError(t, tree.DeleteVersion(3))
+	_, err = tree.Get(3, []byte("key"))
+	require.Error(t, err)
+	for _, v := range []uint64{2, 4} {
+		val, err := tree.Get(v, []byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", v)), val)
+	}
+
+	require.ErrorIs(t, tree.DeleteVersion(3), ErrVersionNotFound)
+	require.Error(t, tree.DeleteVersion(6), "latest version")
+	require.Error(t, tree.DeleteVersion(1), "checkpoint")
+
+	// the versions after the deleted one are still loaded from the checkpoint
+	require.NoError(t, tree.Close())
+	tree, err = NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	require.NoError(t, tree.LoadVersion(6))
+	val, err = tree.Get(4, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-4"), val)
+
+	// the changes of a deleted version are deleted once no version replays them,
+	// i.e. the versions after it up to the checkpoint 10 are deleted, except the
+	// leaves still in the tree at the checkpoint
+	leaves := func(from, to int) int64 {
+		n, err := queryInt64(shardPath(path, 1)+shardSuffix, "SELECT COUNT(*) FROM leaf WHERE version >= ? AND version <= ?", from, to)
+		require.NoError(t, err)
+		return n
+	}
+	for v := 7; v <= 11; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(6), leaves(7, 9))
+	require.NoError(t, tree.DeleteVersion(7))
+	require.Equal(t, int64(6), leaves(7, 9))
+	require.NoError(t, tree.DeleteVersion(9))
+	require.Equal(t, int64(5), leaves(7, 9))
+	require.NoError(t, tree.DeleteVersion(8))
+	require.Equal(t, int64(3), leaves(7, 9))
+	orphans, err := queryInt64(shardPath(path, 1)+shardSuffix, "SELECT COUNT(*) FROM leaf_orphan WHERE version >= 7 AND version <= 9")
+	require.NoError(t, err)
+	require.Zero(t, orphans)
+
+	require.NoError(t, tree.Close())
+	tree, err = NewTree(cfg, iavl.SqliteDbOptions{Path: path}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	require.NoError(t, tree.LoadVersion(11))
+	// version 11 is loaded from the checkpoint 10
+	for _, v := range []uint64{6, 11} {
+		val, err := tree.Get(v, []byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", v)), val)
+		val, err = tree.Get(v, []byte("key-7"))
+		require.NoError(t, err)
+		if v == 6 {
+			require.Nil(t, val)
+		} else {
+			require.Equal(t, []byte("value"), val)
+		}
+	}
+	for _, v := range []uint64{7, 8, 9} {
+		_, err = tree.Get(v, []byte("key"))
+		require.Error(t, err)
+	}
+}
+
+func TestHasVersions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	cfg.MinimumKeepVersions = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	has, err := tree.HasVersions([]uint64{0, 1})
+	require.NoError(t, err)
+	require.Equal(t, map[uint64]bool{0: false, 1: false}, has)
+
+	for v := 1; v <= 20; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	// the versions are pruned in the background
+	var earliest uint64
+	require.Eventually(t, func() bool {
+		earliest, err = tree.EarliestVersion()
+		require.NoError(t, err)
+		return earliest > 1
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, tree.DeleteVersion(17))
+
+	has, err = tree.HasVersions([]uint64{1, earliest, 17, 18, 20, 21})
+	require.NoError(t, err)
+	require.Equal(t, map[uint64]bool{1: false, earliest: true, 17: false, 18: true, 20: true, 21: false}, has)
+	for v, ok := range has {
+		_, err := tree.Get(v, []byte("key"))
+		require.Equal(t, ok, err == nil, "version %d", v)
+	}
+
+	require.NoError(t, tree.Close())
+	_, err = tree.HasVersions([]uint64{1})
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestSnapshotVersions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	cfg.MinimumKeepVersions = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	versions, err := tree.SnapshotVersions(5)
+	require.NoError(t, err)
+	require.Empty(t, versions)
+
+	for v := 1; v <= 20; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	// the versions are pruned in the background
+	require.Eventually(t, func() bool {
+		earliest, err := tree.EarliestVersion()
+		require.NoError(t, err)
+		return earliest > 5
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, tree.DeleteVersion(15))
+
+	earliest, err := tree.EarliestVersion()
+	require.NoError(t, err)
+	var expected []uint64
+	for _, v := range []uint64{10, 20} {
+		if v >= earliest {
+			expected = append(expected, v)
+		}
+	}
+	versions, err = tree.SnapshotVersions(5)
+	require.NoError(t, err)
+	require.Equal(t, expected, versions)
+	for _, v := range versions {
+		exporter, err := tree.Export(v)
+		require.NoError(t, err)
+		require.NoError(t, exporter.Close())
+	}
+
+	_, err = tree.SnapshotVersions(0)
+	require.Error(t, err)
+	require.NoError(t, tree.Close())
+	_, err = tree.SnapshotVersions(5)
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestEarliestVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	cfg.MinimumKeepVersions = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	earliest, err := tree.EarliestVersion()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), earliest)
+
+	for v := 1; v <= 20; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	// the versions are pruned in the background
+	require.Eventually(t, func() bool {
+		earliest, err = tree.EarliestVersion()
+		require.NoError(t, err)
+		return earliest > 1
+	}, 10*time.Second, 10*time.Millisecond)
+
+	_, err = tree.Get(1, []byte("key"))
+	require.ErrorIs(t, err, ErrVersionPruned)
+	_, err = tree.Has(1, []byte("key"))
+	require.ErrorIs(t, err, ErrVersionPruned)
+	_, err = tree.GetProof(1, []byte("key"))
+	require.ErrorIs(t, err, ErrVersionPruned)
+
+	val, err := tree.Get(20, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-20"), val)
+}
+
+func TestVersionErrors(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 3; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	_, err = tree.Get(4, []byte("key"))
+	require.ErrorIs(t, err, ErrFutureVersion)
+	require.False(t, errors.Is(err, ErrVersionPruned))
+	_, err = tree.Has(4, []byte("key"))
+	require.ErrorIs(t, err, ErrFutureVersion)
+	_, err = tree.Iterator(4, nil, nil, true)
+	require.ErrorIs(t, err, ErrFutureVersion)
+
+	_, err = tree.Get(3, []byte("key"))
+	require.NoError(t, err)
+}
+
+func TestHealthCheck(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	require.NoError(t, tree.HealthCheck())
+
+	for v := 1; v <= 3; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte("value")))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	require.NoError(t, tree.HealthCheck())
+
+	// a read-only tree may lag behind
+	reader, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: dir, Readonly: true}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, reader.LoadVersion(2))
+	require.NoError(t, reader.HealthCheck())
+	require.NoError(t, reader.Close())
+	require.ErrorIs(t, reader.HealthCheck(), ErrClosed)
+
+	require.NoError(t, tree.LoadVersion(2))
+	require.ErrorContains(t, tree.HealthCheck(), "latest saved version is 3")
+}
+
+func TestDoubleClose(t *testing.T) {
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+
+	require.NoError(t, tree.Close())
+	require.NoError(t, tree.Close())
+
+	_, err = tree.Get(1, []byte("key"))
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = tree.Has(1, []byte("key"))
+	require.ErrorIs(t, err, ErrClosed)
+	_, err = tree.Iterator(1, nil, nil, true)
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorIs(t, tree.Set([]byte("key"), []byte("value")), ErrClosed)
+	_, _, err = tree.Commit()
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 4; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	readOnly, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir, Readonly: true}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer readOnly.Close()
+	require.NoError(t, readOnly.LoadVersion(4))
+	require.Equal(t, tree.Hash(), readOnly.Hash())
+	val, err := readOnly.Get(2, []byte("key-2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-2"), val)
+
+	require.ErrorIs(t, readOnly.Set([]byte("key"), []byte("value")), ErrReadOnly)
+	require.ErrorIs(t, readOnly.Remove([]byte("key-1")), ErrReadOnly)
+	_, _, err = readOnly.Commit()
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, readOnly.Prune(2), ErrReadOnly)
+	require.ErrorIs(t, readOnly.LoadVersionForOverwriting(2), ErrReadOnly)
+
+	// the versions committed by the writer are read once loaded
+	require.NoError(t, tree.Set([]byte("key-5"), []byte("value-5")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.NoError(t, readOnly.LoadVersion(5))
+	require.Equal(t, tree.Hash(), readOnly.Hash())
+	val, err = readOnly.Get(5, []byte("key-5"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-5"), val)
+}
+
+func TestReadOnlyTreeAtVersion(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for v := 1; v <= 4; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	pinned, err := NewReadOnlyTreeAtVersion(cfg, iavl.SqliteDbOptions{Path: dir}, 3, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer pinned.Close()
+	require.Equal(t, uint64(3), pinned.Version())
+	for _, v := range []uint64{0, 3} {
+		val, err := pinned.Get(v, []byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value-3"), val)
+	}
+	val, err := pinned.Get(1, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-1"), val)
+	_, err = pinned.Get(4, []byte("key"))
+	require.ErrorIs(t, err, ErrFutureVersion)
+
+	require.ErrorIs(t, pinned.Set([]byte("key"), []byte("value")), ErrReadOnly)
+	_, _, err = pinned.Commit()
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.Error(t, pinned.LoadVersion(4))
+	require.NoError(t, pinned.LoadVersion(3))
+
+	_, err = NewReadOnlyTreeAtVersion(cfg, iavl.SqliteDbOptions{Path: dir}, 5, coretesting.NewNopLogger())
+	require.ErrorIs(t, err, ErrFutureVersion)
+}
+
+func TestBackupRestore(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CheckpointInterval = 2
+	source, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer source.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, source.BackupTo(&buf, 0))
+	empty, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, empty.RestoreFrom(&buf))
+	require.Equal(t, source.Hash(), empty.Hash())
+	require.NoError(t, empty.Close())
+
+	// version v sets 10 keys and removes the first key of version v-1
+	write := func(tree *Tree, v int) []byte {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d-%d", v, i)), []byte(fmt.Sprintf("value-%d-%d", v, i))))
+		}
+		if v > 1 {
+			require.NoError(t, tree.Remove([]byte(fmt.Sprintf("key-%d-0", v-1))))
+		}
+		hash, version, err := tree.Commit()
+		require.NoError(t, err)
+		require.Equal(t, uint64(v), version)
+		return hash
+	}
+	hashes := make(map[uint64][]byte)
+	for v := 1; v <= 5; v++ {
+		hashes[uint64(v)] = write(source, v)
+	}
+
+	for _, version := range []uint64{3, 5} {
+		buf.Reset()
+		require.NoError(t, source.BackupTo(&buf, version))
+		backup := bytes.Clone(buf.Bytes())
+
+		target, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		require.NoError(t, target.RestoreFrom(&buf))
+		require.Equal(t, version, target.Version())
+		require.Equal(t, hashes[version], target.Hash())
+
+		// the restored keys are read back
+		for v := 1; v <= int(version); v++ {
+			for i := 0; i < 10; i++ {
+				val, err := target.Get(version, []byte(fmt.Sprintf("key-%d-%d", v, i)))
+				require.NoError(t, err)
+				if i == 0 && v < int(version) {
+					require.Nil(t, val)
+				} else {
+					require.Equal(t, []byte(fmt.Sprintf("value-%d-%d", v, i)), val)
+				}
+			}
+		}
+
+		// the restored tree commits the next version as the tree it was backed up
+		// from
+		reference, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		for v := 1; v <= int(version); v++ {
+			write(reference, v)
+		}
+		require.Equal(t, write(reference, int(version)+1), write(target, int(version)+1))
+		val, err := target.Get(version+1, []byte(fmt.Sprintf("key-%d-1", version)))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d-1", version)), val)
+		require.NoError(t, reference.Close())
+
+		// a tree can only be restored when empty
+		require.Error(t, target.RestoreFrom(bytes.NewReader(backup)))
+		require.NoError(t, target.Close())
+
+		// a truncated backup is rejected
+		target, err = NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+		require.NoError(t, err)
+		require.Error(t, target.RestoreFrom(bytes.NewReader(backup[:len(backup)/2])))
+		require.NoError(t, target.Close())
+	}
+
+	require.ErrorIs(t, source.BackupTo(&buf, 6), ErrFutureVersion)
+}
+
+func TestGetVersionZero(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ClonePoolSize = 1
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+
+	// version 0 of an empty tree holds no key
+	val, err := tree.Get(0, []byte("key"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	for v := 1; v <= 3; v++ {
+		require.NoError(t, tree.Set([]byte("key"), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+
+	// version 0 reads the latest committed version, ignoring uncommitted changes
+	require.NoError(t, tree.Set([]byte("key"), []byte("dirty")))
+	val, err = tree.Get(0, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value-3"), val)
+	has, err := tree.Has(0, []byte("key"))
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = tree.Has(0, []byte("absent"))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// the live tree is read, no clone is pooled
+	require.Equal(t, 0, tree.clones.len())
+}
+
+func TestPragmas(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.Pragmas = map[string]string{"synchronous": "FULL"}
+	_, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.Error(t, err)
+	cfg.Pragmas = map[string]string{"journal_mode": "truncate"}
+	_, err = NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.Error(t, err)
+
+	cfg.Pragmas = map[string]string{"journal_mode": "wal", "mmap_size": "1048576", "wal_autocheckpoint": "100"}
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	for v := 1; v <= 3; v++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%d", v)), []byte(fmt.Sprintf("value-%d", v))))
+		_, _, err = tree.Commit()
+		require.NoError(t, err)
+	}
+	hash := tree.Hash()
+	require.NoError(t, tree.Close())
+
+	// the journal mode of the existing databases is switched on open
+	cfg.Pragmas = map[string]string{"journal_mode": "delete"}
+	tree, err = NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer tree.Close()
+	mode, err := queryString(filepath.Join(dir, rootDbName), "PRAGMA journal_mode")
+	require.NoError(t, err)
+	require.Equal(t, "delete", mode)
+
+	require.NoError(t, tree.LoadVersion(3))
+	require.Equal(t, hash, tree.Hash())
+	require.NoError(t, tree.Set([]byte("key-4"), []byte("value-4")))
+	_, version, err := tree.Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), version)
+}
+
+// recordLogger records the key/value pairs of the info and warn log lines.
+type recordLogger struct {
+	corelog.Logger
+	lines map[string][]any
+}
+
+func (l *recordLogger) Info(msg string, keyVals ...any) {
+	l.lines[msg] = keyVals
+}
+
+func (l *recordLogger) Warn(msg string, keyVals ...any) {
+	l.lines[msg] = keyVals
+}
+
+func TestSlowCommit(t *testing.T) {
+	for _, tc := range []struct {
+		threshold time.Duration
+		logged    bool
+	}{
+		{threshold: 0, logged: false},
+		{threshold: time.Nanosecond, logged: true},
+		{threshold: time.Hour, logged: false},
+	} {
+		t.Run(fmt.Sprintf("threshold=%v", tc.threshold), func(t *testing.T) {
+			dir := t.TempDir()
+			logger := &recordLogger{Logger: coretesting.NewNopLogger(), lines: make(map[string][]any)}
+			cfg := DefaultConfig()
+			cfg.SlowCommitThreshold = tc.threshold
+			tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, logger)
+			require.NoError(t, err)
+			defer tree.Close()
+
+			require.NoError(t, tree.Set([]byte("a"), []byte("value")))
+			require.NoError(t, tree.Set([]byte("b"), []byte("value")))
+			require.NoError(t, tree.Remove([]byte("a")))
+			_, _, err = tree.Commit()
+			require.NoError(t, err)
+			if !tc.logged {
+				require.NotContains(t, logger.lines, "slow commit")
+				return
+			}
+			line := logger.lines["slow commit"]
+			require.Equal(t, []any{"path", dir, "version", int64(1)}, line[:4])
+			require.Equal(t, []any{"writes", 3, "threshold", tc.threshold}, line[6:])
+		})
+	}
+
+	cfg := DefaultConfig()
+	cfg.SlowCommitThreshold = -time.Second
+	_, err := NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.Error(t, err)
+}
+
+func TestLoggerPath(t *testing.T) {
+	dir := t.TempDir()
+	logger := &recordLogger{Logger: coretesting.NewNopLogger(), lines: make(map[string][]any)}
+	tree, err := NewTree(DefaultConfig(), iavl.SqliteDbOptions{Path: dir}, logger)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.Set([]byte("key"), []byte("value")))
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.NoError(t, tree.Compact())
+	require.Contains(t, logger.lines, "compacted tree")
+	require.Equal(t, []any{"path", dir}, logger.lines["compacted tree"][:2])
+}
+
+func TestPreload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.PreloadDepth = 3
+	logger := &recordLogger{Logger: coretesting.NewNopLogger(), lines: make(map[string][]any)}
+	tree, err := NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, logger)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	// an empty tree has nothing to preload
+	require.NoError(t, tree.LoadVersion(0))
+	require.NotContains(t, logger.lines, "preloaded tree")
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, tree.Set([]byte(fmt.Sprintf("key-%03d", i)), []byte("value")))
+	}
+	_, _, err = tree.Commit()
+	require.NoError(t, err)
+	require.NoError(t, tree.Close())
+
+	tree, err = NewTree(cfg, iavl.SqliteDbOptions{Path: dir}, logger)
+	require.NoError(t, err)
+	defer tree.Close()
+	require.NoError(t, tree.LoadVersion(1))
+	require.Contains(t, logger.lines, "preloaded tree")
+	require.Equal(t, []any{"path", dir, "version", int64(1), "depth", int8(3), "leaves", int64(8)}, logger.lines["preloaded tree"][:8])
+	val, err := tree.Get(1, []byte("key-042"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val)
+
+	cfg.PreloadDepth = maxPreloadDepth + 1
+	_, err = NewTree(cfg, iavl.SqliteDbOptions{Path: t.TempDir()}, coretesting.NewNopLogger())
+	require.Error(t, err)
+}
+
+func TestMultiTree(t *testing.T) {
+	dir := t.TempDir()
+	storeKeys := []string{"bank", "acc"}
+	mt, err := NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.NoError(t, err)
+
+	var hash []byte
+	for v := uint64(1); v <= 3; v++ {
+		for _, storeKey := range storeKeys {
+			require.NoError(t, mt.Tree(storeKey).Set([]byte(storeKey), []byte(fmt.Sprintf("value-%d", v))))
+		}
+		hash, err = mt.CommitAll(v)
+		require.NoError(t, err)
+		require.NotEmpty(t, hash)
+	}
+	_, err = mt.CommitAll(5)
+	require.Error(t, err)
+	require.Equal(t, uint64(3), mt.Version())
+
+	// crash in between the commits of the trees
+	require.NoError(t, mt.Tree("acc").Set([]byte("acc"), []byte("value-4")))
+	_, version, err := mt.Tree("acc").Commit()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), version)
+	require.NoError(t, mt.Close())
+
+	mt, err = NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), mt.Version())
+	for _, storeKey := range storeKeys {
+		require.Equal(t, uint64(3), mt.Tree(storeKey).Version())
+		val, err := mt.Tree(storeKey).Get(3, []byte(storeKey))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value-3"), val)
+	}
+	m, found, err := readManifest(dir)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, manifest{Version: 3, Hash: hash}, m)
+
+	require.NoError(t, mt.Tree("acc").Set([]byte("acc"), []byte("value-4")))
+	hash, err = mt.CommitAll(4)
+	require.NoError(t, err)
+	require.NoError(t, mt.Close())
+
+	// the common version of the trees is adopted without a manifest
+	manifestPath := filepath.Join(dir, manifestName)
+	require.NoError(t, os.Remove(manifestPath))
+	mt, err = NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), mt.Version())
+	m, found, err = readManifest(dir)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, manifest{Version: 4, Hash: hash}, m)
+
+	// trees at different versions are not rolled back past the first version
+	// without a manifest
+	require.NoError(t, mt.Tree("acc").Set([]byte("acc"), []byte("value-5")))
+	_, _, err = mt.Tree("acc").Commit()
+	require.NoError(t, err)
+	require.NoError(t, mt.Close())
+	require.NoError(t, os.Remove(manifestPath))
+	_, err = NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.ErrorContains(t, err, "no manifest found")
+	for storeKey, expected := range map[string]int64{"acc": 5, "bank": 4} {
+		latest, err := latestVersion(filepath.Join(dir, storeKey))
+		require.NoError(t, err)
+		require.Equal(t, expected, latest, storeKey)
+	}
+	require.NoError(t, writeManifest(dir, manifest{Version: 4, Hash: hash}))
+	mt, err = NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), mt.Version())
+	require.NoError(t, mt.Close())
+
+	// a tree behind the manifest cannot be recovered
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "bank")))
+	_, err = NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.ErrorContains(t, err, "behind the manifest")
+
+	// a crash in the first commit is rolled back without a manifest
+	dir = t.TempDir()
+	mt, err = NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, mt.Tree("acc").Set([]byte("acc"), []byte("value-1")))
+	_, _, err = mt.Tree("acc").Commit()
+	require.NoError(t, err)
+	require.NoError(t, mt.Close())
+	mt, err = NewMultiTree(DefaultConfig(), dir, storeKeys, coretesting.NewNopLogger())
+	require.NoError(t, err)
+	defer mt.Close()
+	require.Equal(t, uint64(0), mt.Version())
+	require.Equal(t, uint64(0), mt.Tree("acc").Version())
+	_, found, err = readManifest(dir)
+	require.NoError(t, err)
+	require.False(t, found)
+	_, err = mt.CommitAll(1)
+	require.NoError(t, err)
+}
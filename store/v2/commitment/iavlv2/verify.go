@@ -0,0 +1,127 @@
+package iavlv2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cosmos/iavl/v2"
+)
+
+// verifiedNode is a subtree whose hash has been recomputed by Verify.
+type verifiedNode struct {
+	hash   []byte
+	size   int64
+	height int8
+}
+
+// Verify walks the tree at the given version, recomputes the node hashes bottom-up
+// and checks them against the stored ones and the stored root hash. It returns an
+// error describing the first node found to be corrupted.
+//
+// The tree is read from a readonly clone, so Verify can run concurrently with the
+// live tree, e.g. periodically from a background goroutine.
+func (t *Tree) Verify(version uint64) error {
+	return t.VerifyCtx(context.Background(), version)
+}
+
+// VerifyCtx is like Verify but stops with ctx.Err() once ctx is cancelled.
+func (t *Tree) VerifyCtx(ctx context.Context, version uint64) (err error) {
+	if err := isHighBitSet(version); err != nil {
+		return err
+	}
+	v := int64(version)
+	h := t.tree.Version()
+	if v > h {
+		return fmt.Errorf("verify: cannot verify future version %d; h: %d path=%s: %w", v, h, t.path, ErrFutureVersion)
+	}
+	cloned, err := t.tree.ReadonlyClone()
+	if err != nil {
+		return err
+	}
+	if err = t.loadVersion(cloned, v); err != nil {
+		return errors.Join(err, cloned.Close())
+	}
+	if isEmpty(cloned) {
+		return cloned.Close()
+	}
+	rootHash := cloned.Hash()
+	exporter, err := cloned.Export(v, iavl.PostOrder)
+	if err != nil {
+		return errors.Join(err, cloned.Close())
+	}
+	// closing the exporter closes the clone
+	defer func() {
+		err = errors.Join(err, exporter.Close())
+	}()
+
+	var stack []verifiedNode
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("verify: stopped at version %d; path=%s: %w", v, t.path, err)
+		}
+		node, err := exporter.Next()
+		if errors.Is(err, iavl.ErrorExportDone) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("verify: failed to read node; version=%d path=%s: %w", v, t.path, err)
+		}
+
+		verified := verifiedNode{height: node.Height(), size: 1}
+		if node.Height() == 0 {
+			valueHash := sha256.Sum256(node.Value())
+			verified.hash = nodeHash(0, 1, node.Version(), node.Key(), valueHash[:])
+		} else {
+			if len(stack) < 2 {
+				return fmt.Errorf("verify: inner node key=%X version=%d height=%d is missing its children; path=%s",
+					node.Key(), node.Version(), node.Height(), t.path)
+			}
+			left, right := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			if node.Height() != max(left.height, right.height)+1 {
+				return fmt.Errorf("verify: inner node key=%X version=%d has height %d, children heights are %d and %d; path=%s",
+					node.Key(), node.Version(), node.Height(), left.height, right.height, t.path)
+			}
+			verified.size = left.size + right.size
+			verified.hash = nodeHash(node.Height(), verified.size, node.Version(), left.hash, right.hash)
+		}
+		if !bytes.Equal(verified.hash, node.GetHash()) {
+			return fmt.Errorf("verify: node key=%X version=%d height=%d hash mismatch; stored %X computed %X; path=%s",
+				node.Key(), node.Version(), node.Height(), node.GetHash(), verified.hash, t.path)
+		}
+		stack = append(stack, verified)
+	}
+
+	if len(stack) != 1 {
+		return fmt.Errorf("verify: found %d unattached subtrees at version %d; path=%s", len(stack), v, t.path)
+	}
+	if !bytes.Equal(stack[0].hash, rootHash) {
+		return fmt.Errorf("verify: root hash mismatch at version %d; stored %X computed %X; path=%s",
+			v, rootHash, stack[0].hash, t.path)
+	}
+	return nil
+}
+
+// nodeHash computes the hash of a node the same way as iavl: a leaf node hashes
+// its key and value hash, an inner node the hashes of its children.
+func nodeHash(height int8, size, version int64, left, right []byte) []byte {
+	var (
+		buf bytes.Buffer
+		n   int
+		bz  [binary.MaxVarintLen64]byte
+	)
+	for _, i := range []int64{int64(height), size, version} {
+		n = binary.PutVarint(bz[:], i)
+		buf.Write(bz[:n])
+	}
+	for _, b := range [][]byte{left, right} {
+		n = binary.PutUvarint(bz[:], uint64(len(b)))
+		buf.Write(bz[:n])
+		buf.Write(b)
+	}
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-metrics"
@@ -9,6 +10,21 @@ import (
 
 var _ StoreMetrics = Metrics{}
 
+// telemetryEnabled is set by SetTelemetryEnabled.
+var telemetryEnabled atomic.Bool
+
+// SetTelemetryEnabled sets whether the stores emit their own metrics, e.g. the
+// commit metrics of the IAVL v2 trees, to the global go-metrics sink. It is meant
+// to be enabled along with the telemetry of the node, which sets up the sink.
+func SetTelemetryEnabled(enabled bool) {
+	telemetryEnabled.Store(enabled)
+}
+
+// IsTelemetryEnabled returns whether the stores emit their own metrics.
+func IsTelemetryEnabled() bool {
+	return telemetryEnabled.Load()
+}
+
 // StoreMetrics defines the set of supported metric APIs for the store package.
 type StoreMetrics interface {
 	MeasureSince(start time.Time, keys ...string)
@@ -59,6 +59,9 @@ func DefaultStoreOptions() Options {
 			CacheSize:              500_000,
 			SkipFastStorageUpgrade: true,
 		},
+		IavlV2Config: iavlv2.Config{
+			ClonePoolSize: iavlv2.DefaultConfig().ClonePoolSize,
+		},
 	}
 }
 
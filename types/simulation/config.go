@@ -1,16 +1,28 @@
 package simulation
 
-import "testing"
+import (
+	"slices"
+	"testing"
+	"time"
+)
 
 // Config contains the necessary configuration flags for the simulator
 type Config struct {
 	GenesisFile string // custom simulation genesis file; cannot be used with params file
 	ParamsFile  string // custom simulation params file which overrides any random params; cannot be used with genesis
+	WeightsFile string // custom simulation operation weights file, keyed by module name and operation name
+
+	ResumeGenesisFile string // exported genesis file to resume the simulation from, at its height; replaces the genesis generated
 
 	ExportParamsPath   string // custom file path to save the exported params JSON
 	ExportParamsHeight int    // height to which export the randomly generated params
 	ExportStatePath    string // custom file path to save the exported app state JSON
 	ExportStatsPath    string // custom file path to save the exported simulation statistics JSON
+	ExportGenesisPath  string // custom file path to save the exported genesis JSON once the simulation completes or fails
+	OperationLogPath   string // custom file path to save the log of the executed operations as JSON lines
+	ExportMetricsPath  string // custom file path to append a CSV row of the performance metrics of each run to
+	StateDiffPath      string // custom file path to save the state changes of each block of the v2 simulation as JSON lines
+	SeedCorpusPath     string // custom file path of a seed corpus, whose seeds run first and to which the failing seeds are appended
 
 	Seed               int64  // simulation random seed
 	InitialBlockHeight uint64 // initial block to start the simulation
@@ -18,9 +30,21 @@ type Config struct {
 	NumBlocks          uint64 // number of new blocks to simulate from the initial block height
 	BlockSize          int    // operations per block
 	ChainID            string // chain-id used on the simulation
+	PruneInterval      uint64 // number of blocks between two prunings of the v2 state commitment, using IAVL v2 trees; no pruning when 0
+
+	Modules []string // names of the modules whose operations are simulated; all modules when empty
+
+	AdversarialRate float64 // share of the operations drawn from the adversarial operations of the modules, in [0, 1); none when 0
+
+	AppHashCheckpoints map[uint64][]byte // expected app hashes by height, asserted after the commit of each listed height
 
-	Lean   bool // lean simulation log output
-	Commit bool // have the simulation commit
+	MaxDuration time.Duration // wall-clock duration after which the simulation stops before its next block; no limit when 0
+
+	Lean             bool // lean simulation log output
+	Commit           bool // have the simulation commit
+	CheckDeterminism bool // run the simulation twice and compare the app hash after each block
+	RecoverPanics    bool // fail the seed on a panic of the simulation, with its height, operation and stack, instead of aborting the test binary
+	ContinueOnPanic  bool // with RecoverPanics, log a panic of an operation as its failure and go on with the next operation instead of failing the seed
 
 	DBBackend   string // custom db backend type
 	BlockMaxGas int64  // custom max gas for block
@@ -29,6 +53,11 @@ type Config struct {
 	FauxMerkle  bool
 }
 
+// SimulatesModule returns true if the operations of the given module are simulated.
+func (c Config) SimulatesModule(name string) bool {
+	return len(c.Modules) == 0 || slices.Contains(c.Modules, name)
+}
+
 func (c Config) shallowCopy() Config {
 	return c
 }
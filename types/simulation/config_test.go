@@ -0,0 +1,17 @@
+package simulation_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cosmos/cosmos-sdk/types/simulation"
+)
+
+func TestConfigSimulatesModule(t *testing.T) {
+	require.True(t, simulation.Config{}.SimulatesModule("bank"))
+	cfg := simulation.Config{Modules: []string{"bank", "staking"}}
+	require.True(t, cfg.SimulatesModule("bank"))
+	require.True(t, cfg.SimulatesModule("staking"))
+	require.False(t, cfg.SimulatesModule("gov"))
+}
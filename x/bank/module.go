@@ -172,6 +172,11 @@ func (am AppModule) WeightedOperationsX(weights simsx.WeightSource, reg simsx.Re
 	reg.Add(weights.Get("msg_multisend", 10), simulation.MsgMultiSendFactory())
 }
 
+// AdversarialOperationsX registers the adversarial operations of the module, simulated with the adversarial rate.
+func (am AppModule) AdversarialOperationsX(weights simsx.WeightSource, reg simsx.Registry) {
+	reg.Add(weights.Get("msg_send_overspend", 100), simulation.MsgSendOverspendFactory())
+}
+
 // ModuleCodec implements `schema.HasModuleCodec` interface.
 // It allows the indexer to decode the module's KVPairUpdate.
 func (am AppModule) ModuleCodec() (schema.ModuleCodec, error) {
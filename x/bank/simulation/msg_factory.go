@@ -2,12 +2,14 @@ package simulation
 
 import (
 	"context"
+	"errors"
 	"slices"
 
 	"cosmossdk.io/x/bank/types"
 
 	"github.com/cosmos/cosmos-sdk/simsx"
 	sdk "github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
 )
 
 func MsgSendFactory() simsx.SimMsgFactoryFn[*types.MsgSend] {
@@ -19,6 +21,34 @@ func MsgSendFactory() simsx.SimMsgFactoryFn[*types.MsgSend] {
 	}
 }
 
+// MsgSendOverspendFactory creates adversarial sends of more than the spendable balance of the sender, which must
+// be rejected with an insufficient funds error.
+func MsgSendOverspendFactory() *simsx.ResultHandlingSimMsgFactory[*types.MsgSend] {
+	return simsx.NewSimMsgFactoryWithDeliveryResultHandler(func(ctx context.Context, testData *simsx.ChainDataSource, reporter simsx.SimulationReporter) ([]simsx.SimAccount, *types.MsgSend, simsx.SimDeliveryResultHandler) {
+		from := testData.AnyAccount(reporter, simsx.WithSpendableBalance())
+		to := testData.AnyAccount(reporter, simsx.ExcludeAccounts(from))
+		spendable := from.LiquidBalance().Coins
+		coins := from.LiquidBalance().RandSubsetCoins(reporter, simsx.WithSendEnabledCoins())
+		if reporter.IsSkipped() {
+			return nil, nil, nil
+		}
+		for i, c := range coins {
+			coins[i].Amount = spendable.AmountOf(c.Denom).AddRaw(1)
+		}
+		handler := func(err error) error {
+			switch {
+			case err == nil:
+				return errors.New("send of more than the spendable balance was delivered")
+			case errors.Is(err, sdkerrors.ErrInsufficientFunds):
+				return nil
+			default:
+				return err
+			}
+		}
+		return []simsx.SimAccount{from}, types.NewMsgSend(from.AddressBech32, to.AddressBech32, coins), handler
+	})
+}
+
 func MsgMultiSendFactory() simsx.SimMsgFactoryFn[*types.MsgMultiSend] {
 	return func(ctx context.Context, testData *simsx.ChainDataSource, reporter simsx.SimulationReporter) ([]simsx.SimAccount, *types.MsgMultiSend) {
 		r := testData.Rand()
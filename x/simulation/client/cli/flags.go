@@ -2,6 +2,7 @@ package cli
 
 import (
 	"flag"
+	"strings"
 	"time"
 
 	"github.com/cosmos/cosmos-sdk/types/simulation"
@@ -13,17 +14,31 @@ const DefaultSeedValue = 42
 var (
 	FlagGenesisFileValue        string
 	FlagParamsFileValue         string
+	FlagWeightsFileValue        string
+	FlagResumeGenesisFileValue  string
 	FlagExportParamsPathValue   string
 	FlagExportParamsHeightValue int
 	FlagExportStatePathValue    string
 	FlagExportStatsPathValue    string
+	FlagExportGenesisPathValue  string
+	FlagOperationLogPathValue   string
+	FlagExportMetricsPathValue  string
+	FlagStateDiffPathValue      string
+	FlagSeedCorpusPathValue     string
 	FlagSeedValue               int64
 	FlagInitialBlockHeightValue uint64
 	FlagNumBlocksValue          uint64
+	FlagMaxDurationValue        time.Duration
 	FlagBlockSizeValue          int
+	FlagPruneIntervalValue      uint64
 	FlagLeanValue               bool
 	FlagCommitValue             bool
+	FlagCheckDeterminismValue   bool
+	FlagRecoverPanicsValue      bool
+	FlagContinueOnPanicValue    bool
 	FlagDBBackendValue          string
+	FlagModulesValue            string
+	FlagAdversarialRateValue    float64
 
 	FlagEnabledValue     bool
 	FlagVerboseValue     bool
@@ -37,15 +52,29 @@ func GetSimulatorFlags() {
 	// config fields
 	flag.StringVar(&FlagGenesisFileValue, "Genesis", "", "custom simulation genesis file; cannot be used with params file")
 	flag.StringVar(&FlagParamsFileValue, "Params", "", "custom simulation params file which overrides any random params; cannot be used with genesis")
+	flag.StringVar(&FlagWeightsFileValue, "Weights", "", "custom simulation operation weights file, keyed by module name and operation name")
+	flag.StringVar(&FlagResumeGenesisFileValue, "ResumeGenesis", "", "exported genesis file to resume the simulation from, at its height; replaces the genesis generated")
 	flag.StringVar(&FlagExportParamsPathValue, "ExportParamsPath", "", "custom file path to save the exported params JSON")
 	flag.IntVar(&FlagExportParamsHeightValue, "ExportParamsHeight", 0, "height to which export the randomly generated params")
 	flag.StringVar(&FlagExportStatePathValue, "ExportStatePath", "", "custom file path to save the exported app state JSON")
+	flag.StringVar(&FlagExportGenesisPathValue, "ExportGenesisPath", "", "custom file path to save the exported genesis JSON once the simulation completes or fails, suffixed with the seed")
+	flag.StringVar(&FlagOperationLogPathValue, "OperationLogPath", "", "custom file path to save the log of the executed operations as JSON lines, suffixed with the seed")
+	flag.StringVar(&FlagExportMetricsPathValue, "ExportMetricsPath", "", "custom file path to append a CSV row of the performance metrics of each run to")
+	flag.StringVar(&FlagStateDiffPathValue, "StateDiffPath", "", "custom file path to save the state changes of each block of the v2 simulation as JSON lines, suffixed with the seed")
+	flag.StringVar(&FlagSeedCorpusPathValue, "SeedCorpus", "", "custom file path of a seed corpus, one seed per line, whose seeds run first and to which the failing seeds are appended")
 	flag.Int64Var(&FlagSeedValue, "Seed", DefaultSeedValue, "simulation random seed")
 	flag.Uint64Var(&FlagInitialBlockHeightValue, "InitialBlockHeight", 1, "initial block to start the simulation")
 	flag.Uint64Var(&FlagNumBlocksValue, "NumBlocks", 500, "number of new blocks to simulate from the initial block height")
+	flag.DurationVar(&FlagMaxDurationValue, "MaxDuration", 0, "wall-clock duration after which the simulation stops before its next block, e.g. 2m; no limit when 0")
 	flag.IntVar(&FlagBlockSizeValue, "BlockSize", 200, "operations per block")
+	flag.Uint64Var(&FlagPruneIntervalValue, "PruneInterval", 0, "number of blocks between two prunings of the v2 state commitment, using IAVL v2 trees; no pruning when 0")
 	flag.BoolVar(&FlagLeanValue, "Lean", false, "lean simulation log output")
 	flag.BoolVar(&FlagCommitValue, "Commit", true, "have the simulation commit")
+	flag.StringVar(&FlagModulesValue, "Modules", "", "comma separated names of the modules whose operations are simulated, all modules by default")
+	flag.Float64Var(&FlagAdversarialRateValue, "AdversarialRate", 0, "share of the operations drawn from the adversarial operations of the modules, in [0, 1); none when 0")
+	flag.BoolVar(&FlagCheckDeterminismValue, "CheckDeterminism", false, "run the simulation twice and compare the app hash after each block")
+	flag.BoolVar(&FlagRecoverPanicsValue, "RecoverPanics", false, "fail the seed on a panic of the simulation, with its height, operation and stack, instead of aborting the test binary")
+	flag.BoolVar(&FlagContinueOnPanicValue, "ContinueOnPanic", false, "with RecoverPanics, log a panic of an operation as its failure and go on with the next operation instead of failing the seed")
 	flag.StringVar(&FlagDBBackendValue, "DBBackend", "memdb", "custom db backend type: goleveldb, pebbledb, memdb")
 
 	// simulation flags
@@ -61,18 +90,43 @@ func NewConfigFromFlags() simulation.Config {
 	return simulation.Config{
 		GenesisFile:        FlagGenesisFileValue,
 		ParamsFile:         FlagParamsFileValue,
+		WeightsFile:        FlagWeightsFileValue,
+		ResumeGenesisFile:  FlagResumeGenesisFileValue,
 		ExportParamsPath:   FlagExportParamsPathValue,
 		ExportParamsHeight: FlagExportParamsHeightValue,
 		ExportStatePath:    FlagExportStatePathValue,
 		ExportStatsPath:    FlagExportStatsPathValue,
+		ExportGenesisPath:  FlagExportGenesisPathValue,
+		OperationLogPath:   FlagOperationLogPathValue,
+		ExportMetricsPath:  FlagExportMetricsPathValue,
+		StateDiffPath:      FlagStateDiffPathValue,
+		SeedCorpusPath:     FlagSeedCorpusPathValue,
 		Seed:               FlagSeedValue,
 		InitialBlockHeight: FlagInitialBlockHeightValue,
 		GenesisTime:        FlagGenesisTimeValue,
 		NumBlocks:          FlagNumBlocksValue,
+		MaxDuration:        FlagMaxDurationValue,
 		BlockSize:          FlagBlockSizeValue,
+		PruneInterval:      FlagPruneIntervalValue,
 		Lean:               FlagLeanValue,
 		Commit:             FlagCommitValue,
+		CheckDeterminism:   FlagCheckDeterminismValue,
+		RecoverPanics:      FlagRecoverPanicsValue,
+		ContinueOnPanic:    FlagContinueOnPanicValue,
 		DBBackend:          FlagDBBackendValue,
 		FauxMerkle:         FlagFauxMerkle,
+		Modules:            parseModules(FlagModulesValue),
+		AdversarialRate:    FlagAdversarialRateValue,
 	}
 }
+
+// parseModules returns the module names of a comma separated list.
+func parseModules(s string) []string {
+	var modules []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			modules = append(modules, name)
+		}
+	}
+	return modules
+}
@@ -0,0 +1,41 @@
+package simulation
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
+)
+
+// ControlPanic is implemented by the panic values stopping a simulation on purpose, e.g. to replay it up to a step,
+// which are not failures: they are panicked again instead of being recovered as a *PanicError.
+type ControlPanic interface {
+	SimulationControl()
+}
+
+// PanicError is a panic of a simulation recovered as an error, see simtypes.Config.RecoverPanics.
+type PanicError struct {
+	Seed   int64
+	Height int64
+	// Op is the operation which panicked, empty if the panic did not happen in an operation.
+	Op    string
+	Value any
+	Stack []byte
+}
+
+// recoverPanic returns the value recovered from a panic of the simulation as a *PanicError, panicking again with
+// it if it is a ControlPanic.
+func recoverPanic(config simtypes.Config, height int64, op string, value any) *PanicError {
+	if _, ok := value.(ControlPanic); ok {
+		panic(value)
+	}
+	return &PanicError{Seed: config.Seed, Height: height, Op: op, Value: value, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	where := fmt.Sprintf("height %d", e.Height)
+	if e.Op != "" {
+		where += ", " + e.Op
+	}
+	return fmt.Sprintf("simulation panicked at %s with seed %d: %v\n%s", where, e.Seed, e.Value, e.Stack)
+}
@@ -18,6 +18,7 @@ import (
 	"cosmossdk.io/core/address"
 	"cosmossdk.io/core/header"
 	corelog "cosmossdk.io/core/log"
+	storetypes "cosmossdk.io/store/types"
 
 	"github.com/cosmos/cosmos-sdk/baseapp"
 	"github.com/cosmos/cosmos-sdk/codec"
@@ -102,6 +103,14 @@ func SimulateFromSeedX(
 	}()
 	// in case we have to end early, don't os.Exit so that we can run cleanup code.
 	testingMode, _, b := getTestingMode(tb)
+	blockHeight := int64(config.InitialBlockHeight)
+	if config.RecoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recoverPanic(config, blockHeight, "", r)
+			}
+		}()
+	}
 
 	r := rand.New(NewByteSource(config.FuzzSeed, config.Seed))
 	params := RandomParams(r)
@@ -140,7 +149,6 @@ func SimulateFromSeedX(
 		pastVoteInfos      [][]abci.VoteInfo
 		timeOperationQueue []simtypes.FutureOperation
 
-		blockHeight     = int64(config.InitialBlockHeight)
 		proposerAddress = validators.randomProposer(r)
 		opCount         = 0
 	)
@@ -196,6 +204,11 @@ func SimulateFromSeedX(
 	}
 
 	for blockHeight < int64(config.NumBlocks+config.InitialBlockHeight) {
+		if config.MaxDuration > 0 && time.Since(startTime) >= config.MaxDuration {
+			logger.Info("Simulation stopped early as the time limit was reached", "height", blockHeight,
+				"blocks", blockHeight-int64(config.InitialBlockHeight), "opsCount", opCount, "max-duration", config.MaxDuration)
+			break
+		}
 		pastTimes = append(pastTimes, blockTime)
 		pastVoteInfos = append(pastVoteInfos, finalizeBlockReq.DecidedLastCommit.Votes)
 
@@ -253,6 +266,9 @@ func SimulateFromSeedX(
 			if _, err := app.Commit(); err != nil {
 				return params, accs, fmt.Errorf("commit failed at height %d: %w", blockHeight, err)
 			}
+			if err := checkAppHash(config, app.LastCommitID()); err != nil {
+				return params, accs, err
+			}
 		}
 
 		if proposerAddress == nil {
@@ -339,7 +355,13 @@ func createBlockSimulator(tb testing.TB, printProgress bool, w io.Writer, params
 			// NOTE: the Rand 'r' should not be used here.
 			opAndR := opAndRz[i]
 			op, r2 := opAndR.op, opAndR.rand
-			opMsg, futureOps, err := op(r2, app, ctx, accounts, config.ChainID)
+			opMsg, futureOps, err := runOperation(config, header.Height, fmt.Sprintf("operation %d/%d", i, blocksize), op, r2, app, ctx, accounts)
+			var panicErr *PanicError
+			if config.ContinueOnPanic && errors.As(err, &panicErr) {
+				// the panic only fails the operation
+				tb.Log(panicErr)
+				opMsg, err = simtypes.NoOpMsg("simulation", "panic", fmt.Sprintf("%s panicked: %v", panicErr.Op, panicErr.Value)), nil
+			}
 			opMsg.LogEvent(event)
 
 			if !config.Lean || opMsg.OK {
@@ -368,6 +390,31 @@ Comment: %s`,
 	}
 }
 
+// checkAppHash returns an error if the app hash of the given commit differs from its checkpoint in the config.
+func checkAppHash(config simtypes.Config, id storetypes.CommitID) error {
+	exp, ok := config.AppHashCheckpoints[uint64(id.Version)]
+	if !ok || bytes.Equal(exp, id.Hash) {
+		return nil
+	}
+	return fmt.Errorf("app hash mismatch at height %d: expected %X, got %X", id.Version, exp, id.Hash)
+}
+
+// runOperation runs op, its panic being returned as a *PanicError if config.RecoverPanics is set, unless it is a
+// ControlPanic.
+func runOperation(
+	config simtypes.Config, height int64, name string, op simtypes.Operation,
+	r *rand.Rand, app simtypes.AppEntrypoint, ctx sdk.Context, accounts []simtypes.Account,
+) (opMsg simtypes.OperationMsg, futureOps []simtypes.FutureOperation, err error) {
+	if config.RecoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recoverPanic(config, height, name, r)
+			}
+		}()
+	}
+	return op(r, app, ctx, accounts, config.ChainID)
+}
+
 func runQueuedOperations(tb testing.TB, queueOps map[int][]simtypes.Operation,
 	blockTime time.Time, height int, r *rand.Rand, app *baseapp.BaseApp,
 	ctx sdk.Context, accounts []simtypes.Account, logWriter LogWriter,
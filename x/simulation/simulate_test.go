@@ -8,6 +8,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	storetypes "cosmossdk.io/store/types"
+
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	simtypes "github.com/cosmos/cosmos-sdk/types/simulation"
 )
@@ -55,3 +57,45 @@ func TestRunQueuedTimeOperations(t *testing.T) {
 		})
 	}
 }
+
+func TestRunOperationRecoverPanics(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	panicOp := func(*rand.Rand, simtypes.AppEntrypoint, sdk.Context, []simtypes.Account, string) (simtypes.OperationMsg, []simtypes.FutureOperation, error) {
+		panic("boom")
+	}
+
+	// panics pass through by default
+	assert.PanicsWithValue(t, "boom", func() {
+		_, _, _ = runOperation(simtypes.Config{}, 3, "operation 1/2", panicOp, r, nil, sdk.Context{}, nil)
+	})
+
+	_, _, err := runOperation(simtypes.Config{Seed: 7, RecoverPanics: true}, 3, "operation 1/2", panicOp, r, nil, sdk.Context{}, nil)
+	var panicErr *PanicError
+	require.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, int64(7), panicErr.Seed)
+	assert.Equal(t, int64(3), panicErr.Height)
+	assert.Equal(t, "operation 1/2", panicErr.Op)
+	assert.Equal(t, "boom", panicErr.Value)
+	assert.Contains(t, string(panicErr.Stack), "TestRunOperationRecoverPanics")
+	assert.Contains(t, err.Error(), "simulation panicked at height 3, operation 1/2 with seed 7: boom")
+
+	// control panics are not failures
+	stopOp := func(*rand.Rand, simtypes.AppEntrypoint, sdk.Context, []simtypes.Account, string) (simtypes.OperationMsg, []simtypes.FutureOperation, error) {
+		panic(stopPanic{})
+	}
+	assert.PanicsWithValue(t, stopPanic{}, func() {
+		_, _, _ = runOperation(simtypes.Config{Seed: 7, RecoverPanics: true}, 3, "operation 1/2", stopOp, r, nil, sdk.Context{}, nil)
+	})
+}
+
+type stopPanic struct{}
+
+func (stopPanic) SimulationControl() {}
+
+func TestCheckAppHash(t *testing.T) {
+	config := simtypes.Config{AppHashCheckpoints: map[uint64][]byte{2: {0xab}}}
+	require.NoError(t, checkAppHash(config, storetypes.CommitID{Version: 1, Hash: []byte{0x01}}))
+	require.NoError(t, checkAppHash(config, storetypes.CommitID{Version: 2, Hash: []byte{0xab}}))
+	require.EqualError(t, checkAppHash(config, storetypes.CommitID{Version: 2, Hash: []byte{0x01}}),
+		"app hash mismatch at height 2: expected AB, got 01")
+}